@@ -0,0 +1,312 @@
+/*
+  Rimu document tree.
+*/
+
+package ast
+
+import "strconv"
+
+// Node is a document tree node.
+type Node interface {
+	node()
+}
+
+// BlockAttributes are the HTML attributes assigned to a block element by a
+// preceding Block Attributes element.
+type BlockAttributes struct {
+	Classes    string // Space separated HTML class names.
+	ID         string // HTML element id.
+	CSS        string // HTML CSS styles.
+	Attributes string // Other HTML element attributes.
+}
+
+func (a *BlockAttributes) blockAttributes() *BlockAttributes { return a }
+
+// IsBlank returns true if there are no attributes.
+func (a BlockAttributes) IsBlank() bool {
+	return a == BlockAttributes{}
+}
+
+/*
+  Block nodes.
+*/
+
+// Document is the root node.
+type Document struct {
+	Children []Node
+	Metadata map[string]string // Front matter fields (nil if there is no front matter).
+}
+
+// HTML is raw HTML text. Block Attributes are injected into the first HTML tag.
+type HTML struct {
+	BlockAttributes
+	Text string
+}
+
+// Newline separates top-level rendered blocks.
+type Newline struct{}
+
+// Header is a Header line block.
+type Header struct {
+	BlockAttributes
+	Level    int    // 1..6
+	Number   string // Section number e.g. "2.3.1" (blank if the header is not numbered).
+	Children []Node
+}
+
+// Image is a block image line block.
+type Image struct {
+	BlockAttributes
+	Src string
+	Alt string
+}
+
+// DelimitedBlock is a delimited block element.
+// The Block Attributes of "html" blocks are injected into the block content when
+// it is parsed.
+type DelimitedBlock struct {
+	BlockAttributes
+	Name     string // Delimited Block definition name e.g. "code", "division".
+	OpenTag  string // Opening tag (without Block Attributes).
+	CloseTag string
+	Children []Node
+}
+
+// AdmonitionKind is the kind of an Admonition.
+type AdmonitionKind string
+
+// Admonition kinds.
+const (
+	AdmonitionNote      AdmonitionKind = "note"
+	AdmonitionTip       AdmonitionKind = "tip"
+	AdmonitionImportant AdmonitionKind = "important"
+	AdmonitionCaution   AdmonitionKind = "caution"
+	AdmonitionWarning   AdmonitionKind = "warning"
+	AdmonitionDanger    AdmonitionKind = "danger"
+)
+
+// Admonition is an admonition (callout) delimited block.
+type Admonition struct {
+	BlockAttributes
+	Kind     AdmonitionKind
+	Title    []Node // Title text (defaults to the capitalized kind).
+	Children []Node
+}
+
+// Paragraph is a normal paragraph (the "paragraph" delimited block).
+type Paragraph struct {
+	DelimitedBlock
+}
+
+// List is an unordered, ordered or definition list.
+type List struct {
+	BlockAttributes
+	ID       string // List ID e.g. "-", "..", "::".
+	OpenTag  string // Opening tag (without Block Attributes).
+	CloseTag string
+	Items    []*ListItem
+}
+
+// ListItem is a list item. Term is only set in definition lists.
+type ListItem struct {
+	BlockAttributes
+	OpenTag        string // Opening tag (without Block Attributes).
+	CloseTag       string
+	Term           []Node
+	TermAttributes BlockAttributes
+	TermOpenTag    string
+	TermCloseTag   string
+	Task           bool   // Task list item (rendered with a checkbox).
+	Checked        bool   // Task list item is checked.
+	Children       []Node // Item text followed by optional attached block and child list.
+}
+
+// Table is a pipe table delimited block.
+type Table struct {
+	BlockAttributes
+	Align  []string       // Column alignments: "", "left", "center" or "right".
+	Header []*TableCell   // Header row cells (nil if there is no header row).
+	Rows   [][]*TableCell // Body rows.
+}
+
+// TableCell is a table header or body cell.
+type TableCell struct {
+	Children []Node
+}
+
+// Footnotes is the document's footnotes section.
+type Footnotes struct {
+	Notes []*Footnote // In order of first reference.
+}
+
+// Footnote is a footnote definition.
+type Footnote struct {
+	Label    string // Footnote reference label e.g. "note" in [^note].
+	ID       string // HTML id of the footnote.
+	RefID    string // HTML id of the first footnote reference.
+	Children []Node
+}
+
+/*
+  Inline nodes.
+*/
+
+// Text is plain text, HTML special characters are escaped when rendered.
+type Text struct {
+	Text string
+}
+
+// Quote is quoted text e.g. *emphasised*.
+type Quote struct {
+	Quote    string // Quote characters.
+	OpenTag  string
+	CloseTag string
+	Children []Node
+}
+
+// Replacement is text matched by a Replacement definition.
+type Replacement struct {
+	Source string // Matched source text.
+	HTML   string // Replacement HTML.
+}
+
+func (*Document) node()       {}
+func (*HTML) node()           {}
+func (*Newline) node()        {}
+func (*Header) node()         {}
+func (*Image) node()          {}
+func (*DelimitedBlock) node() {}
+func (*Paragraph) node()      {}
+func (*Admonition) node()     {}
+func (*List) node()           {}
+func (*ListItem) node()       {}
+func (*Table) node()          {}
+func (*TableCell) node()      {}
+func (*Footnotes) node()      {}
+func (*Footnote) node()       {}
+func (*Text) node()           {}
+func (*Quote) node()          {}
+func (*Replacement) node()    {}
+
+// AttributesOf returns a pointer to the node's Block Attributes or nil if the
+// node does not have Block Attributes.
+func AttributesOf(n Node) *BlockAttributes {
+	if b, ok := n.(interface{ blockAttributes() *BlockAttributes }); ok {
+		return b.blockAttributes()
+	}
+	return nil
+}
+
+// Children returns the child nodes of n.
+func Children(n Node) []Node {
+	switch n := n.(type) {
+	case *Document:
+		return n.Children
+	case *Header:
+		return n.Children
+	case *DelimitedBlock:
+		return n.Children
+	case *Paragraph:
+		return n.Children
+	case *Admonition:
+		return append(append([]Node{}, n.Title...), n.Children...)
+	case *List:
+		result := make([]Node, len(n.Items))
+		for i, item := range n.Items {
+			result[i] = item
+		}
+		return result
+	case *ListItem:
+		return append(append([]Node{}, n.Term...), n.Children...)
+	case *Table:
+		var result []Node
+		for _, cell := range n.Header {
+			result = append(result, cell)
+		}
+		for _, row := range n.Rows {
+			for _, cell := range row {
+				result = append(result, cell)
+			}
+		}
+		return result
+	case *TableCell:
+		return n.Children
+	case *Footnotes:
+		result := make([]Node, len(n.Notes))
+		for i, note := range n.Notes {
+			result[i] = note
+		}
+		return result
+	case *Footnote:
+		return n.Children
+	case *Quote:
+		return n.Children
+	}
+	return nil
+}
+
+// Walk traverses the tree rooted at n in depth-first order calling f for each node.
+// Children of a node are skipped if f returns false.
+func Walk(n Node, f func(n Node) bool) {
+	if !f(n) {
+		return
+	}
+	for _, child := range Children(n) {
+		Walk(child, f)
+	}
+}
+
+// IsBlank returns true if n renders no HTML.
+func IsBlank(n Node) bool {
+	switch n := n.(type) {
+	case *HTML:
+		return n.Text == ""
+	case *Text:
+		return n.Text == ""
+	case *Replacement:
+		return n.HTML == ""
+	case *Paragraph:
+		return IsBlank(&n.DelimitedBlock)
+	case *DelimitedBlock:
+		// Division tags are dropped if the opening div has no attributes.
+		dropped := n.Name == "division" && Inject(n.OpenTag, n.BlockAttributes) == "<div>"
+		if !dropped && (n.OpenTag != "" || n.CloseTag != "") {
+			return false
+		}
+	case *Document, *TableCell:
+	default:
+		return false
+	}
+	for _, child := range Children(n) {
+		if !IsBlank(child) {
+			return false
+		}
+	}
+	return true
+}
+
+// OpenTag returns the opening HTML tag of n without Block Attributes (blank if
+// n does not have an opening tag). The text of HTML nodes is returned.
+func OpenTag(n Node) string {
+	switch n := n.(type) {
+	case *HTML:
+		return n.Text
+	case *Header:
+		return "<h" + strconv.Itoa(n.Level) + ">"
+	case *Image:
+		return "<img>"
+	case *DelimitedBlock:
+		return n.OpenTag
+	case *Paragraph:
+		return n.OpenTag
+	case *Admonition:
+		return "<aside>"
+	case *List:
+		return n.OpenTag
+	case *ListItem:
+		return n.OpenTag
+	case *Table:
+		return "<table>"
+	}
+	return ""
+}
@@ -0,0 +1,176 @@
+package ast
+
+import (
+	"testing"
+
+	"github.com/srackham/go-rimu/v11/internal/assert"
+)
+
+func TestRenderHTML(t *testing.T) {
+	doc := &Document{Children: []Node{
+		&Header{
+			BlockAttributes: BlockAttributes{ID: "Intro"},
+			Level:           2,
+			Children:        []Node{&Text{Text: "A & B"}},
+		},
+		&Newline{},
+		&Paragraph{DelimitedBlock{
+			Name:     "paragraph",
+			OpenTag:  "<p>",
+			CloseTag: "</p>",
+			Children: []Node{
+				&Quote{Quote: "*", OpenTag: "<em>", CloseTag: "</em>", Children: []Node{&Text{Text: "x"}}},
+				&Replacement{Source: "(C)", HTML: "&copy;"},
+			},
+		}},
+		&DelimitedBlock{Name: "division", OpenTag: "<div>", CloseTag: "</div>", Children: []Node{&HTML{Text: "<hr>"}}},
+		&List{
+			BlockAttributes: BlockAttributes{Classes: "c"},
+			OpenTag:         "<dl>",
+			CloseTag:        "</dl>",
+			Items: []*ListItem{{
+				OpenTag:      "<dd>",
+				CloseTag:     "</dd>",
+				TermOpenTag:  "<dt>",
+				TermCloseTag: "</dt>",
+				Term:         []Node{&Text{Text: "t"}},
+				Children:     []Node{&Text{Text: "d"}},
+			}},
+		},
+		&Image{BlockAttributes: BlockAttributes{CSS: "width:1px"}, Src: "a.png", Alt: "<a>"},
+	}}
+	want := `<h2 id="intro">A &amp; B</h2>` + "\n" +
+		`<p><em>x</em>&copy;</p>` +
+		`<hr>` +
+		`<dl class="c"><dt>t</dt><dd>d</dd></dl>` +
+		`<img style="width:1px" src="a.png" alt="&lt;a&gt;">`
+	assert.Equal(t, want, RenderHTML(doc))
+}
+
+func TestRenderText(t *testing.T) {
+	doc := &Document{Children: []Node{
+		&Header{Level: 2, Number: "1", Children: []Node{&Text{Text: "A & B"}}},
+		&Newline{},
+		&Paragraph{DelimitedBlock{
+			Name: "paragraph",
+			Children: []Node{
+				&Quote{Quote: "*", OpenTag: "<em>", CloseTag: "</em>", Children: []Node{&Text{Text: "one two\nthree"}}},
+				&Replacement{Source: "(C)", HTML: "&copy;"},
+				&Replacement{Source: "<x|y>", HTML: `<a href="x">y</a>`},
+				&Replacement{Source: " \\", HTML: "<br>"},
+				&Replacement{Source: "<x>", HTML: `<a href="x">x</a>`},
+			},
+		}},
+		&List{
+			OpenTag: "<ol>",
+			Items: []*ListItem{
+				{Children: []Node{&Text{Text: "a"}, &List{OpenTag: "<ul>", Items: []*ListItem{{Task: true, Children: []Node{&Text{Text: "b"}}}}}}},
+				{Children: []Node{&Text{Text: "c"}}},
+			},
+		},
+		&DelimitedBlock{Name: "code", Children: []Node{&Text{Text: "x <\n  y"}}},
+		&HTML{Text: "<div><p>&lt;1&gt;</p><p>2</p></div>"},
+	}}
+	want := "1 A & B\n-------\n\none two\nthree©y [1]\nx\n\n" +
+		"1. a\n   - [ ] b\n2. c\n\n    x <\n      y\n\n<1>\n2\n\n[1] x"
+	assert.Equal(t, want, RenderText(doc, 12))
+}
+
+func TestWrap(t *testing.T) {
+	tests := []struct {
+		text  string
+		width int
+		want  []string
+	}{
+		{"", 10, nil},
+		{"a b c", 0, []string{"a b c"}},
+		{"aaa bbb ccc", 7, []string{"aaa bbb", "ccc"}},
+		{"aaaaaaaaaa b", 4, []string{"aaaaaaaaaa", "b"}},
+		{" a \n b ", 10, []string{"a b"}},
+		{"a" + hardBreak + "b", 10, []string{"a", "b"}},
+	}
+	for _, tt := range tests {
+		assert.EqualValues(t, tt.want, wrap(tt.text, tt.width))
+	}
+}
+
+func TestWalk(t *testing.T) {
+	doc := &Document{Children: []Node{
+		&Header{Level: 1, Children: []Node{&Text{Text: "a"}}},
+		&List{Items: []*ListItem{{Children: []Node{&Text{Text: "b"}}}}},
+	}}
+	text := ""
+	Walk(doc, func(n Node) bool {
+		if n, ok := n.(*Text); ok {
+			text += n.Text
+		}
+		_, isList := n.(*List)
+		return !isList
+	})
+	assert.Equal(t, "a", text)
+	assert.True(t, AttributesOf(doc.Children[0]) != nil)
+	assert.True(t, AttributesOf(&Text{}) == nil)
+}
+
+func TestIsBlank(t *testing.T) {
+	tests := []Node{
+		&HTML{},
+		&HTML{Text: "<hr>"},
+		&HTML{BlockAttributes: BlockAttributes{Classes: "foo"}},
+		&Header{Level: 1},
+		&DelimitedBlock{Name: "html", Children: []Node{&HTML{}}},
+		&DelimitedBlock{Name: "division", OpenTag: "<div>", CloseTag: "</div>", Children: []Node{&Text{}}},
+		&DelimitedBlock{Name: "division", OpenTag: "<div>", CloseTag: "</div>", BlockAttributes: BlockAttributes{ID: "x"}},
+		&DelimitedBlock{Name: "division", OpenTag: "<div>", CloseTag: "</div>", Children: []Node{&Newline{}}},
+		&Paragraph{DelimitedBlock{OpenTag: "<p>", CloseTag: "</p>"}},
+		&Document{Children: []Node{&Replacement{}, &Text{Text: ""}}},
+		&Document{Children: []Node{&Replacement{HTML: "&amp;"}}},
+	}
+	for _, n := range tests {
+		assert.Equal(t, RenderHTML(n) == "", IsBlank(n))
+	}
+}
+
+func TestInject(t *testing.T) {
+	tests := []struct {
+		tag   string
+		attrs BlockAttributes
+		want  string
+	}{
+		{"", BlockAttributes{Classes: "foo"}, ""},
+		{"<p>", BlockAttributes{}, "<p>"},
+		{`<p class="bar">`, BlockAttributes{Classes: "foo"}, `<p class="foo bar">`},
+		{`<p id="x">`, BlockAttributes{ID: "y"}, `<p id="x">`},
+		{`<p style="color:red">`, BlockAttributes{CSS: "width:1px"}, `<p style="color:red; width:1px">`},
+		{"<p>", BlockAttributes{Attributes: `title="t"`}, `<p title="t">`},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, Inject(tt.tag, tt.attrs))
+	}
+}
+
+func TestEscapeMarkdown(t *testing.T) {
+	tests := []struct {
+		text      string
+		lineStart bool
+		want      string
+	}{
+		{"", true, ""},
+		{"plain text", true, "plain text"},
+		{"*a* _b_ `c` [d] ~e~ \\", false, "\\*a\\* \\_b\\_ \\`c\\` \\[d\\] \\~e\\~ \\\\"},
+		{"snake_case _x", false, `snake_case \_x`},
+		{"a < b <c> &amp; & c", false, `a < b \<c> \&amp; & c`},
+		{"# a\n- b\n+ c\n> d\n1. e\n2) f\n10 g", true, "\\# a\n\\- b\n\\+ c\n\\> d\n1\\. e\n2\\) f\n10 g"},
+		{"# a", false, "# a"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, escapeMarkdown(tt.text, tt.lineStart))
+	}
+}
+
+func TestCodeSpan(t *testing.T) {
+	assert.Equal(t, "`x`", codeSpan("x"))
+	assert.Equal(t, "``a`b``", codeSpan("a`b"))
+	assert.Equal(t, "`` `x ``", codeSpan("`x"))
+	assert.Equal(t, "`  x  `", codeSpan(" x "))
+}
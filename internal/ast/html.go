@@ -0,0 +1,204 @@
+package ast
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/srackham/go-rimu/v11/internal/utils/str"
+)
+
+// RenderHTML returns the HTML rendered from nodes.
+func RenderHTML(nodes ...Node) string {
+	var b strings.Builder
+	for _, n := range nodes {
+		writeHTML(&b, n)
+	}
+	return b.String()
+}
+
+func writeHTML(b *strings.Builder, n Node) {
+	switch n := n.(type) {
+	case *Document:
+		b.WriteString(RenderHTML(n.Children...))
+	case *HTML:
+		b.WriteString(Inject(n.Text, n.BlockAttributes))
+	case *Newline:
+		b.WriteString("\n")
+	case *Header:
+		tag := fmt.Sprintf("h%d", n.Level)
+		number := ""
+		if n.Number != "" {
+			number = str.ReplaceSpecialChars(n.Number) + " "
+		}
+		b.WriteString(Inject("<"+tag+">"+number+RenderHTML(n.Children...)+"</"+tag+">", n.BlockAttributes))
+	case *Image:
+		b.WriteString(Inject(`<img src="`+str.ReplaceSpecialChars(n.Src)+`" alt="`+str.ReplaceSpecialChars(n.Alt)+`">`, n.BlockAttributes))
+	case *DelimitedBlock:
+		writeDelimitedBlock(b, n)
+	case *Paragraph:
+		writeDelimitedBlock(b, &n.DelimitedBlock)
+	case *Admonition:
+		b.WriteString(Inject(admonitionTag(n.Kind), n.BlockAttributes))
+		b.WriteString(`<p class="admonition-title">` + RenderHTML(n.Title...) + "</p>\n")
+		b.WriteString(RenderHTML(n.Children...))
+		b.WriteString("</aside>")
+	case *List:
+		b.WriteString(Inject(n.OpenTag, n.BlockAttributes))
+		for _, item := range n.Items {
+			writeHTML(b, item)
+		}
+		b.WriteString(n.CloseTag)
+	case *ListItem:
+		if n.TermOpenTag != "" {
+			b.WriteString(Inject(n.TermOpenTag, n.TermAttributes))
+			b.WriteString(RenderHTML(n.Term...))
+			b.WriteString(n.TermCloseTag)
+		}
+		b.WriteString(Inject(n.OpenTag, n.BlockAttributes))
+		if n.Task {
+			if n.Checked {
+				b.WriteString(`<input type="checkbox" checked disabled> `)
+			} else {
+				b.WriteString(`<input type="checkbox" disabled> `)
+			}
+		}
+		b.WriteString(RenderHTML(n.Children...))
+		b.WriteString(n.CloseTag)
+	case *Table:
+		writeTable(b, n)
+	case *TableCell:
+		b.WriteString(RenderHTML(n.Children...))
+	case *Footnotes:
+		b.WriteString(`<section class="footnotes"><ol>`)
+		for _, note := range n.Notes {
+			writeHTML(b, note)
+		}
+		b.WriteString("</ol></section>")
+	case *Footnote:
+		b.WriteString(`<li id="` + n.ID + `">`)
+		b.WriteString(RenderHTML(n.Children...))
+		b.WriteString(` <a href="#` + n.RefID + `" class="footnote-backref">&#8617;</a></li>`)
+	case *Text:
+		b.WriteString(str.ReplaceSpecialChars(n.Text))
+	case *Quote:
+		b.WriteString(n.OpenTag)
+		b.WriteString(RenderHTML(n.Children...))
+		b.WriteString(n.CloseTag)
+	case *Replacement:
+		b.WriteString(n.HTML)
+	default:
+		panic(fmt.Sprintf("illegal node type: %T", n))
+	}
+}
+
+func writeDelimitedBlock(b *strings.Builder, n *DelimitedBlock) {
+	opentag := n.OpenTag
+	closetag := n.CloseTag
+	opentag = Inject(opentag, n.BlockAttributes)
+	if n.Name == "division" && opentag == "<div>" {
+		// Drop div tags if the opening div has no attributes.
+		opentag = ""
+		closetag = ""
+	}
+	b.WriteString(opentag)
+	b.WriteString(RenderHTML(n.Children...))
+	b.WriteString(closetag)
+}
+
+// admonitionTag returns the opening aside tag of an admonition. Cautions,
+// warnings and dangers are not announced as notes.
+func admonitionTag(kind AdmonitionKind) string {
+	switch kind {
+	case AdmonitionCaution, AdmonitionWarning, AdmonitionDanger:
+		return `<aside class="admonition ` + string(kind) + `">`
+	default:
+		return `<aside class="admonition ` + string(kind) + `" role="note">`
+	}
+}
+
+func writeTable(b *strings.Builder, n *Table) {
+	writeRow := func(cells []*TableCell, tag string) {
+		b.WriteString("<tr>")
+		for i, cell := range cells {
+			b.WriteString("<" + tag)
+			if i < len(n.Align) && n.Align[i] != "" {
+				b.WriteString(` style="text-align:` + n.Align[i] + `"`)
+			}
+			b.WriteString(">")
+			writeHTML(b, cell)
+			b.WriteString("</" + tag + ">")
+		}
+		b.WriteString("</tr>")
+	}
+	b.WriteString(Inject("<table>", n.BlockAttributes))
+	if n.Header != nil {
+		b.WriteString("<thead>")
+		writeRow(n.Header, "th")
+		b.WriteString("</thead>")
+	}
+	if len(n.Rows) > 0 {
+		b.WriteString("<tbody>")
+		for _, row := range n.Rows {
+			writeRow(row, "td")
+		}
+		b.WriteString("</tbody>")
+	}
+	b.WriteString("</table>")
+}
+
+// HasID returns true if the first tag in tag has an id attribute.
+func HasID(tag string) bool {
+	return regexp.MustCompile(`(?i)^<[^<]*id=".*?"`).MatchString(tag)
+}
+
+// Inject HTML attributes into the HTML `tag` and return result.
+func Inject(tag string, a BlockAttributes) string {
+	if tag == "" || a.IsBlank() {
+		return tag
+	}
+	attrs := ""
+	if a.Classes != "" {
+		m := regexp.MustCompile(`(?i)^<[^>]*class="`).FindStringIndex(tag)
+		if m != nil {
+			// Inject class names into first existing class attribute in first tag.
+			before := tag[:m[1]]
+			after := tag[m[1]:]
+			tag = before + a.Classes + " " + after
+		} else {
+			attrs = "class=\"" + a.Classes + "\""
+		}
+	}
+	if a.ID != "" && !HasID(tag) {
+		attrs += " id=\"" + strings.ToLower(a.ID) + "\""
+	}
+	if a.CSS != "" {
+		m := regexp.MustCompile(`(?i)^<[^<]*style="(.*?)"`).FindStringSubmatchIndex(tag)
+		if m != nil {
+			// Inject CSS styles into first existing style attribute in first tag.
+			before := tag[:m[2]]
+			after := tag[m[3]:]
+			css := tag[m[2]:m[3]]
+			css = strings.TrimSpace(css)
+			if !strings.HasSuffix(css, ";") {
+				css += ";"
+			}
+			tag = before + css + " " + a.CSS + after
+		} else {
+			attrs += " style=\"" + a.CSS + "\""
+		}
+	}
+	if a.Attributes != "" {
+		attrs += " " + a.Attributes
+	}
+	attrs = strings.TrimLeft(attrs, " \n")
+	if attrs != "" {
+		m := regexp.MustCompile(`(?i)^(<[a-z]+|<h[1-6])(?:[ >])`).FindStringSubmatch(tag) // Match start tag.
+		if m != nil {
+			before := m[1]
+			after := tag[len(m[1]):]
+			tag = before + " " + attrs + after
+		}
+	}
+	return tag
+}
@@ -0,0 +1,498 @@
+package ast
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+var (
+	MATCH_MD_LINK      = regexp.MustCompile(`(?s)^<a href="([^"]*)">(.*)</a>$`)
+	MATCH_MD_IMAGE     = regexp.MustCompile(`^<img src="([^"]*)" alt="([^"]*)">$`)
+	MATCH_MD_BREAK     = regexp.MustCompile(`^<br>(\n?)$`)
+	MATCH_MD_AUTOLINK  = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9+.\-]{1,31}:[^\s<>]*$`)
+	MATCH_MD_ENTITY    = regexp.MustCompile(`^&(?:#[0-9]+|#[xX][0-9a-fA-F]+|[a-zA-Z][a-zA-Z0-9]*);`)
+	MATCH_MD_LIST_ITEM = regexp.MustCompile(`^(\d+)([.)])(\s|$)`)
+)
+
+// alerts maps admonition kinds to GitHub alert types.
+var alerts = map[AdmonitionKind]string{
+	AdmonitionNote:      "NOTE",
+	AdmonitionTip:       "TIP",
+	AdmonitionImportant: "IMPORTANT",
+	AdmonitionCaution:   "CAUTION",
+	AdmonitionWarning:   "WARNING",
+}
+
+// emphasis maps Quote open tags to Markdown emphasis delimiters.
+var emphasis = map[string]string{
+	"<em>":     "*",
+	"<strong>": "**",
+	"<del>":    "~~",
+}
+
+// markdownRenderer renders document tree nodes to Markdown.
+type markdownRenderer struct {
+	warn func(message string)
+}
+
+// RenderMarkdown returns the CommonMark/GFM Markdown rendered from the
+// document tree rooted at n. Elements that cannot be expressed in Markdown
+// (e.g. Block Attributes, definition lists and custom quotes) are rendered as
+// HTML and reported by calling warn (if it is not nil).
+func RenderMarkdown(n Node, warn func(message string)) string {
+	r := &markdownRenderer{warn: warn}
+	return strings.Join(r.block(n), "\n")
+}
+
+// fallback returns the lines of a node rendered as HTML and reports a warning.
+func (r *markdownRenderer) fallback(n Node, what string) []string {
+	r.warning(what + " rendered as HTML")
+	return strings.Split(RenderHTML(n), "\n")
+}
+
+func (r *markdownRenderer) warning(message string) {
+	if r.warn != nil {
+		r.warn(message)
+	}
+}
+
+// blocks returns the Markdown lines of block nodes separated by blank lines.
+func (r *markdownRenderer) blocks(nodes []Node) (lines []string) {
+	if isInline(nodes) {
+		return r.paragraph(nodes)
+	}
+	var prev Node
+	for _, n := range nodes {
+		block := r.block(n)
+		if len(block) == 0 {
+			continue
+		}
+		if len(lines) > 0 {
+			lines = append(lines, "")
+			// Adjacent Markdown lists of the same type would be merged.
+			if list, ok := n.(*List); ok {
+				if prevList, ok := prev.(*List); ok && prevList.OpenTag == list.OpenTag {
+					lines = append(lines, "<!-- -->", "")
+				}
+			}
+		}
+		lines = append(lines, block...)
+		prev = n
+	}
+	return
+}
+
+// block returns the Markdown lines of a block node.
+func (r *markdownRenderer) block(n Node) []string {
+	switch n := n.(type) {
+	case *Document:
+		return r.blocks(n.Children)
+	case *HTML:
+		return strings.Split(Inject(n.Text, n.BlockAttributes), "\n")
+	case *Header:
+		if !n.IsBlank() {
+			return r.fallback(n, "header attributes")
+		}
+		title := r.inline(n.Children)
+		if n.Number != "" {
+			title = n.Number + " " + title
+		}
+		return []string{strings.TrimRight(strings.Repeat("#", n.Level)+" "+title, " ")}
+	case *Image:
+		if !n.IsBlank() {
+			return r.fallback(n, "image attributes")
+		}
+		return []string{"![" + escapeMarkdown(n.Alt, false) + "](" + destination(n.Src) + ")"}
+	case *Paragraph:
+		if !n.IsBlank() {
+			return r.fallback(n, "paragraph attributes")
+		}
+		return r.paragraph(n.Children)
+	case *DelimitedBlock:
+		return r.delimitedBlock(n)
+	case *Admonition:
+		return r.admonition(n)
+	case *List:
+		return r.list(n)
+	case *Table:
+		return r.table(n)
+	case *Footnotes:
+		var lines []string
+		for i, note := range n.Notes {
+			if i > 0 {
+				lines = append(lines, "")
+			}
+			lines = append(lines, indent(r.blocks(note.Children), fmt.Sprintf("[^%d]: ", i+1), "    ")...)
+		}
+		return lines
+	case *Text, *Quote, *Replacement:
+		return r.paragraph([]Node{n})
+	}
+	return nil
+}
+
+// delimitedBlock returns the Markdown lines of a delimited block.
+func (r *markdownRenderer) delimitedBlock(n *DelimitedBlock) []string {
+	switch n.Name {
+	case "code", "indented":
+		classes := strings.Fields(n.Classes)
+		if len(classes) > 1 || n.ID != "" || n.CSS != "" || n.Attributes != "" {
+			return r.fallback(n, "code block attributes")
+		}
+		lang := ""
+		if len(classes) == 1 {
+			lang = strings.TrimPrefix(strings.TrimPrefix(classes[0], "language-"), "lang-")
+		}
+		code := codeText(n.Children)
+		fence := "```"
+		if n := longestRun(code, '`'); n >= 3 {
+			fence = strings.Repeat("`", n+1)
+		}
+		lines := []string{fence + lang}
+		if code != "" {
+			lines = append(lines, strings.Split(code, "\n")...)
+		}
+		return append(lines, fence)
+	case "quote", "quote-paragraph":
+		if !n.IsBlank() {
+			return r.fallback(n, "quote attributes")
+		}
+		return quoted(r.blocks(n.Children))
+	}
+	opentag := Inject(n.OpenTag, n.BlockAttributes)
+	if n.Name == "division" && opentag == "<div>" || opentag == "" && n.CloseTag == "" {
+		return r.blocks(n.Children)
+	}
+	// Wrap Markdown content in the block's HTML tags.
+	r.warning(n.Name + " block rendered as HTML")
+	lines := []string{opentag, ""}
+	lines = append(lines, r.blocks(n.Children)...)
+	return append(lines, "", n.CloseTag)
+}
+
+// admonition returns an admonition rendered as a GitHub alert. Admonitions
+// without an equivalent alert are wrapped in HTML aside tags.
+func (r *markdownRenderer) admonition(n *Admonition) []string {
+	alert, ok := alerts[n.Kind]
+	title := strings.TrimSpace(stripTags(RenderHTML(n.Title...)))
+	kind := string(n.Kind)
+	if ok && n.IsBlank() && title == strings.ToUpper(kind[:1])+kind[1:] {
+		return quoted(append([]string{"[!" + alert + "]"}, r.blocks(n.Children)...))
+	}
+	r.warning(kind + " admonition rendered as HTML")
+	lines := []string{
+		Inject(admonitionTag(n.Kind), n.BlockAttributes),
+		`<p class="admonition-title">` + RenderHTML(n.Title...) + "</p>",
+		"",
+	}
+	lines = append(lines, r.blocks(n.Children)...)
+	return append(lines, "", "</aside>")
+}
+
+// list returns the Markdown lines of a list. Item text is indented past the
+// item marker and child lists are indented under their parent item.
+func (r *markdownRenderer) list(n *List) (lines []string) {
+	if n.OpenTag == "<dl>" {
+		return r.fallback(n, "definition list")
+	}
+	attributes := !n.IsBlank()
+	for _, item := range n.Items {
+		attributes = attributes || !item.IsBlank()
+	}
+	if attributes {
+		return r.fallback(n, "list attributes")
+	}
+	for i, item := range n.Items {
+		marker := "-"
+		if n.OpenTag == "<ol>" {
+			marker = fmt.Sprintf("%d.", i+1)
+		}
+		// Item text followed by optional attached block and child list.
+		text := len(item.Children)
+		for j, child := range item.Children {
+			if !isInline([]Node{child}) {
+				text = j
+				break
+			}
+		}
+		itemLines := r.paragraph(item.Children[:text])
+		if item.Task {
+			checkbox := "[ ]"
+			if item.Checked {
+				checkbox = "[x]"
+			}
+			if len(itemLines) == 0 {
+				itemLines = []string{checkbox}
+			} else {
+				itemLines[0] = checkbox + " " + itemLines[0]
+			}
+		}
+		for _, child := range item.Children[text:] {
+			block := r.block(child)
+			if len(block) == 0 {
+				continue
+			}
+			if _, ok := child.(*List); !ok && len(itemLines) > 0 {
+				itemLines = append(itemLines, "")
+			}
+			itemLines = append(itemLines, block...)
+		}
+		if len(itemLines) == 0 {
+			itemLines = []string{""}
+		}
+		lines = append(lines, indent(itemLines, marker+" ", strings.Repeat(" ", len(marker)+1))...)
+	}
+	return
+}
+
+// table returns a GFM pipe table. Tables without a header row are rendered
+// as HTML.
+func (r *markdownRenderer) table(n *Table) []string {
+	if !n.IsBlank() {
+		return r.fallback(n, "table attributes")
+	}
+	if n.Header == nil {
+		return r.fallback(n, "table without header row")
+	}
+	lines := []string{r.row(n.Header)}
+	var delimiters []string
+	for i := range n.Header {
+		align := ""
+		if i < len(n.Align) {
+			align = n.Align[i]
+		}
+		switch align {
+		case "left":
+			delimiters = append(delimiters, ":---")
+		case "center":
+			delimiters = append(delimiters, ":---:")
+		case "right":
+			delimiters = append(delimiters, "---:")
+		default:
+			delimiters = append(delimiters, "---")
+		}
+	}
+	lines = append(lines, "| "+strings.Join(delimiters, " | ")+" |")
+	for _, row := range n.Rows {
+		lines = append(lines, r.row(row))
+	}
+	return lines
+}
+
+// row returns a pipe table row.
+func (r *markdownRenderer) row(cells []*TableCell) string {
+	var result []string
+	for _, cell := range cells {
+		text := strings.ReplaceAll(r.inline(cell.Children), "\n", " ")
+		result = append(result, strings.ReplaceAll(text, "|", `\|`))
+	}
+	return strings.TrimRight("| "+strings.Join(result, " | "), " ") + " |"
+}
+
+// paragraph returns the Markdown lines of inline nodes.
+func (r *markdownRenderer) paragraph(nodes []Node) []string {
+	text := strings.TrimRight(r.inline(nodes), "\n")
+	if text == "" {
+		return nil
+	}
+	lines := strings.Split(text, "\n")
+	for i := range lines {
+		// Leading indents are not significant in paragraphs.
+		lines[i] = strings.TrimLeft(lines[i], " \t")
+	}
+	return lines
+}
+
+// inline returns the Markdown text of inline nodes.
+func (r *markdownRenderer) inline(nodes []Node) string {
+	var b strings.Builder
+	r.writeInline(&b, nodes)
+	return b.String()
+}
+
+func (r *markdownRenderer) writeInline(b *strings.Builder, nodes []Node) {
+	for _, n := range nodes {
+		lineStart := b.Len() == 0 || strings.HasSuffix(b.String(), "\n")
+		switch n := n.(type) {
+		case *Text:
+			b.WriteString(escapeMarkdown(n.Text, lineStart))
+		case *Quote:
+			if n.OpenTag == "<code>" {
+				b.WriteString(codeSpan(codeText(n.Children)))
+			} else if delimiter, ok := emphasis[n.OpenTag]; ok {
+				b.WriteString(delimiter)
+				r.writeInline(b, n.Children)
+				b.WriteString(delimiter)
+			} else {
+				r.warning("quote " + n.Quote + " rendered as HTML")
+				b.WriteString(n.OpenTag)
+				r.writeInline(b, n.Children)
+				b.WriteString(n.CloseTag)
+			}
+		case *Replacement:
+			b.WriteString(r.replacement(n, lineStart))
+		case *HTML:
+			b.WriteString(n.Text)
+		}
+	}
+}
+
+// replacement returns the Markdown text of a replacement. Links, images, hard
+// line breaks and footnote references are converted to Markdown, inline HTML
+// is passed through and other replacement HTML is reported.
+func (r *markdownRenderer) replacement(n *Replacement, lineStart bool) string {
+	if match := MATCH_MD_LINK.FindStringSubmatch(n.HTML); match != nil {
+		url := html.UnescapeString(match[1])
+		text := stripTags(match[2])
+		if (text == url || "mailto:"+text == url) && MATCH_MD_AUTOLINK.MatchString(url) {
+			return "<" + text + ">"
+		}
+		return "[" + markdownHTML(match[2]) + "](" + destination(url) + ")"
+	}
+	if strings.Contains(n.HTML, `class="footnote-ref"`) {
+		return "[^" + stripTags(n.HTML) + "]"
+	}
+	if match := MATCH_MD_IMAGE.FindStringSubmatch(n.HTML); match != nil {
+		return "![" + escapeMarkdown(html.UnescapeString(match[2]), false) + "](" + destination(html.UnescapeString(match[1])) + ")"
+	}
+	if match := MATCH_MD_BREAK.FindStringSubmatch(n.HTML); match != nil {
+		if match[1] == "" {
+			return "" // A hard line break at the end of a paragraph is ignored.
+		}
+		return "\\\n"
+	}
+	if n.Source == n.HTML {
+		return n.HTML // Inline HTML tags and entities.
+	}
+	if !strings.Contains(n.HTML, "<") {
+		return escapeMarkdown(html.UnescapeString(n.HTML), lineStart)
+	}
+	r.warning("replacement " + n.Source + " rendered as HTML")
+	return n.HTML
+}
+
+// markdownHTML returns inline HTML with emphasis and code tags converted to
+// Markdown.
+func markdownHTML(text string) string {
+	var b strings.Builder
+	code := false
+	i := 0
+	for _, m := range MATCH_TAG.FindAllStringIndex(text, -1) {
+		part := html.UnescapeString(text[i:m[0]])
+		if !code {
+			part = escapeMarkdown(part, false)
+		}
+		b.WriteString(part)
+		tag := strings.ToLower(text[m[0]:m[1]])
+		switch tag {
+		case "<code>", "</code>":
+			b.WriteString("`")
+			code = tag == "<code>"
+		case "<em>", "</em>", "<strong>", "</strong>", "<del>", "</del>":
+			b.WriteString(emphasis[strings.Replace(tag, "/", "", 1)])
+		default:
+			b.WriteString(text[m[0]:m[1]])
+		}
+		i = m[1]
+	}
+	b.WriteString(escapeMarkdown(html.UnescapeString(text[i:]), false))
+	return b.String()
+}
+
+// escapeMarkdown returns text with Markdown special characters escaped. If
+// lineStart is true the text starts at the beginning of a line, characters
+// that would start a block element at the beginning of a line are also
+// escaped.
+func escapeMarkdown(text string, lineStart bool) string {
+	var b strings.Builder
+	runes := []rune(text)
+	for i := 0; i < len(runes); i++ {
+		c := runes[i]
+		if c == '\n' {
+			lineStart = true
+			b.WriteRune(c)
+			continue
+		}
+		if lineStart {
+			lineStart = false
+			if match := MATCH_MD_LIST_ITEM.FindStringSubmatch(string(runes[i:])); match != nil {
+				// Escape the list item number delimiter.
+				b.WriteString(match[1] + `\` + match[2])
+				i += len(match[1]) // Item numbers are ASCII digits.
+				continue
+			}
+			if strings.ContainsRune("#>-+=", c) {
+				b.WriteRune('\\')
+			}
+		}
+		prev, next := ' ', ' '
+		if i > 0 {
+			prev = runes[i-1]
+		}
+		if i < len(runes)-1 {
+			next = runes[i+1]
+		}
+		switch {
+		case strings.ContainsRune("\\`*[]~", c):
+			b.WriteRune('\\')
+		case c == '_' && !(isWordRune(prev) && isWordRune(next)):
+			b.WriteRune('\\')
+		case c == '<' && (unicode.IsLetter(next) || strings.ContainsRune("/!?", next)):
+			b.WriteRune('\\')
+		case c == '&' && MATCH_MD_ENTITY.MatchString(string(runes[i:])):
+			b.WriteRune('\\')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+func isWordRune(c rune) bool {
+	return unicode.IsLetter(c) || unicode.IsDigit(c)
+}
+
+// codeSpan returns a Markdown code span containing code.
+func codeSpan(code string) string {
+	delimiter := strings.Repeat("`", longestRun(code, '`')+1)
+	if strings.HasPrefix(code, "`") || strings.HasSuffix(code, "`") ||
+		strings.HasPrefix(code, " ") && strings.HasSuffix(code, " ") && strings.TrimSpace(code) != "" {
+		code = " " + code + " "
+	}
+	return delimiter + code + delimiter
+}
+
+// longestRun returns the length of the longest run of c characters in text.
+func longestRun(text string, c rune) (longest int) {
+	run := 0
+	for _, r := range text {
+		if r == c {
+			run++
+			if run > longest {
+				longest = run
+			}
+		} else {
+			run = 0
+		}
+	}
+	return
+}
+
+// destination returns a Markdown link destination.
+func destination(url string) string {
+	if url == "" || strings.ContainsAny(url, " ()<>") {
+		return "<" + strings.NewReplacer("<", `\<`, ">", `\>`).Replace(url) + ">"
+	}
+	return url
+}
+
+// quoted returns lines prefixed with Markdown block quote markers.
+func quoted(lines []string) []string {
+	result := make([]string, len(lines))
+	for i, line := range lines {
+		result[i] = strings.TrimRight("> "+line, " ")
+	}
+	return result
+}
@@ -0,0 +1,386 @@
+package ast
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// hardBreak separates lines that are not joined when text is wrapped.
+const hardBreak = "\u2028"
+
+var (
+	MATCH_LINK          = regexp.MustCompile(`(?s)^<a\s+href="([^"]*)"[^>]*>(.*)</a>$`)
+	MATCH_BREAK         = regexp.MustCompile(`(?i)^<br\s*/?>`)
+	MATCH_IMAGE_ALT     = regexp.MustCompile(`(?i)^<img\s[^>]*alt="([^"]*)"`)
+	MATCH_TAG           = regexp.MustCompile(`<[^>]*>`)
+	MATCH_BLOCK_END_TAG = regexp.MustCompile(`(?i)<br\s*/?>|</(p|div|li|dt|dd|tr|h[1-6]|pre|blockquote|nav|section|table|ul|ol|dl)>`)
+)
+
+// textRenderer renders document tree nodes to plain text.
+type textRenderer struct {
+	links []string // Link reference URLs.
+}
+
+// RenderText returns the plain text rendered from the document tree rooted
+// at n. Paragraphs are wrapped to width columns (they are not wrapped if width
+// is zero). Headers are underlined, list items are indented and link URLs are
+// listed as numbered references at the end of the text.
+func RenderText(n Node, width int) string {
+	r := &textRenderer{}
+	lines := r.block(n, width)
+	if len(r.links) > 0 {
+		if len(lines) > 0 {
+			lines = append(lines, "")
+		}
+		for i, url := range r.links {
+			lines = append(lines, fmt.Sprintf("[%d] %s", i+1, url))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// blocks returns the text lines of block nodes separated by blank lines.
+func (r *textRenderer) blocks(nodes []Node, width int) (lines []string) {
+	if isInline(nodes) {
+		return wrap(r.inline(nodes), width)
+	}
+	for _, n := range nodes {
+		block := r.block(n, width)
+		if len(block) == 0 {
+			continue
+		}
+		if len(lines) > 0 {
+			lines = append(lines, "")
+		}
+		lines = append(lines, block...)
+	}
+	return
+}
+
+// block returns the text lines of a block node.
+func (r *textRenderer) block(n Node, width int) []string {
+	switch n := n.(type) {
+	case *Document:
+		return r.blocks(n.Children, width)
+	case *HTML:
+		return htmlLines(n.Text)
+	case *Header:
+		title := strings.Join(wrap(r.inline(n.Children), 0), " ")
+		if n.Number != "" {
+			title = n.Number + " " + title
+		}
+		underline := "~"
+		switch n.Level {
+		case 1:
+			underline = "="
+		case 2:
+			underline = "-"
+		}
+		return []string{title, strings.Repeat(underline, utf8.RuneCountInString(title))}
+	case *Image:
+		if n.Alt == "" {
+			return nil
+		}
+		return []string{n.Alt}
+	case *Paragraph:
+		return wrap(r.inline(n.Children), width)
+	case *DelimitedBlock:
+		switch n.Name {
+		case "code", "indented":
+			return indent(strings.Split(codeText(n.Children), "\n"), "    ", "    ")
+		case "quote", "quote-paragraph":
+			return indent(r.blocks(n.Children, sub(width, 2)), "> ", "> ")
+		}
+		return r.blocks(n.Children, width)
+	case *Admonition:
+		title := strings.Join(wrap(r.inline(n.Title), 0), " ")
+		return append([]string{title + ":"}, indent(r.blocks(n.Children, sub(width, 2)), "  ", "  ")...)
+	case *List:
+		return r.list(n, width)
+	case *Table:
+		return r.table(n)
+	case *Footnotes:
+		var lines []string
+		for i, note := range n.Notes {
+			marker := fmt.Sprintf("[^%d]", i+1)
+			lines = append(lines, indent(wrap(r.inline(note.Children), sub(width, len(marker)+1)), marker+" ", strings.Repeat(" ", len(marker)+1))...)
+		}
+		return lines
+	case *Text, *Quote, *Replacement:
+		return wrap(r.inline([]Node{n}), width)
+	}
+	return nil
+}
+
+// list returns the text lines of a list. Item text is indented past the item
+// marker and child lists are indented under their parent item.
+func (r *textRenderer) list(n *List, width int) (lines []string) {
+	for i, item := range n.Items {
+		var marker string
+		switch n.OpenTag {
+		case "<ol>":
+			marker = fmt.Sprintf("%d.", i+1)
+		case "<dl>":
+			lines = append(lines, wrap(r.inline(item.Term), width)...)
+			marker = "   "
+		default:
+			marker = "-"
+		}
+		if item.Task {
+			if item.Checked {
+				marker += " [x]"
+			} else {
+				marker += " [ ]"
+			}
+		}
+		// Item text followed by optional attached block and child list.
+		text := len(item.Children)
+		for j, child := range item.Children {
+			if !isInline([]Node{child}) {
+				text = j
+				break
+			}
+		}
+		w := sub(width, utf8.RuneCountInString(marker)+1)
+		itemLines := wrap(r.inline(item.Children[:text]), w)
+		for _, child := range item.Children[text:] {
+			block := r.block(child, w)
+			if len(block) == 0 {
+				continue
+			}
+			if _, ok := child.(*List); !ok && len(itemLines) > 0 {
+				itemLines = append(itemLines, "")
+			}
+			itemLines = append(itemLines, block...)
+		}
+		if len(itemLines) == 0 {
+			itemLines = []string{""}
+		}
+		pad := strings.Repeat(" ", utf8.RuneCountInString(marker)+1)
+		lines = append(lines, indent(itemLines, marker+" ", pad)...)
+	}
+	return
+}
+
+// table returns the text lines of a table with padded columns. The header
+// row is underlined.
+func (r *textRenderer) table(n *Table) (lines []string) {
+	var rows [][]string
+	if n.Header != nil {
+		rows = append(rows, r.cells(n.Header))
+	}
+	for _, row := range n.Rows {
+		rows = append(rows, r.cells(row))
+	}
+	var widths []int
+	for _, row := range rows {
+		for i, cell := range row {
+			if i >= len(widths) {
+				widths = append(widths, 0)
+			}
+			if w := utf8.RuneCountInString(cell); w > widths[i] {
+				widths[i] = w
+			}
+		}
+	}
+	for i, row := range rows {
+		var cols []string
+		for j, cell := range row {
+			align := ""
+			if j < len(n.Align) {
+				align = n.Align[j]
+			}
+			cols = append(cols, pad(cell, widths[j], align))
+		}
+		lines = append(lines, strings.TrimRight(strings.Join(cols, "  "), " "))
+		if i == 0 && n.Header != nil {
+			var rules []string
+			for _, w := range widths {
+				rules = append(rules, strings.Repeat("-", w))
+			}
+			lines = append(lines, strings.Join(rules, "  "))
+		}
+	}
+	return
+}
+
+// cells returns the single line text of table cells.
+func (r *textRenderer) cells(cells []*TableCell) (result []string) {
+	for _, cell := range cells {
+		result = append(result, strings.Join(wrap(r.inline(cell.Children), 0), " "))
+	}
+	return
+}
+
+// inline returns the text of inline nodes. Hard line breaks are separated by
+// hardBreak characters.
+func (r *textRenderer) inline(nodes []Node) string {
+	var b strings.Builder
+	for _, n := range nodes {
+		switch n := n.(type) {
+		case *Text:
+			b.WriteString(n.Text)
+		case *Quote:
+			b.WriteString(r.inline(n.Children))
+		case *Replacement:
+			b.WriteString(r.replacement(n))
+		case *HTML:
+			b.WriteString(stripTags(n.Text))
+		}
+	}
+	return b.String()
+}
+
+// replacement returns the text of a replacement. Links are followed by a link
+// reference number (unless the link text is the URL).
+func (r *textRenderer) replacement(n *Replacement) string {
+	if match := MATCH_LINK.FindStringSubmatch(n.HTML); match != nil {
+		url := html.UnescapeString(match[1])
+		text := stripTags(match[2])
+		switch {
+		case strings.HasPrefix(url, "#"):
+			return text
+		case text == "" || text == url || "mailto:"+text == url:
+			return url
+		}
+		return text + " [" + fmt.Sprint(r.link(url)) + "]"
+	}
+	if strings.Contains(n.HTML, `class="footnote-ref"`) {
+		return "[^" + stripTags(n.HTML) + "]"
+	}
+	if MATCH_BREAK.MatchString(n.HTML) {
+		return hardBreak + stripTags(n.HTML)
+	}
+	if match := MATCH_IMAGE_ALT.FindStringSubmatch(n.HTML); match != nil {
+		return html.UnescapeString(match[1])
+	}
+	return stripTags(n.HTML)
+}
+
+// link returns the reference number of a link URL.
+func (r *textRenderer) link(url string) int {
+	for i, u := range r.links {
+		if u == url {
+			return i + 1
+		}
+	}
+	r.links = append(r.links, url)
+	return len(r.links)
+}
+
+// isInline returns true if all nodes are inline nodes.
+func isInline(nodes []Node) bool {
+	for _, n := range nodes {
+		switch n.(type) {
+		case *Text, *Quote, *Replacement:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// codeText returns the source text of code block nodes.
+func codeText(nodes []Node) string {
+	var b strings.Builder
+	for _, n := range nodes {
+		switch n := n.(type) {
+		case *Text:
+			b.WriteString(n.Text)
+		case *Replacement:
+			b.WriteString(n.Source)
+		case *Quote:
+			b.WriteString(codeText(n.Children))
+		case *HTML:
+			b.WriteString(stripTags(n.Text))
+		}
+	}
+	return b.String()
+}
+
+// stripTags returns HTML text without tags and with entities unescaped.
+func stripTags(text string) string {
+	return html.UnescapeString(MATCH_TAG.ReplaceAllString(text, ""))
+}
+
+// htmlLines returns the non-blank text lines of an HTML block.
+func htmlLines(text string) (lines []string) {
+	text = MATCH_BLOCK_END_TAG.ReplaceAllString(text, "$0\n")
+	for _, line := range strings.Split(stripTags(text), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return
+}
+
+// wrap returns text split into lines no longer than width (unless a word is
+// longer than width). Text is not wrapped if width is zero. Whitespace is
+// collapsed and lines are also split at hard breaks.
+func wrap(text string, width int) (lines []string) {
+	for _, para := range strings.Split(text, hardBreak) {
+		words := strings.FieldsFunc(para, func(r rune) bool {
+			return r == ' ' || r == '\t' || r == '\n' || r == '\r'
+		})
+		line := ""
+		for _, word := range words {
+			switch {
+			case line == "":
+				line = word
+			case width > 0 && utf8.RuneCountInString(line)+1+utf8.RuneCountInString(word) > width:
+				lines = append(lines, line)
+				line = word
+			default:
+				line += " " + word
+			}
+		}
+		lines = append(lines, line)
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return
+}
+
+// indent prefixes the first line with first and the remaining non-blank
+// lines with rest.
+func indent(lines []string, first string, rest string) []string {
+	result := make([]string, len(lines))
+	for i, line := range lines {
+		switch {
+		case i == 0:
+			result[i] = strings.TrimRight(first+line, " ")
+		case line == "":
+			result[i] = ""
+		default:
+			result[i] = rest + line
+		}
+	}
+	return result
+}
+
+// sub returns the width remaining after an indent (zero widths are not wrapped).
+func sub(width int, n int) int {
+	if width == 0 {
+		return 0
+	}
+	if width-n < 1 {
+		return 1
+	}
+	return width - n
+}
+
+// pad returns text padded to width with the given alignment.
+func pad(text string, width int, align string) string {
+	n := width - utf8.RuneCountInString(text)
+	switch align {
+	case "right":
+		return strings.Repeat(" ", n) + text
+	case "center":
+		return strings.Repeat(" ", n/2) + text + strings.Repeat(" ", n-n/2)
+	}
+	return text + strings.Repeat(" ", n)
+}
@@ -5,150 +5,140 @@ import (
 	"regexp"
 	"strings"
 
+	"github.com/srackham/go-rimu/v11/internal/ast"
 	"github.com/srackham/go-rimu/v11/internal/expansion"
 	"github.com/srackham/go-rimu/v11/internal/options"
 	"github.com/srackham/go-rimu/v11/internal/spans"
 	"github.com/srackham/go-rimu/v11/internal/utils/stringlist"
 )
 
+// MATCH_ATTRIBUTES matches a Block Attributes element.
+// class names = $1, id = $2, css-properties = $3, html-attributes = $4, block-options = $5
+var MATCH_ATTRIBUTES = regexp.MustCompile(`^\\?\.((?:[a-zA-Z][\w-]*\s*)+)?(#[a-zA-Z][\w-]*)?(?:\s*"([^"]+?)")?(?:\s*\[([^\]]+)\])?(\s*[+-][\w\s+-]+)?$`)
+
 type attrs struct {
-	Classes    string // Space separated HTML class names.
-	ID         string // HTML element id.
-	css        string // HTML CSS styles.
-	attributes string // Other HTML element attributes.
-	Options    expansion.Options
+	ast.BlockAttributes
+	Options expansion.Options
 }
 
-// Attrs are those of the last parsed Block Attributes element.
-var Attrs attrs
-
-var ids stringlist.StringList // List of allocated HTML ids.
-
-func init() {
-	Init()
+// BlockAttributes contains the Block Attributes state of a single document.
+type BlockAttributes struct {
+	Attrs   attrs                 // Attributes of the last parsed Block Attributes element.
+	ids     stringlist.StringList // List of allocated HTML ids.
+	Options *options.Options
+	Spans   *spans.Spans
 }
 
 // Init resets options to default values.
-func Init() {
-	Attrs.Classes = ""
-	Attrs.ID = ""
-	Attrs.css = ""
-	Attrs.attributes = ""
-	Attrs.Options = expansion.Options{}
-	ids = nil
+func (b *BlockAttributes) Init() {
+	b.Attrs.BlockAttributes = ast.BlockAttributes{}
+	b.Attrs.Options = expansion.Options{}
+	b.ids = nil
 }
 
 // Parse text to Attrs block attributes.
-func Parse(text string) bool {
-	// class names = $1, id = $2, css-properties = $3, html-attributes = $4, block-options = $5
-	text = spans.ReplaceInline(text, expansion.Options{Macros: true})
-	m := regexp.MustCompile(`^\\?\.((?:[a-zA-Z][\w-]*\s*)+)?(#[a-zA-Z][\w-]*)?(?:\s*"([^"]+?)")?(?:\s*\[([^\]]+)\])?(\s*[+-][\w\s+-]+)?$`).FindStringSubmatch(text)
+func (b *BlockAttributes) Parse(text string) bool {
+	text = b.Spans.ReplaceInline(text, expansion.Options{Macros: true})
+	m := MATCH_ATTRIBUTES.FindStringSubmatch(text)
 	if m == nil {
 		return false
 	}
 	for i, v := range m {
 		m[i] = strings.TrimSpace(v)
 	}
-	if !options.SkipBlockAttributes() {
+	if !b.Options.SkipBlockAttributes() {
 		if m[1] != "" { // HTML element class names.
-			if Attrs.Classes != "" {
-				Attrs.Classes += " "
+			if b.Attrs.Classes != "" {
+				b.Attrs.Classes += " "
 			}
-			Attrs.Classes += m[1]
+			b.Attrs.Classes += m[1]
 		}
 		if m[2] != "" { // HTML element id.
-			Attrs.ID = m[2][1:]
+			b.Attrs.ID = m[2][1:]
 		}
 		if m[3] != "" { // CSS properties.
-			if Attrs.css != "" && !strings.HasSuffix(Attrs.css, ";") {
-				Attrs.css += ";"
+			if b.Attrs.CSS != "" && !strings.HasSuffix(b.Attrs.CSS, ";") {
+				b.Attrs.CSS += ";"
 			}
-			if Attrs.css != "" {
-				Attrs.css += " "
+			if b.Attrs.CSS != "" {
+				b.Attrs.CSS += " "
 			}
-			Attrs.css += m[3]
+			b.Attrs.CSS += m[3]
 		}
-		if m[4] != "" && !options.IsSafeModeNz() { // HTML attributes.
-			if Attrs.attributes != "" {
-				Attrs.attributes += " "
+		if m[4] != "" && !b.Options.IsSafeModeNz() { // HTML attributes.
+			if b.Attrs.Attributes != "" {
+				b.Attrs.Attributes += " "
 			}
-			Attrs.attributes += m[4]
+			b.Attrs.Attributes += m[4]
 		}
 		if m[5] != "" {
-			Attrs.Options.Merge(expansion.Parse(m[5]))
+			b.Attrs.Options.Merge(expansion.Parse(m[5], b.Options))
 		}
 	}
 	return true
 }
 
-// Inject HTML attributes into the HTML `tag` and return result.
-// Consume HTML attributes unless the `tag` argument is blank.
-func Inject(tag string) string {
-	if tag == "" {
-		return tag
+// Format returns Block Attributes element text with its parts separated by
+// single spaces. Text containing macro references is returned unchanged.
+func Format(text string) string {
+	m := MATCH_ATTRIBUTES.FindStringSubmatch(text)
+	if m == nil || strings.Contains(text, "{") {
+		return text
 	}
-	attrs := ""
-	if Attrs.Classes != "" {
-		m := regexp.MustCompile(`(?i)^<[^>]*class="`).FindStringIndex(tag)
-		if m != nil {
-			// Inject class names into first existing class attribute in first tag.
-			before := tag[:m[1]]
-			after := tag[m[1]:]
-			tag = before + Attrs.Classes + " " + after
-		} else {
-			attrs = "class=\"" + Attrs.Classes + "\""
-		}
+	var parts []string
+	if m[1] != "" {
+		parts = append(parts, strings.TrimSpace(m[1]))
 	}
-	if Attrs.ID != "" {
-		Attrs.ID = strings.ToLower(Attrs.ID)
-		hasID := regexp.MustCompile(`(?i)^<[^<]*id=".*?"`).MatchString(tag)
-		if hasID || ids.IndexOf(Attrs.ID) >= 0 {
-			options.ErrorCallback("duplicate 'id' attribute: " + Attrs.ID)
-		} else {
-			ids.Push(Attrs.ID)
-		}
-		if !hasID {
-			attrs += " id=\"" + Attrs.ID + "\""
-		}
+	if m[2] != "" {
+		parts = append(parts, m[2])
 	}
-	if Attrs.css != "" {
-		m := regexp.MustCompile(`(?i)^<[^<]*style="(.*?)"`).FindStringSubmatchIndex(tag)
-		if m != nil {
-			// Inject CSS styles into first existing style attribute in first tag.
-			before := tag[:m[2]]
-			after := tag[m[3]:]
-			css := tag[m[2]:m[3]]
-			css = strings.TrimSpace(css)
-			if !strings.HasSuffix(css, ";") {
-				css += ";"
-			}
-			tag = before + css + " " + Attrs.css + after
-		} else {
-			attrs += " style=\"" + Attrs.css + "\""
-		}
+	if m[3] != "" {
+		parts = append(parts, `"`+m[3]+`"`)
+	}
+	if m[4] != "" {
+		parts = append(parts, "["+m[4]+"]")
 	}
-	if Attrs.attributes != "" {
-		attrs += " " + Attrs.attributes
+	if m[5] != "" {
+		parts = append(parts, strings.Join(strings.Fields(m[5]), " "))
 	}
-	attrs = strings.TrimLeft(attrs, " \n")
-	if attrs != "" {
-		m := regexp.MustCompile(`(?i)^(<[a-z]+|<h[1-6])(?:[ >])`).FindStringSubmatch(tag) // Match start tag.
-		if m != nil {
-			before := m[1]
-			after := tag[len(m[1]):]
-			tag = before + " " + attrs + after
+	return "." + strings.Join(parts, " ")
+}
+
+// Inject HTML attributes into the HTML `tag` and return result.
+// Consume HTML attributes unless the `tag` argument is blank.
+func (b *BlockAttributes) Inject(tag string) string {
+	return ast.Inject(tag, b.Consume(tag))
+}
+
+// Consume returns the HTML attributes that will be injected into the HTML `tag`.
+// The returned attributes are removed from Attrs unless the `tag` argument is blank.
+// Reports duplicate ids.
+func (b *BlockAttributes) Consume(tag string) (result ast.BlockAttributes) {
+	if tag == "" {
+		return
+	}
+	if b.Attrs.ID != "" {
+		b.Attrs.ID = strings.ToLower(b.Attrs.ID)
+		if ast.HasID(tag) || b.ids.IndexOf(b.Attrs.ID) >= 0 {
+			b.Options.ErrorCallback(options.DuplicateID, "duplicate 'id' attribute: "+b.Attrs.ID)
+		} else {
+			b.ids.Push(b.Attrs.ID)
 		}
 	}
-	// Consume the attributes.
-	Attrs.Classes = ""
-	Attrs.ID = ""
-	Attrs.css = ""
-	Attrs.attributes = ""
-	return tag
+	result = b.Attrs.BlockAttributes
+	b.Attrs.BlockAttributes = ast.BlockAttributes{}
+	return
+}
+
+// NewID returns a unique HTML id slugified from text and allocates it.
+func (b *BlockAttributes) NewID(text string) string {
+	id := b.Slugify(text)
+	b.ids.Push(id)
+	return id
 }
 
 // Slugify converts text to a slug.
-func Slugify(text string) string {
+func (b *BlockAttributes) Slugify(text string) string {
 	slug := text
 	slug = regexp.MustCompile(`\W+`).ReplaceAllString(slug, "-") // Replace non-alphanumeric characters with dashes.
 	slug = regexp.MustCompile(`-+`).ReplaceAllString(slug, "-")  // Replace multiple dashes with single dash.
@@ -157,9 +147,9 @@ func Slugify(text string) string {
 	if slug == "" {
 		slug = "x"
 	}
-	if ids.IndexOf(slug) > -1 { // Another element already has that id.
+	if b.ids.IndexOf(slug) > -1 { // Another element already has that id.
 		i := 2
-		for ids.IndexOf(slug+"-"+fmt.Sprint(i)) > -1 {
+		for b.ids.IndexOf(slug+"-"+fmt.Sprint(i)) > -1 {
 			i++
 		}
 		slug += "-" + fmt.Sprint(i)
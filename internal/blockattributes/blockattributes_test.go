@@ -1,26 +1,30 @@
-package blockattributes
+package blockattributes_test
 
 import (
 	"testing"
 
 	"github.com/srackham/go-rimu/v11/internal/assert"
-
-	_ "github.com/srackham/go-rimu/v11/internal/macros"
+	"github.com/srackham/go-rimu/v11/internal/ast"
+	"github.com/srackham/go-rimu/v11/internal/blockattributes"
+	"github.com/srackham/go-rimu/v11/internal/document"
+	"github.com/srackham/go-rimu/v11/internal/expansion"
 )
 
 func TestParse(t *testing.T) {
 	tests := []struct {
 		in   string
-		want attrs
+		want ast.BlockAttributes
 	}{
-		{".class #id", attrs{Classes: "class", ID: "id"}},
-		{".\"css\"", attrs{css: "css"}},
+		{".class #id", ast.BlockAttributes{Classes: "class", ID: "id"}},
+		{".\"css\"", ast.BlockAttributes{CSS: "css"}},
 	}
+	b := document.New().BlockAttributes
 	for _, tt := range tests {
-		Init()
-		Parse(tt.in)
-		got := Attrs
-		assert.Equal(t, tt.want, got)
+		b.Init()
+		b.Parse(tt.in)
+		got := b.Attrs
+		assert.Equal(t, tt.want, got.BlockAttributes)
+		assert.Equal(t, expansion.Options{}, got.Options)
 	}
 }
 
@@ -34,13 +38,14 @@ func TestInject(t *testing.T) {
 		{tag: `<p>`, classes: `class`, want: `<p class="class">`},
 		{tag: `<p class="class">`, classes: `class2`, want: `<p class="class2 class">`},
 	}
+	b := document.New().BlockAttributes
 	for _, tt := range tests {
-		Init()
-		Attrs.ID = tt.id
-		Attrs.Classes = tt.classes
-		Attrs.css = tt.css
-		Attrs.attributes = tt.attributes
-		got := Inject(tt.tag)
+		b.Init()
+		b.Attrs.ID = tt.id
+		b.Attrs.Classes = tt.classes
+		b.Attrs.CSS = tt.css
+		b.Attrs.Attributes = tt.attributes
+		got := b.Inject(tt.tag)
 		assert.Equal(t, tt.want, got)
 	}
 }
@@ -54,10 +59,29 @@ func TestSlugify(t *testing.T) {
 		{"Foo Bar", "foo-bar-2"},
 		{"--", "x"},
 	}
-	Init()
+	b := document.New().BlockAttributes
+	b.Init()
 	for _, tt := range tests {
-		got := Slugify(tt.in)
+		got := b.Slugify(tt.in)
 		assert.Equal(t, tt.want, got)
-		ids.Push("foo-bar")
+		b.NewID("foo-bar")
+	}
+}
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{".foo", ".foo"},
+		{".foo  bar   #x", ".foo  bar #x"},
+		{".#x   \"color: red\"", ".#x \"color: red\""},
+		{".  \"color: red\"  [title=\"T\"]", ".\"color: red\" [title=\"T\"]"},
+		{".foo   +macros   -spans", ".foo +macros -spans"},
+		{".{class}  #x", ".{class}  #x"},
+		{".not attributes!", ".not attributes!"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, blockattributes.Format(tt.in))
 	}
 }
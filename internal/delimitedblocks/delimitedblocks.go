@@ -1,25 +1,24 @@
 package delimitedblocks
 
 import (
+	"fmt"
 	"regexp"
 	"strings"
 
+	"github.com/srackham/go-rimu/v11/internal/ast"
 	"github.com/srackham/go-rimu/v11/internal/blockattributes"
 	"github.com/srackham/go-rimu/v11/internal/expansion"
+	"github.com/srackham/go-rimu/v11/internal/footnotes"
+	"github.com/srackham/go-rimu/v11/internal/format"
+	"github.com/srackham/go-rimu/v11/internal/highlight"
 	"github.com/srackham/go-rimu/v11/internal/iotext"
 	"github.com/srackham/go-rimu/v11/internal/macros"
 	"github.com/srackham/go-rimu/v11/internal/options"
 	"github.com/srackham/go-rimu/v11/internal/spans"
+	"github.com/srackham/go-rimu/v11/internal/utils/str"
 	"github.com/srackham/go-rimu/v11/internal/utils/stringlist"
 )
 
-// document package dependency injection.
-var ApiRender func(source string) string
-
-func init() {
-	Init()
-}
-
 var MATCH_INLINE_TAG = regexp.MustCompile(`(?i)^(a|abbr|acronym|address|b|bdi|bdo|big|blockquote|br|cite|code|del|dfn|em|i|img|ins|kbd|mark|q|s|samp|small|span|strike|strong|sub|sup|time|tt|u|var|wbr)$`)
 
 // Multi-line block element definition.
@@ -29,13 +28,46 @@ type Definition struct {
 	closeMatch      *regexp.Regexp
 	openTag         string
 	closeTag        string
-	verify          func(match []string) bool                    // Additional match verification checks.
-	delimiterFilter func(match []string, def *Definition) string // Process opening delimiter. Return any delimiter content.
-	contentFilter   func(text string, match []string, opts expansion.Options) string
+	verify          func(match []string) bool                                        // Additional match verification checks.
+	delimiterFilter func(b *DelimitedBlocks, match []string, def *Definition) string // Process opening delimiter. Return any delimiter content.
+	contentFilter   func(b *DelimitedBlocks, text string, match []string, opts expansion.Options) string
 	options         expansion.Options
+	custom          bool // Registered with the API.
+}
+
+// CustomDefinition is a Delimited Block definition registered with the API.
+type CustomDefinition struct {
+	Name       string         // Unique identifier.
+	OpenMatch  *regexp.Regexp // Opening delimiter.
+	CloseMatch *regexp.Regexp // Closing delimiter, defaults to OpenMatch.
+	OpenTag    string
+	CloseTag   string
+	Options    expansion.Options // Block content expansion options.
+	// Before is the name of the definition that the block is matched before,
+	// defaults to "paragraph".
+	Before string
+	// Verify performs additional opening delimiter match verification checks.
+	Verify func(match []string) bool
+	// DelimiterFilter processes the opening delimiter match and returns text that
+	// is prepended to the block content. The Block Attributes that will be
+	// injected into the block's opening tag can be updated via attrs.
+	DelimiterFilter func(match []string, attrs *ast.BlockAttributes) string
+	// ContentFilter transforms the block content before it is expanded.
+	ContentFilter func(text string, match []string) string
 }
 
-var defs []Definition // Mutable definitions initialized by DEFAULT_DEFS.
+// DelimitedBlocks contains the Delimited Block definitions of a single document.
+type DelimitedBlocks struct {
+	defs            []Definition       // Mutable definitions initialized by DEFAULT_DEFS.
+	custom          []CustomDefinition // Registered definitions, they persist across Init calls.
+	Options         *options.Options
+	Formatter       *format.Formatter
+	Spans           *spans.Spans
+	Macros          *macros.Macros
+	BlockAttributes *blockattributes.BlockAttributes
+	Footnotes       *footnotes.Footnotes
+	ApiParse        func(source string, lineNos []int) []ast.Node // document package dependency injection.
+}
 
 var DEFAULT_DEFS = []Definition{
 	// Delimited blocks cannot be escaped with a backslash.
@@ -91,6 +123,19 @@ var DEFAULT_DEFS = []Definition{
 		},
 		delimiterFilter: classInjectionFilter,
 	},
+	// Admonition block.
+	{
+		name: "admonition",
+		// $1 is delimiter text, $2 is the admonition kind, $3 is the optional title.
+		openMatch: regexp.MustCompile(`(?i)^\\?(!{2,})[ \t]*(note|tip|important|caution|warning|danger)(?:[ \t]+(.*?))?[ \t]*$`),
+		openTag:   "<aside>",
+		closeTag:  "</aside>",
+		options: expansion.Options{
+			Container: true,
+			Specials:  true, // Fall-back if container is disabled.
+		},
+		delimiterFilter: admonitionFilter,
+	},
 	// Quote block.
 	{
 		name:      "quote",
@@ -141,9 +186,8 @@ var DEFAULT_DEFS = []Definition{
 			}
 		},
 		delimiterFilter: delimiterTextFilter,
-		// contentFilter:   options.HtmlSafeModeFilter,
-		contentFilter: func(text string, _ []string, _ expansion.Options) string {
-			return options.HtmlSafeModeFilter(text)
+		contentFilter: func(b *DelimitedBlocks, text string, _ []string, _ expansion.Options) string {
+			return b.Options.HtmlSafeModeFilter(text)
 		},
 	},
 	// Indented paragraph.
@@ -157,7 +201,7 @@ var DEFAULT_DEFS = []Definition{
 			Specials: true,
 		},
 		delimiterFilter: delimiterTextFilter,
-		contentFilter: func(text string, _ []string, _ expansion.Options) string {
+		contentFilter: func(_ *DelimitedBlocks, text string, _ []string, _ expansion.Options) string {
 			// Strip indent from start of each line.
 			firstIndent := regexp.MustCompile(`\S`).FindStringIndex(text)[0]
 			result := ""
@@ -185,7 +229,7 @@ var DEFAULT_DEFS = []Definition{
 			Specials: true, // Fall-back if spans is disabled.
 		},
 		delimiterFilter: delimiterTextFilter,
-		contentFilter: func(text string, _ []string, _ expansion.Options) string {
+		contentFilter: func(_ *DelimitedBlocks, text string, _ []string, _ expansion.Options) string {
 			// Strip leading > from start of each line and unescape escaped leading >.
 			result := ""
 			for _, line := range strings.Split(text, "\n") {
@@ -196,6 +240,37 @@ var DEFAULT_DEFS = []Definition{
 			return strings.TrimSuffix(result, "\n")
 		},
 	},
+	// Footnote definition.
+	// $1 is the footnote label, $2 is the first line of the footnote.
+	{
+		name:       "footnote",
+		openMatch:  regexp.MustCompile(`^\\?\[\^([\w\-]+)\]:\s*(.*)$`),
+		closeMatch: regexp.MustCompile(`^$`),
+		openTag:    "",
+		closeTag:   "",
+		options: expansion.Options{
+			Macros:   true,
+			Spans:    true,
+			Specials: true, // Fall-back if spans is disabled.
+		},
+		delimiterFilter: func(_ *DelimitedBlocks, match []string, _ *Definition) string {
+			return match[2]
+		},
+	},
+	// Pipe table.
+	{
+		name:       "table",
+		openMatch:  regexp.MustCompile(`^\\?(\|.*\|)$`), // $1 is first line of block.
+		closeMatch: regexp.MustCompile(`^$`),
+		openTag:    "<table>",
+		closeTag:   "</table>",
+		options: expansion.Options{
+			Macros:   true,
+			Spans:    true,
+			Specials: true, // Fall-back if spans is disabled.
+		},
+		delimiterFilter: delimiterTextFilter,
+	},
 	// Paragraph (lowest priority, cannot be escaped).
 	{
 		name:       "paragraph",
@@ -213,30 +288,102 @@ var DEFAULT_DEFS = []Definition{
 }
 
 // Reset definitions to defaults.
-func Init() {
-	defs = make([]Definition, len(DEFAULT_DEFS))
+func (b *DelimitedBlocks) Init() {
+	b.defs = make([]Definition, len(DEFAULT_DEFS))
 	for i, def := range DEFAULT_DEFS {
-		defs[i] = def
-		defs[i].options = expansion.Options(def.options) // Clone expansion options.
+		b.defs[i] = def
+		b.defs[i].options = expansion.Options(def.options) // Clone expansion options.
 		if def.closeMatch == nil {
-			defs[i].closeMatch = def.openMatch
+			b.defs[i].closeMatch = def.openMatch
+		}
+	}
+	for _, c := range b.custom {
+		b.insert(c)
+	}
+}
+
+// Register adds a new custom definition. A custom definition replaces the
+// built-in definition with the same name (other than "paragraph").
+func (b *DelimitedBlocks) Register(c CustomDefinition) error {
+	if c.Name == "" {
+		return fmt.Errorf("missing delimited block name")
+	}
+	if def := b.GetDefinition(c.Name); def != nil && (def.custom || c.Name == "paragraph") {
+		return fmt.Errorf("delimited block already defined: %s", c.Name)
+	}
+	if c.OpenMatch == nil {
+		return fmt.Errorf("missing delimited block opening delimiter: %s", c.Name)
+	}
+	if c.OpenMatch.MatchString("") {
+		return fmt.Errorf("delimited block opening delimiter matches the empty string: %s", c.Name)
+	}
+	if c.Before == "" {
+		c.Before = "paragraph"
+	}
+	if c.Before == c.Name || b.GetDefinition(c.Before) == nil {
+		return fmt.Errorf("illegal delimited block name: %s: before: %s", c.Name, c.Before)
+	}
+	b.custom = append(b.custom, c)
+	b.insert(c)
+	return nil
+}
+
+// insert inserts the custom definition before the definition named c.Before
+// and removes the built-in definition it replaces.
+func (b *DelimitedBlocks) insert(c CustomDefinition) {
+	def := Definition{
+		name:       c.Name,
+		openMatch:  c.OpenMatch,
+		closeMatch: c.CloseMatch,
+		openTag:    c.OpenTag,
+		closeTag:   c.CloseTag,
+		verify:     c.Verify,
+		options:    c.Options,
+		custom:     true,
+	}
+	for i := range b.defs {
+		if b.defs[i].name == c.Name {
+			b.defs = append(b.defs[:i], b.defs[i+1:]...)
+			break
+		}
+	}
+	if def.closeMatch == nil {
+		def.closeMatch = def.openMatch
+	}
+	if c.DelimiterFilter != nil {
+		def.delimiterFilter = func(b *DelimitedBlocks, match []string, _ *Definition) string {
+			return c.DelimiterFilter(match, &b.BlockAttributes.Attrs.BlockAttributes)
+		}
+	}
+	if c.ContentFilter != nil {
+		def.contentFilter = func(_ *DelimitedBlocks, text string, match []string, _ expansion.Options) string {
+			return c.ContentFilter(text, match)
+		}
+	}
+	for i := range b.defs {
+		if b.defs[i].name == c.Before {
+			b.defs = append(b.defs[:i], append([]Definition{def}, b.defs[i:]...)...)
+			return
 		}
 	}
 }
 
 // If the next element in the reader is a valid delimited block render it
 // and return true, else return false.
-func Render(reader *iotext.Reader, writer *iotext.Writer, allowed []string) bool {
+func (b *DelimitedBlocks) Render(reader *iotext.Reader, writer *iotext.Writer, allowed []string) bool {
 	if reader.Eof() {
 		panic("premature eof")
 	}
-	for _, def := range defs {
+	for _, def := range b.defs {
 		if len(allowed) > 0 && stringlist.StringList(allowed).IndexOf(def.name) == -1 {
 			continue
 		}
 		matches := def.openMatch.FindAllStringSubmatch(reader.Cursor(), 1)
 		if matches != nil {
 			match := matches[0]
+			if match[0] == "" {
+				continue // Empty delimiter matches are ignored.
+			}
 			// Escape non-paragraphs.
 			if match[0][0] == '\\' && def.name != "paragraph" {
 				// Drop backslash escape and continue.
@@ -246,71 +393,195 @@ func Render(reader *iotext.Reader, writer *iotext.Writer, allowed []string) bool
 			if def.verify != nil && !def.verify(match) {
 				continue
 			}
+			// Name specific processing only applies to built-in definitions.
+			name := def.name
+			if def.custom {
+				name = ""
+			}
+			source := options.Source{Lines: []string{reader.Cursor()}, LineNos: []int{reader.LineNo()}}
+			saved := b.Options.SetSource(source)
+			defer b.Options.SetSource(saved)
 			// Process opening delimiter.
 			delimiterText := ""
 			if def.delimiterFilter != nil {
-				delimiterText = def.delimiterFilter(match, &def)
+				delimiterText = def.delimiterFilter(b, match, &def)
 			}
 			// Read block content into lines.
 			lines := []string{}
+			lineNos := []int{}
 			if delimiterText != "" {
 				lines = append(lines, delimiterText)
+				lineNos = append(lineNos, source.LineNos[0])
 			}
 			// Read content up to the closing delimiter.
 			reader.Next()
+			start := reader.Pos
 			content := reader.ReadTo(def.closeMatch)
-			if reader.Eof() && stringlist.StringList([]string{"code", "comment", "division", "quote"}).IndexOf(def.name) > -1 {
-				options.ErrorCallback("unterminated " + def.name + " block: " + match[0])
+			contentNos := reader.LineNos[start : start+len(content)]
+			source.Lines = append(source.Lines, content...)
+			source.LineNos = append(source.LineNos, contentNos...)
+			b.Options.SetSource(source)
+			closeLine, closeLineNo := "", 0
+			if !reader.Eof() {
+				closeLine, closeLineNo = reader.Cursor(), reader.LineNo()
+			}
+			b.deprecationWarnings(name, match, content, closeLine, closeLineNo)
+			if reader.Eof() && stringlist.StringList([]string{"admonition", "code", "comment", "division", "quote"}).IndexOf(name) > -1 {
+				b.Options.ErrorCallback(options.UnterminatedBlock, "unterminated "+def.name+" block: "+match[0])
 			}
 			reader.Next() // Skip closing delimiter.
 			lines = append(lines, content...)
+			lineNos = append(lineNos, contentNos...)
 			// Calculate block expansion options.
 			opts := def.options
-			opts.Merge(blockattributes.Attrs.Options)
+			opts.Merge(b.BlockAttributes.Attrs.Options)
 			// Translate block.
 			if !opts.Skip {
 				text := strings.Join(lines, "\n")
 				if def.contentFilter != nil {
-					text = def.contentFilter(text, match, opts)
+					text = def.contentFilter(b, text, match, opts)
 				}
-				opentag := def.openTag
-				if def.name == "html" {
-					text = blockattributes.Inject(text)
+				node := &ast.DelimitedBlock{
+					Name:     def.name,
+					OpenTag:  def.openTag,
+					CloseTag: def.closeTag,
+				}
+				if name == "html" {
+					// HTML block attributes are injected into the block content.
+					text = b.BlockAttributes.Inject(text)
 				} else {
-					opentag = blockattributes.Inject(opentag)
+					node.BlockAttributes = b.BlockAttributes.Consume(def.openTag)
 				}
 				if opts.Container {
-					blockattributes.Attrs.Options.Container = false // Consume before recursing.
-					text = ApiRender(text)
-				} else {
-					text = spans.ReplaceInline(text, opts)
+					b.BlockAttributes.Attrs.Options.Container = false // Consume before recursing.
+					node.Children = b.ApiParse(text, lineNos)
+				} else if nodes := b.highlight(name, text, node.Classes, opts); nodes != nil {
+					node.Children = nodes
+				} else if name != "table" {
+					node.Children = b.Spans.ParseInline(text, opts)
 				}
-				closetag := def.closeTag
-				if def.name == "division" && opentag == "<div>" {
-					// Drop div tags if the opening div has no attributes.
-					opentag = ""
-					closetag = ""
+				var block ast.Node = node
+				switch name {
+				case "paragraph":
+					block = &ast.Paragraph{DelimitedBlock: *node}
+				case "admonition":
+					block = b.admonition(match, node)
+				case "table":
+					table := b.parseTable(text, opts)
+					table.BlockAttributes = node.BlockAttributes
+					block = table
 				}
-				text = opentag + text + closetag
-				writer.Write(text)
-				if text != "" && !reader.Eof() {
-					// Add a trailing "\n" if we"ve written a non-blank line and there are more source lines left.
-					writer.Write("\n")
+				if name == "footnote" {
+					b.Footnotes.Define(match[1], node.Children) // Footnotes are rendered at the end of the document.
+				} else if !ast.IsBlank(block) {
+					writer.Append(block)
+					if !reader.Eof() {
+						// Add a trailing "\n" if we"ve written a non-blank line and there are more source lines left.
+						writer.Append(&ast.Newline{})
+					}
 				}
 			}
+			// Nested blocks have been formatted so the delimiters are formatted last.
+			b.formatDelimiters(name, match, content, contentNos, closeLine, closeLineNo)
 			// Reset consumed Block Attributes expansion options.
-			blockattributes.Attrs.Options = expansion.Options{}
+			b.BlockAttributes.Attrs.Options = expansion.Options{}
 			return true
 		}
 	}
 	return false // No matching delimited block found.
 }
 
+// deprecationWarnings reports deprecated delimited block syntax along with the
+// edits that migrate it. closeLine is the closing delimiter line and closeLineNo
+// is its source line number (zero if the block is unterminated).
+func (b *DelimitedBlocks) deprecationWarnings(name string, match []string, content []string, closeLine string, closeLineNo int) {
+	var edits []options.Edit
+	switch {
+	case name == "code" && match[1][0] == '-':
+		// Migrate to backtick delimiters.
+		delimiter := match[1]
+		edits = append(edits, options.Edit{Old: delimiter, New: strings.Repeat("`", len(delimiter))})
+		if closeLineNo > 0 {
+			edits = append(edits, options.Edit{Line: closeLineNo, Old: delimiter, New: strings.Repeat("`", len(delimiter))})
+		}
+		b.Options.DeprecationWarning("'-' code block delimiters are deprecated: "+match[0], match[0], edits...)
+	case name == "deprecated-macro-expression":
+		// Migrate to a literal value definition.
+		if literalSafe(match, content, closeLineNo) {
+			open := match[0]
+			i := strings.Index(open, "`")
+			edits = append(edits, options.Edit{Old: open, New: open[:i] + "'" + open[i+1:]})
+			edits = append(edits, options.Edit{Line: closeLineNo, Old: closeLine, New: closeLine[:len(closeLine)-1] + "'"})
+		}
+		b.Options.DeprecationWarning("macro expression values are deprecated (the value is literal): "+match[0], match[0], edits...)
+	}
+}
+
+// literalSafe returns true if a multi-line macro expression value definition
+// can be migrated to a literal value definition. Lines ending with a single
+// quote would prematurely close the literal value so they cannot be migrated.
+func literalSafe(match []string, content []string, closeLineNo int) bool {
+	if closeLineNo == 0 || strings.HasSuffix(match[1], "'") {
+		return false
+	}
+	for _, line := range content[:len(content)-1] {
+		if strings.HasSuffix(line, "'") {
+			return false
+		}
+	}
+	return true
+}
+
+// formatDelimiters saves the format edits that normalize the delimiters of a
+// terminated block. Delimiters are shortened to the shortest length that does
+// not close the block prematurely, class names follow the delimiter without
+// separating spaces and multi-line macro definitions are single-quoted with a single space on
+// either side of the equals sign. Deprecated '-' code block delimiters are
+// left to migration.
+func (b *DelimitedBlocks) formatDelimiters(name string, match []string, content []string, contentNos []int, closeLine string, closeLineNo int) {
+	if !b.Formatter.IsFormatting() || closeLineNo == 0 {
+		return
+	}
+	var open, close string
+	switch name {
+	case "macro-definition", "deprecated-macro-expression":
+		if name == "deprecated-macro-expression" && !literalSafe(match, content, closeLineNo) {
+			return
+		}
+		macro := regexp.MustCompile(`^{([\w\-]+\??)}`).FindString(match[0])
+		open = macro + " = '" + match[1]
+		close = closeLine[:len(closeLine)-1] + "'"
+	case "admonition", "code", "division", "quote":
+		delimiter := match[1]
+		if delimiter[0] == '-' {
+			return
+		}
+		lines := map[string]bool{}
+		for i, line := range content {
+			lines[b.Formatter.Formatted(contentNos[i], line)] = true
+		}
+		close = delimiter[:2]
+		for lines[close] {
+			close += delimiter[:1]
+		}
+		if name == "admonition" {
+			open = close + match[0][len(delimiter):]
+		} else {
+			open = close + strings.TrimSpace(match[2])
+		}
+	default:
+		return
+	}
+	b.Formatter.Edit(
+		options.Edit{Old: match[0], New: open},
+		options.Edit{Line: closeLineNo, Old: closeLine, New: close})
+}
+
 // Return block definition or nil if not found.
-func GetDefinition(name string) *Definition {
-	for i, def := range defs {
+func (b *DelimitedBlocks) GetDefinition(name string) *Definition {
+	for i, def := range b.defs {
 		if def.name == name {
-			return &defs[i]
+			return &b.defs[i]
 		}
 	}
 	return nil
@@ -318,15 +589,15 @@ func GetDefinition(name string) *Definition {
 
 // Update existing named definition.
 // Value syntax: <open-tag>|<close-tag> block-options
-func SetDefinition(name string, value string) {
-	def := GetDefinition(name)
+func (b *DelimitedBlocks) SetDefinition(name string, value string) {
+	def := b.GetDefinition(name)
 	if def == nil {
-		options.ErrorCallback("illegal delimited block name: " + name + ": |" + name + "|='" + value + "'")
+		b.Options.ErrorCallback(options.IllegalBlockDefinition, "illegal delimited block name: "+name+": |"+name+"|='"+value+"'")
 		return
 	}
 	match := regexp.MustCompile(`^(?:(<[a-zA-Z].*>)\|(<[a-zA-Z/].*>))?(?:\s*)?([+-][ \w+-]+)?$`).FindStringSubmatch(strings.TrimSpace(value))
 	if match == nil {
-		options.ErrorCallback("illegal delimited block definition: |" + name + "|='" + value + "'")
+		b.Options.ErrorCallback(options.IllegalBlockDefinition, "illegal delimited block definition: |"+name+"|='"+value+"'")
 		return
 	}
 	if strings.Contains(value, "|") {
@@ -334,33 +605,162 @@ func SetDefinition(name string, value string) {
 		def.closeTag = match[2]
 	}
 	if match[3] != "" {
-		def.options.Merge(expansion.Parse(match[3]))
+		def.options.Merge(expansion.Parse(match[3], b.Options))
 	}
 }
 
 // delimiterFilter that returns opening delimiter line text from match group $1.
-func delimiterTextFilter(match []string, _ *Definition) string {
+func delimiterTextFilter(_ *DelimitedBlocks, match []string, _ *Definition) string {
 	return match[1]
 }
 
 // delimiterFilter for code, division and quote blocks.
 // Inject $2 into block class attribute, set close delimiter to $1.
-func classInjectionFilter(match []string, def *Definition) string {
+func classInjectionFilter(b *DelimitedBlocks, match []string, def *Definition) string {
 	if p1 := strings.TrimSpace(match[2]); p1 != "" {
-		blockattributes.Attrs.Classes = p1
+		b.BlockAttributes.Attrs.Classes = p1
 	}
 	// closeMatch must be set at runtime so we correctly match closing delimiter
 	def.closeMatch = regexp.MustCompile("^" + regexp.QuoteMeta(match[1]) + "$")
 	return ""
 }
 
+// delimiterFilter for admonition blocks.
+func admonitionFilter(b *DelimitedBlocks, match []string, def *Definition) string {
+	// closeMatch must be set at runtime so we correctly match closing delimiter
+	def.closeMatch = regexp.MustCompile("^" + regexp.QuoteMeta(match[1]) + "$")
+	return ""
+}
+
 // contentFilter for multi-line macro definitions.
-func macroDefContentFilter(text string, match []string, opts expansion.Options) string {
+func macroDefContentFilter(b *DelimitedBlocks, text string, match []string, opts expansion.Options) string {
 	quote := string(match[0][len(match[0])-len(match[1])-1])                           // The leading macro value quote character.
 	name := regexp.MustCompile(`^{([\w\-]+\??)}`).FindStringSubmatch(match[0])[1]      // Extract macro name from opening delimiter.
 	text = regexp.MustCompile("("+quote+`) *\\\n`).ReplaceAllString(text, "$1\n")      // Unescape line-continuations.
 	text = regexp.MustCompile("("+quote+` *[\\]+)\\\n`).ReplaceAllString(text, "$1\n") // Unescape escaped line-continuations.
-	text = spans.ReplaceInline(text, opts)                                             // Expand macro invocations.
-	macros.SetValue(name, text, quote)
+	text = b.Spans.ReplaceInline(text, opts)                                           // Expand macro invocations.
+	b.Macros.SetValue(name, text)
 	return ""
 }
+
+// admonition returns the admonition block node. match[2] is the admonition
+// kind and match[3] is the optional title (defaults to the capitalized kind).
+func (b *DelimitedBlocks) admonition(match []string, node *ast.DelimitedBlock) *ast.Admonition {
+	kind := strings.ToLower(match[2])
+	result := &ast.Admonition{
+		BlockAttributes: node.BlockAttributes,
+		Kind:            ast.AdmonitionKind(kind),
+		Children:        node.Children,
+	}
+	if match[3] != "" {
+		result.Title = b.Spans.ParseInline(match[3], expansion.Options{Macros: true, Spans: true})
+	} else {
+		result.Title = []ast.Node{&ast.Text{Text: strings.ToUpper(kind[:1]) + kind[1:]}}
+	}
+	return result
+}
+
+// highlight returns the syntax highlighted nodes of code block text (nil if
+// the block is not highlighted). Highlighting is enabled by a non-blank
+// --highlight macro and the language is the first block class name that has a
+// lexer. Highlighted tokens are Replacement nodes so their source text is
+// retained.
+func (b *DelimitedBlocks) highlight(name string, text string, classes string, opts expansion.Options) []ast.Node {
+	if name != "code" || !opts.Specials || opts.Macros || opts.Spans || !b.Macros.IsNotBlank("--highlight") {
+		return nil
+	}
+	for _, class := range strings.Fields(classes) {
+		if lexer := highlight.Lookup(class); lexer != nil {
+			var result []ast.Node
+			for _, token := range lexer.Tokens(text) {
+				if token.Class == "" {
+					result = append(result, &ast.Text{Text: token.Text})
+				} else {
+					result = append(result, &ast.Replacement{
+						Source: token.Text,
+						HTML:   `<span class="` + token.Class + `">` + str.ReplaceSpecialChars(token.Text) + "</span>",
+					})
+				}
+			}
+			return result
+		}
+	}
+	return nil
+}
+
+// Matches a table separator row cell. $1 and $2 are the optional alignment colons.
+var MATCH_TABLE_SEPARATOR = regexp.MustCompile(`^(:?)-+(:?)$`)
+
+// parseTable returns the table parsed from pipe table rows. If the second row
+// is a separator row then the first row is the header row and the separator
+// cells set the column alignments.
+func (b *DelimitedBlocks) parseTable(text string, opts expansion.Options) *ast.Table {
+	table := &ast.Table{}
+	var rows [][]string
+	for _, line := range strings.Split(text, "\n") {
+		rows = append(rows, splitTableRow(line))
+	}
+	if len(rows) > 1 && isTableSeparator(rows[1]) {
+		for _, cell := range rows[1] {
+			match := MATCH_TABLE_SEPARATOR.FindStringSubmatch(cell)
+			switch {
+			case match[1] != "" && match[2] != "":
+				table.Align = append(table.Align, "center")
+			case match[1] != "":
+				table.Align = append(table.Align, "left")
+			case match[2] != "":
+				table.Align = append(table.Align, "right")
+			default:
+				table.Align = append(table.Align, "")
+			}
+		}
+		table.Header = b.tableCells(rows[0], opts)
+		rows = rows[2:]
+	}
+	for _, row := range rows {
+		table.Rows = append(table.Rows, b.tableCells(row, opts))
+	}
+	return table
+}
+
+// tableCells returns the table cells parsed from row cell texts.
+func (b *DelimitedBlocks) tableCells(row []string, opts expansion.Options) (result []*ast.TableCell) {
+	for _, text := range row {
+		result = append(result, &ast.TableCell{Children: b.Spans.ParseInline(text, opts)})
+	}
+	return
+}
+
+// isTableSeparator returns true if all the row cells are separator cells.
+func isTableSeparator(row []string) bool {
+	for _, cell := range row {
+		if !MATCH_TABLE_SEPARATOR.MatchString(cell) {
+			return false
+		}
+	}
+	return true
+}
+
+// splitTableRow returns the trimmed cell texts of a pipe table row.
+// Leading and trailing pipes are optional and escaped pipes (\|) are literal.
+func splitTableRow(line string) (result []string) {
+	line = strings.TrimSpace(line)
+	line = strings.TrimPrefix(line, "|")
+	if strings.HasSuffix(line, "|") && !strings.HasSuffix(line, "\\|") {
+		line = line[:len(line)-1]
+	}
+	var cell strings.Builder
+	for i := 0; i < len(line); i++ {
+		switch {
+		case line[i] == '\\' && i+1 < len(line) && line[i+1] == '|':
+			cell.WriteByte('|')
+			i++
+		case line[i] == '|':
+			result = append(result, strings.TrimSpace(cell.String()))
+			cell.Reset()
+		default:
+			cell.WriteByte(line[i])
+		}
+	}
+	return append(result, strings.TrimSpace(cell.String()))
+}
@@ -1,16 +1,20 @@
-package delimitedblocks
+package delimitedblocks_test
 
 import (
 	"testing"
 
 	"github.com/srackham/go-rimu/v11/internal/assert"
+	"github.com/srackham/go-rimu/v11/internal/delimitedblocks"
+	"github.com/srackham/go-rimu/v11/internal/document"
 	"github.com/srackham/go-rimu/v11/internal/iotext"
 )
 
 func TestInit(t *testing.T) {
-	Init()
-	assert.Equal(t, len(DEFAULT_DEFS), len(defs))
-	assert.NotEqual(t, &DEFAULT_DEFS, &defs)
+	b := document.New().DelimitedBlocks
+	b.Init()
+	defs := b.Definitions()
+	assert.Equal(t, len(delimitedblocks.DEFAULT_DEFS), len(defs))
+	assert.NotEqual(t, &delimitedblocks.DEFAULT_DEFS[0], &defs[0])
 }
 
 func TestRender(t *testing.T) {
@@ -23,26 +27,28 @@ func TestRender(t *testing.T) {
 	}
 	var reader *iotext.Reader
 	var writer *iotext.Writer
+	b := document.New().DelimitedBlocks
 	for _, tt := range tests {
 		reader = iotext.NewReader(tt.in)
 		writer = iotext.NewWriter()
-		assert.True(t, Render(reader, writer, nil))
+		assert.True(t, b.Render(reader, writer, nil))
 		assert.Equal(t, tt.want, writer.String())
 	}
 }
 
 func TestGetDefinition(t *testing.T) {
-	Init()
-	def := GetDefinition("paragraph")
-	assert.Equal(t, "<p>", def.openTag)
-	def = GetDefinition("foo")
+	b := document.New().DelimitedBlocks
+	def := b.GetDefinition("paragraph")
+	openTag, _ := def.Tags()
+	assert.Equal(t, "<p>", openTag)
+	def = b.GetDefinition("foo")
 	assert.True(t, def == nil)
 }
 
 func TestSetDefinition(t *testing.T) {
-	Init()
-	SetDefinition("indented", "<foo>|</foo>")
-	def := GetDefinition("indented")
-	assert.Equal(t, "<foo>", def.openTag)
-	assert.Equal(t, "</foo>", def.closeTag)
+	b := document.New().DelimitedBlocks
+	b.SetDefinition("indented", "<foo>|</foo>")
+	openTag, closeTag := b.GetDefinition("indented").Tags()
+	assert.Equal(t, "<foo>", openTag)
+	assert.Equal(t, "</foo>", closeTag)
 }
@@ -0,0 +1,11 @@
+package delimitedblocks
+
+// Definitions returns the current definitions (for external tests).
+func (b *DelimitedBlocks) Definitions() []Definition {
+	return b.defs
+}
+
+// Tags returns the definition's opening and closing tags (for external tests).
+func (def *Definition) Tags() (openTag string, closeTag string) {
+	return def.openTag, def.closeTag
+}
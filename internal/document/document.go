@@ -1,8 +1,17 @@
 package document
 
 import (
+	"io"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/srackham/go-rimu/v11/internal/ast"
 	"github.com/srackham/go-rimu/v11/internal/blockattributes"
 	"github.com/srackham/go-rimu/v11/internal/delimitedblocks"
+	"github.com/srackham/go-rimu/v11/internal/footnotes"
+	"github.com/srackham/go-rimu/v11/internal/format"
+	"github.com/srackham/go-rimu/v11/internal/frontmatter"
 	"github.com/srackham/go-rimu/v11/internal/iotext"
 	"github.com/srackham/go-rimu/v11/internal/lineblocks"
 	"github.com/srackham/go-rimu/v11/internal/lists"
@@ -10,44 +19,472 @@ import (
 	"github.com/srackham/go-rimu/v11/internal/options"
 	"github.com/srackham/go-rimu/v11/internal/quotes"
 	"github.com/srackham/go-rimu/v11/internal/replacements"
+	"github.com/srackham/go-rimu/v11/internal/spans"
+	"github.com/srackham/go-rimu/v11/internal/toc"
+	"github.com/srackham/go-rimu/v11/internal/utils/stringlist"
 )
 
-func init() {
-	// Dependency injectiion so we can use document functions in imported packages without incuring import cycle errors.
-	options.ApiInit = Init
-	delimitedblocks.ApiRender = Render
+// Document contains all Rimu state.
+// Documents are isolated from one another but a Document is not safe for concurrent use.
+type Document struct {
+	Options         *options.Options
+	Quotes          *quotes.Quotes
+	Replacements    *replacements.Replacements
+	Spans           *spans.Spans
+	Macros          *macros.Macros
+	BlockAttributes *blockattributes.BlockAttributes
+	DelimitedBlocks *delimitedblocks.DelimitedBlocks
+	Footnotes       *footnotes.Footnotes
+	Formatter       *format.Formatter
+	LineBlocks      *lineblocks.LineBlocks
+	Lists           *lists.Lists
+	Metadata        map[string]string // Front matter of the most recently rendered document.
+	Headings        []toc.Heading     // Headings of the most recently rendered document.
+	pending         bool              // True once output that is generated at the end of the document has been parsed.
+}
+
+// New returns a new initialised Document.
+func New() *Document {
+	doc := &Document{}
+	doc.Options = &options.Options{}
+	doc.Quotes = &quotes.Quotes{}
+	doc.Replacements = &replacements.Replacements{
+		Options: doc.Options,
+	}
+	doc.Spans = &spans.Spans{
+		Options:      doc.Options,
+		Quotes:       doc.Quotes,
+		Replacements: doc.Replacements,
+	}
+	doc.Macros = &macros.Macros{
+		Options: doc.Options,
+		Spans:   doc.Spans,
+	}
+	doc.BlockAttributes = &blockattributes.BlockAttributes{
+		Options: doc.Options,
+		Spans:   doc.Spans,
+	}
+	doc.Footnotes = &footnotes.Footnotes{
+		Options:         doc.Options,
+		BlockAttributes: doc.BlockAttributes,
+	}
+	doc.Formatter = &format.Formatter{
+		Options: doc.Options,
+	}
+	doc.DelimitedBlocks = &delimitedblocks.DelimitedBlocks{
+		Options:         doc.Options,
+		Formatter:       doc.Formatter,
+		Spans:           doc.Spans,
+		Macros:          doc.Macros,
+		BlockAttributes: doc.BlockAttributes,
+		Footnotes:       doc.Footnotes,
+	}
+	doc.LineBlocks = &lineblocks.LineBlocks{
+		Options:         doc.Options,
+		Formatter:       doc.Formatter,
+		Quotes:          doc.Quotes,
+		Replacements:    doc.Replacements,
+		Spans:           doc.Spans,
+		Macros:          doc.Macros,
+		BlockAttributes: doc.BlockAttributes,
+		DelimitedBlocks: doc.DelimitedBlocks,
+	}
+	doc.Lists = &lists.Lists{
+		Options:         doc.Options,
+		Formatter:       doc.Formatter,
+		Spans:           doc.Spans,
+		BlockAttributes: doc.BlockAttributes,
+		LineBlocks:      doc.LineBlocks,
+		DelimitedBlocks: doc.DelimitedBlocks,
+	}
+	// Dependency injection so we can use document functions in imported packages without incuring import cycle errors.
+	doc.Options.ApiInit = doc.Init
+	doc.Spans.MacrosRender = doc.Macros.Render
+	doc.DelimitedBlocks.ApiParse = doc.parse
+	doc.LineBlocks.ApiParse = doc.parse
+	// Footnote references are processed after the built-in replacements (like registered replacements).
+	doc.Replacements.Register(footnotes.MATCH_REFERENCE.String(), doc.Footnotes.Reference)
+	doc.Init()
+	return doc
 }
 
 // Init initialises Rimu state.
-func Init() {
-	blockattributes.Init()
-	options.Init()
-	delimitedblocks.Init()
-	macros.Init()
-	quotes.Init()
-	replacements.Init()
+func (doc *Document) Init() {
+	doc.BlockAttributes.Init()
+	doc.Options.Init()
+	doc.DelimitedBlocks.Init()
+	doc.Footnotes.Init()
+	doc.Macros.Init()
+	doc.Quotes.Init()
+	doc.Replacements.Init()
+}
+
+// UpdateOptions processes the API render options.
+func (doc *Document) UpdateOptions(opts options.RenderOptions) {
+	doc.Options.UpdateOptions(opts)
+	if opts.Macros != nil {
+		doc.Macros.Preload(opts.Macros)
+	}
 }
 
 // Render source text to HTML string.
-func Render(source string) string {
+func (doc *Document) Render(source string) string {
+	return ast.RenderHTML(doc.Parse(source))
+}
+
+// Parse source text to a document tree.
+func (doc *Document) Parse(source string) *ast.Document {
+	doc.Options.SetSource(options.Source{})
+	defer doc.Options.SetSource(options.Source{})
+	if !utf8.ValidString(source) {
+		doc.Options.ErrorCallback(options.InvalidUTF8, "invalid UTF-8 input")
+		source = ""
+	}
 	reader := iotext.NewReader(source)
+	doc.frontMatter(reader)
 	writer := iotext.NewWriter()
+	doc.startDocument()
+	doc.render(reader, writer, nil)
+	doc.endDocument(writer)
+	return &ast.Document{Children: writer.Buffer, Metadata: doc.Metadata}
+}
+
+// startDocument resets per-document state before a document is parsed.
+// The --toc-html macro expands to a placeholder until the document's table of
+// contents is generated.
+func (doc *Document) startDocument() {
+	doc.LineBlocks.StartDocument()
+	doc.Lists.StartDocument()
+	doc.Macros.StartDocument()
+	doc.Footnotes.Init()
+	doc.Macros.Preload(map[string]string{"--toc-html": toc.PLACEHOLDER})
+	doc.pending = false
+}
+
+// endDocument appends the footnotes section, resolves the footnote references,
+// generates the table of contents and resolves the cross-references once the
+// document has been parsed.
+func (doc *Document) endDocument(writer *iotext.Writer) {
+	var texts []string
+	replaceText(placeholders(writer.Buffer, footnotes.PLACEHOLDER), func(text string) string {
+		texts = append(texts, text)
+		return text
+	})
+	doc.Footnotes.Retain(texts...)
+	if section := doc.Footnotes.Section(); section != nil {
+		if n := len(writer.Buffer); n > 0 {
+			if _, ok := writer.Buffer[n-1].(*ast.Newline); !ok {
+				writer.Append(&ast.Newline{})
+			}
+		}
+		writer.Append(section)
+	}
+	replaceText(placeholders(writer.Buffer, footnotes.PLACEHOLDER), doc.Footnotes.Resolve)
+	doc.tableOfContents(placeholders(writer.Buffer, toc.PLACEHOLDER))
+	for _, n := range crossReferences(writer.Buffer) {
+		if html := toc.CrossReference(doc.Headings, toc.MATCH_CROSS_REFERENCE.FindStringSubmatch(n.Source)[1]); html != "" {
+			n.HTML = html
+		}
+	}
+}
+
+// holdOutput returns true once a table of contents element, --toc-html macro,
+// footnote reference or (if headers are numbered) cross-reference has been
+// parsed: streamed output is held back until they are generated (when the
+// whole document has been parsed).
+func (doc *Document) holdOutput(writer *iotext.Writer) bool {
+	if !doc.pending {
+		doc.pending = len(doc.LineBlocks.TOCs) > 0 || doc.Footnotes.IsReferenced() ||
+			len(placeholders(writer.Buffer, toc.PLACEHOLDER)) > 0 ||
+			doc.Macros.IsNotBlank("--header-numbers") && len(crossReferences(writer.Buffer)) > 0
+	}
+	return doc.pending
+}
+
+// crossReferences returns the <#id> link Replacement nodes, their link text
+// is replaced by the title of the numbered heading with the id.
+func crossReferences(nodes []ast.Node) (result []*ast.Replacement) {
+	for _, n := range nodes {
+		ast.Walk(n, func(n ast.Node) bool {
+			if r, ok := n.(*ast.Replacement); ok {
+				if match := toc.MATCH_CROSS_REFERENCE.FindStringSubmatch(r.Source); match != nil && r.HTML == `<a href="#`+match[1]+`">#`+match[1]+`</a>` {
+					result = append(result, r)
+				}
+			}
+			return true
+		})
+	}
+	return
+}
+
+// placeholders returns the HTML, Text and Replacement nodes whose text contains
+// placeholder.
+func placeholders(nodes []ast.Node, placeholder string) (result []ast.Node) {
+	for _, n := range nodes {
+		ast.Walk(n, func(n ast.Node) bool {
+			switch t := n.(type) {
+			case *ast.HTML:
+				if strings.Contains(t.Text, placeholder) {
+					result = append(result, n)
+				}
+			case *ast.Text:
+				if strings.Contains(t.Text, placeholder) {
+					result = append(result, n)
+				}
+			case *ast.Replacement:
+				if strings.Contains(t.HTML, placeholder) {
+					result = append(result, n)
+				}
+			}
+			return true
+		})
+	}
+	return
+}
+
+// replaceText replaces the text of HTML, Text and Replacement nodes with the
+// result of calling f with the text.
+func replaceText(nodes []ast.Node, f func(text string) string) {
+	for _, n := range nodes {
+		switch n := n.(type) {
+		case *ast.HTML:
+			n.Text = f(n.Text)
+		case *ast.Text:
+			n.Text = f(n.Text)
+		case *ast.Replacement:
+			n.HTML = f(n.HTML)
+		}
+	}
+}
+
+// tableOfContents generates the document's table of contents elements,
+// replaces the --toc-html macro placeholders in macros with the document's
+// table of contents and sets the --toc-html macro to it.
+// Headers without an id are allocated a slugified id if the document contains
+// a table of contents.
+func (doc *Document) tableOfContents(macros []ast.Node) {
+	doc.Headings = nil
+	for i, h := range doc.LineBlocks.Headers {
+		if h.ID == "" && (len(doc.LineBlocks.TOCs) > 0 || len(macros) > 0) {
+			h.ID = doc.BlockAttributes.NewID(toc.Text(h.Children...))
+		}
+		doc.Headings = append(doc.Headings, toc.NewHeading(h, doc.LineBlocks.HeaderLines[i]))
+	}
+	html := toc.HTML(doc.Headings)
+	for _, n := range doc.LineBlocks.TOCs {
+		n.Text = html
+		if html == "" {
+			n.BlockAttributes = ast.BlockAttributes{}
+		}
+	}
+	replaceText(macros, func(text string) string {
+		return strings.ReplaceAll(text, toc.PLACEHOLDER, html)
+	})
+	doc.Macros.Preload(map[string]string{"--toc-html": html})
+	doc.LineBlocks.Headers = nil
+	doc.LineBlocks.HeaderLines = nil
+	doc.LineBlocks.TOCs = nil
+}
+
+// frontMatter reads the document front matter (if it is enabled) and sets
+// the Metadata and a "meta-" prefixed macro for each field.
+func (doc *Document) frontMatter(reader *iotext.Reader) {
+	doc.Metadata = nil
+	if !doc.Options.IsFrontMatter() {
+		return
+	}
+	doc.Metadata = frontmatter.Read(reader)
+	macros := map[string]string{}
+	for key, value := range doc.Metadata {
+		macros["meta-"+key] = value
+	}
+	doc.Macros.Preload(macros)
+}
+
+// Migrate returns source text with deprecated syntax replaced by its modern
+// equivalent. Deprecation warnings are reported by the callback.
+func (doc *Document) Migrate(source string) string {
+	doc.Options.CollectEdits(true)
+	doc.Parse(source)
+	edits := doc.Options.CollectEdits(false)
+	// Definitions inserted at the start of the document follow the front matter.
+	bodyLineNo := doc.bodyLineNo(source)
+	for i := range edits {
+		if edits[i].Old == "" && edits[i].Line == 1 {
+			edits[i].Line = bodyLineNo
+		}
+	}
+	return applyEdits(source, edits)
+}
+
+// bodyLineNo returns the line number of the first non-blank source line
+// following the front matter (1 if there is no front matter).
+func (doc *Document) bodyLineNo(source string) int {
+	if !doc.Options.IsFrontMatter() {
+		return 1
+	}
+	reader := iotext.NewReader(source)
+	if frontmatter.Read(reader) == nil {
+		return 1
+	}
+	for !reader.Eof() && strings.TrimSpace(reader.Cursor()) == "" {
+		reader.Next()
+	}
+	return reader.Pos + 1
+}
+
+// applyEdits applies deprecated syntax edits to source text.
+// Edits whose deprecated text is not in the source line (e.g. deprecated
+// syntax generated by macro expansion) are skipped.
+func applyEdits(source string, edits []options.Edit) string {
+	if len(edits) == 0 {
+		return source
+	}
+	lines := regexp.MustCompile(`\r\n|\r|\n`).Split(source, -1)
+	inserts := map[int]stringlist.StringList{} // Lines inserted before line number.
+	for _, edit := range edits {
+		if edit.Line < 1 || edit.Line > len(lines) {
+			continue
+		}
+		if edit.Old == "" {
+			if !inserts[edit.Line].Contains(edit.New) {
+				inserts[edit.Line] = append(inserts[edit.Line], edit.New)
+			}
+			continue
+		}
+		lines[edit.Line-1] = strings.Replace(lines[edit.Line-1], edit.Old, edit.New, 1)
+	}
+	var result []string
+	for i, line := range lines {
+		result = append(result, inserts[i+1]...)
+		result = append(result, line)
+	}
+	return strings.Join(result, "\n")
+}
+
+// Format returns source text in the canonical Rimu source format: headers
+// have '#' markers, list IDs and indents are determined by list nesting, runs
+// of blank lines between blocks are collapsed to a single blank line (blank
+// lines are not inserted between adjacent blocks), delimiters are as short as
+// possible, Block Attributes are separated by single spaces and macro
+// definition values are single-quoted. The source is parsed to collect the format edits and
+// renderings of the formatted source are checked with new documents returned
+// by newDoc: format edits that change the rendered HTML are dropped.
+// Definitions are never reordered or changed.
+func (doc *Document) Format(source string, newDoc func() *Document) string {
+	doc.Formatter.Collect(true)
+	doc.Parse(source)
+	edits := doc.Formatter.Collect(false)
+	want := newDoc().Render(source)
+	result := format.Apply(source, format.Verify(source, edits, func(text string) bool {
+		return newDoc().Render(text) == want
+	}))
+	// Terminate the last line with a newline.
+	if result != "" && !strings.HasSuffix(result, "\n") && newDoc().Render(result+"\n") == want {
+		result += "\n"
+	}
+	return result
+}
+
+// formatBlankLines saves the format edits that leave a single blank line
+// between the blocks preceding and following the blank lines skipped from
+// reader line index start. Blank lines at the start of the reader and those
+// following a block's blank closing delimiter line are deleted. The last of
+// the blank lines at the end of the reader is kept because it determines
+// whether the rendered HTML ends with a newline.
+func (doc *Document) formatBlankLines(reader *iotext.Reader, start int) {
+	if !doc.Formatter.IsFormatting() || start == reader.Pos {
+		return
+	}
+	keep := -1 // Line index of the kept blank line.
+	switch {
+	case reader.Eof():
+		keep = reader.Pos - 1
+	case start > 0 && reader.Lines[start-1] != "":
+		keep = start
+	}
+	for i := start; i < reader.Pos; i++ {
+		edit := options.Edit{Line: reader.LineNos[i], Old: reader.Lines[i]}
+		if i == keep {
+			edit.New = ""
+		} else {
+			edit.Delete = true
+		}
+		doc.Formatter.Edit(edit)
+	}
+}
+
+// parse returns the document tree nodes parsed from source text.
+// lineNos contains the source line numbers of the source text lines, if it is
+// nil then lines are numbered from 1.
+func (doc *Document) parse(source string, lineNos []int) []ast.Node {
+	reader := iotext.NewReader(source)
+	if len(lineNos) == len(reader.Lines) {
+		reader.LineNos = append([]int(nil), lineNos...)
+	} else if len(lineNos) > 0 {
+		// The number of lines has been changed by a block content filter.
+		for i := range reader.LineNos {
+			reader.LineNos[i] += lineNos[0] - 1
+		}
+	}
+	writer := iotext.NewWriter()
+	doc.render(reader, writer, nil)
+	return writer.Buffer
+}
+
+// RenderTo renders source text read from src to HTML which is written to out.
+// Source lines are read on demand and each top-level block is written to
+// out as soon as it has been rendered.
+// Returns the first read or write error.
+func (doc *Document) RenderTo(out io.Writer, src io.Reader) error {
+	doc.Options.SetSource(options.Source{})
+	defer doc.Options.SetSource(options.Source{})
+	reader := iotext.NewStreamReader(src)
+	doc.frontMatter(reader)
+	doc.startDocument()
+	err := doc.render(reader, iotext.NewWriter(), out)
+	if reader.Err == iotext.ErrInvalidUTF8 {
+		doc.Options.SetSource(options.Source{Lines: []string{""}, LineNos: []int{reader.LineNo()}})
+		doc.Options.ErrorCallback(options.InvalidUTF8, reader.Err.Error())
+	} else if reader.Err != nil {
+		return reader.Err
+	}
+	return err
+}
+
+// render renders reader lines to writer.
+// If out is not nil then rendered blocks are flushed from writer to out.
+func (doc *Document) render(reader *iotext.Reader, writer *iotext.Writer, out io.Writer) error {
 	for !reader.Eof() {
+		if out != nil && !doc.holdOutput(writer) {
+			if _, err := writer.WriteTo(out); err != nil {
+				return err
+			}
+			reader.Discard()
+		}
+		start := reader.Pos
 		reader.SkipBlankLines()
+		doc.formatBlankLines(reader, start)
 		if reader.Eof() {
 			break
 		}
-		if lineblocks.Render(reader, writer, nil) {
+		if doc.LineBlocks.Render(reader, writer, nil) {
 			continue
 		}
-		if lists.Render(reader, writer) {
+		if doc.Lists.Render(reader, writer) {
 			continue
 		}
-		if delimitedblocks.Render(reader, writer, nil) {
+		if doc.DelimitedBlocks.Render(reader, writer, nil) {
 			continue
 		}
 		// This code should never be executed (normal paragraphs should match anything).
 		panic("no matching delimited block found")
 	}
-	return writer.String()
+	if out != nil {
+		doc.endDocument(writer)
+		if _, err := writer.WriteTo(out); err != nil {
+			return err
+		}
+	}
+	return nil
 }
@@ -17,12 +17,16 @@ type Options struct {
 	Skip      bool
 	Spans     bool // Span substitution also expands special characters.
 	Specials  bool
+	// Typography enables typographic replacements (curly quotes, dashes and
+	// ellipses) when spans are processed.
+	Typography bool
 	// xxxMerge specify if the Xxx field has been set.
-	containerMerge bool
-	macrosMerge    bool
-	skipMerge      bool
-	spansMerge     bool
-	specialsMerge  bool
+	containerMerge  bool
+	macrosMerge     bool
+	skipMerge       bool
+	spansMerge      bool
+	specialsMerge   bool
+	typographyMerge bool
 }
 
 // Merge copies expansion options that are set from from to to.
@@ -47,18 +51,33 @@ func (to *Options) Merge(from Options) {
 		to.Specials = from.Specials
 		to.specialsMerge = true
 	}
+	if from.typographyMerge {
+		to.Typography = from.Typography
+		to.typographyMerge = true
+	}
+}
+
+// IsTypography returns true if typographic replacements are enabled. If the
+// Typography option has not been set by a block option then typographic
+// replacements are enabled if Typography is true or def is true.
+func (o Options) IsTypography(def bool) bool {
+	if o.typographyMerge {
+		return o.Typography
+	}
+	return o.Typography || def
 }
 
 // Parse block-options string and return ExpansionOptions.
-func Parse(optsString string) (result Options) {
+// Errors and safeMode restrictions are reported by apiOptions.
+func Parse(optsString string, apiOptions *options.Options) (result Options) {
 	if optsString != "" {
 		opts := regexp.MustCompile(`\s+`).Split(strings.TrimSpace(optsString), -1)
 		for _, opt := range opts {
-			if options.IsSafeModeNz() && opt == "-specials" {
-				options.ErrorCallback("-specials block option not valid in safeMode")
+			if apiOptions.IsSafeModeNz() && opt == "-specials" {
+				apiOptions.ErrorCallbackNear(options.IllegalBlockOption, "-specials block option not valid in safeMode", opt)
 				continue
 			}
-			if regexp.MustCompile(`^[+-](macros|spans|specials|container|skip)$`).MatchString(opt) {
+			if regexp.MustCompile(`^[+-](macros|spans|specials|container|skip|typography)$`).MatchString(opt) {
 				value := opt[0] == '+'
 				switch opt[1:] {
 				case "container":
@@ -76,9 +95,12 @@ func Parse(optsString string) (result Options) {
 				case "spans":
 					result.Spans = value
 					result.spansMerge = true
+				case "typography":
+					result.Typography = value
+					result.typographyMerge = true
 				}
 			} else {
-				options.ErrorCallback("illegal block option: " + opt)
+				apiOptions.ErrorCallbackNear(options.IllegalBlockOption, "illegal block option: "+opt, opt)
 			}
 		}
 	}
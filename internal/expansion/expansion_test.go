@@ -4,6 +4,7 @@ import (
 	"testing"
 
 	"github.com/srackham/go-rimu/v11/internal/assert"
+	"github.com/srackham/go-rimu/v11/internal/options"
 )
 
 func TestParse(t *testing.T) {
@@ -12,12 +13,28 @@ func TestParse(t *testing.T) {
 		want Options
 	}{
 		{"", Options{}},
-		{"+skip +macros +container +specials +spans", Options{true, true, true, true, true, true, true, true, true, true}},
-		{"+skip +macros +container +specials", Options{true, true, true, false, true, true, true, true, false, true}},
-		{"-skip +macros +container +specials", Options{true, true, false, false, true, true, true, true, false, true}},
+		{"+skip +macros +container +specials +spans", Options{true, true, true, true, true, false, true, true, true, true, true, false}},
+		{"+skip +macros +container +specials", Options{true, true, true, false, true, false, true, true, true, false, true, false}},
+		{"-skip +macros +container +specials", Options{true, true, false, false, true, false, true, true, true, false, true, false}},
+		{"+typography", Options{false, false, false, false, false, true, false, false, false, false, false, true}},
 	}
+	apiOptions := &options.Options{}
+	apiOptions.Init()
 	for _, tt := range tests {
-		got := Parse(tt.opts)
+		got := Parse(tt.opts, apiOptions)
 		assert.Equal(t, tt.want, got)
 	}
 }
+
+func TestIsTypography(t *testing.T) {
+	apiOptions := &options.Options{}
+	apiOptions.Init()
+	assert.False(t, Options{}.IsTypography(false))
+	assert.True(t, Options{}.IsTypography(true))
+	assert.True(t, Options{Typography: true}.IsTypography(false))
+	opts := Options{Spans: true}
+	opts.Merge(Parse("-typography", apiOptions))
+	assert.False(t, opts.IsTypography(true))
+	opts.Merge(Parse("+typography", apiOptions))
+	assert.True(t, opts.IsTypography(false))
+}
@@ -0,0 +1,154 @@
+/*
+  Footnotes.
+*/
+
+package footnotes
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"github.com/srackham/go-rimu/v11/internal/ast"
+	"github.com/srackham/go-rimu/v11/internal/blockattributes"
+	"github.com/srackham/go-rimu/v11/internal/options"
+)
+
+// Matches an inline footnote reference. $1 = label.
+var MATCH_REFERENCE = regexp.MustCompile(`\\?\[\^([\w\-]+)\]`)
+
+// PLACEHOLDER prefixes the footnote reference placeholders that are returned
+// by Reference, they are replaced by Resolve once the document has been parsed.
+const PLACEHOLDER = "<!--rimu-fnref-"
+
+// Matches a footnote reference placeholder. $1 = reference index.
+var MATCH_PLACEHOLDER = regexp.MustCompile(PLACEHOLDER + `(\d+)-->`)
+
+// reference is a parsed footnote reference.
+type reference struct {
+	label  string
+	line   int    // Source line number.
+	column int    // Source column number.
+	html   string // Resolved reference HTML.
+	unused bool   // The placeholder is not in the output.
+}
+
+// definition is a parsed footnote definition.
+type definition struct {
+	children []ast.Node
+	line     int // Source line number.
+	column   int // Source column number.
+}
+
+// Footnotes contains the footnotes of a single document.
+type Footnotes struct {
+	Options         *options.Options
+	BlockAttributes *blockattributes.BlockAttributes
+	refs            []*reference           // Footnote references in document order.
+	defs            map[string]*definition // Footnote definitions keyed by label.
+	labels          []string               // Defined labels in document order.
+}
+
+// Init resets the footnotes at the start of a document.
+func (f *Footnotes) Init() {
+	f.refs = nil
+	f.defs = map[string]*definition{}
+	f.labels = nil
+}
+
+// Reference records the footnote reference matched by MATCH_REFERENCE and
+// returns its placeholder (footnotes can be defined after they are referenced).
+func (f *Footnotes) Reference(match []string) string {
+	line, col := f.Options.Locate(match[0])
+	f.refs = append(f.refs, &reference{label: match[1], line: line, column: col})
+	return PLACEHOLDER + strconv.Itoa(len(f.refs)-1) + "-->"
+}
+
+// IsReferenced returns true if footnote references have been parsed.
+func (f *Footnotes) IsReferenced() bool {
+	return len(f.refs) > 0
+}
+
+// Retain marks the references whose placeholders are not in texts (the
+// rendered output) as unused e.g. references in code quotes, which are
+// rendered verbatim. Unused references are ignored by Section.
+func (f *Footnotes) Retain(texts ...string) {
+	retained := map[int]bool{}
+	for _, text := range texts {
+		for _, match := range MATCH_PLACEHOLDER.FindAllStringSubmatch(text, -1) {
+			i, _ := strconv.Atoi(match[1])
+			retained[i] = true
+		}
+	}
+	for i, ref := range f.refs {
+		ref.unused = !retained[i]
+	}
+}
+
+// Define sets the content of the labeled footnote.
+func (f *Footnotes) Define(label string, children []ast.Node) {
+	if f.defs[label] == nil {
+		f.labels = append(f.labels, label)
+	}
+	line, col := f.Options.Locate("")
+	f.defs[label] = &definition{children: children, line: line, column: col}
+}
+
+// Section resolves the footnote references and returns the document's
+// footnotes section or nil if no footnotes are referenced. Footnotes are
+// numbered in order of first reference. Undefined footnote references are
+// rendered literally. Undefined footnote references and unused footnote
+// definitions are reported.
+func (f *Footnotes) Section() *ast.Footnotes {
+	var notes []*ast.Footnote
+	numbers := map[string]int{} // Footnote numbers keyed by label.
+	for _, ref := range f.refs {
+		if ref.unused {
+			continue
+		}
+		def := f.defs[ref.label]
+		if def == nil {
+			f.Options.ErrorCallbackAt(options.UndefinedFootnote, "undefined footnote: [^"+ref.label+"]", ref.line, ref.column)
+			ref.html = "[^" + ref.label + "]"
+			continue
+		}
+		n := numbers[ref.label]
+		var refID string
+		if n == 0 {
+			n = len(notes) + 1
+			numbers[ref.label] = n
+			notes = append(notes, &ast.Footnote{
+				Label:    ref.label,
+				ID:       f.BlockAttributes.NewID(fmt.Sprintf("fn-%d", n)),
+				RefID:    f.BlockAttributes.NewID(fmt.Sprintf("fnref-%d", n)),
+				Children: def.children,
+			})
+			refID = notes[n-1].RefID
+		} else {
+			refID = f.BlockAttributes.NewID(notes[n-1].RefID)
+		}
+		ref.html = fmt.Sprintf(`<sup class="footnote-ref"><a href="#%s" id="%s">%d</a></sup>`, notes[n-1].ID, refID, n)
+	}
+	for _, label := range f.labels {
+		if numbers[label] == 0 {
+			def := f.defs[label]
+			f.Options.ErrorCallbackAt(options.UnusedFootnote, "unused footnote: [^"+label+"]", def.line, def.column)
+		}
+	}
+	if len(notes) == 0 {
+		return nil
+	}
+	return &ast.Footnotes{Notes: notes}
+}
+
+// Resolve replaces the footnote reference placeholders in text with the
+// resolved footnote references (see Section).
+func (f *Footnotes) Resolve(text string) string {
+	return MATCH_PLACEHOLDER.ReplaceAllStringFunc(text, func(match string) string {
+		i, _ := strconv.Atoi(MATCH_PLACEHOLDER.FindStringSubmatch(match)[1])
+		if i >= len(f.refs) {
+			return match
+		}
+		return f.refs[i].html
+	})
+}
@@ -0,0 +1,57 @@
+package footnotes_test
+
+import (
+	"testing"
+
+	"github.com/srackham/go-rimu/v11/internal/assert"
+	"github.com/srackham/go-rimu/v11/internal/ast"
+	"github.com/srackham/go-rimu/v11/internal/document"
+	"github.com/srackham/go-rimu/v11/internal/options"
+)
+
+func TestFootnotes(t *testing.T) {
+	f := document.New().Footnotes
+	var got []options.CallbackMessage
+	f.Options.UpdateOptions(options.RenderOptions{Callback: func(message options.CallbackMessage) { got = append(got, message) }})
+	f.Options.SetSource(options.Source{Lines: []string{"x[^c] [^b] [^a] [^b]"}, LineNos: []int{3}})
+	assert.True(t, f.Section() == nil)
+	refs := f.Reference([]string{"[^c]", "c"}) + f.Reference([]string{"[^b]", "b"}) + f.Reference([]string{"[^a]", "a"}) + f.Reference([]string{"[^b]", "b"})
+	assert.Equal(t, "<!--rimu-fnref-0--><!--rimu-fnref-1--><!--rimu-fnref-2--><!--rimu-fnref-3-->", refs)
+	f.Options.SetSource(options.Source{Lines: []string{"[^a]: A"}, LineNos: []int{5}})
+	f.Define("a", []ast.Node{&ast.Text{Text: "A"}})
+	f.Options.SetSource(options.Source{Lines: []string{"[^b]: B"}, LineNos: []int{7}})
+	f.Define("b", []ast.Node{&ast.Text{Text: "B"}})
+	f.Options.SetSource(options.Source{Lines: []string{"[^d]: D"}, LineNos: []int{9}})
+	f.Define("d", []ast.Node{&ast.Text{Text: "D"}})
+	f.Options.SetSource(options.Source{})
+	section := f.Section()
+	assert.Equal(t, 2, len(section.Notes))
+	assert.Equal(t, "b", section.Notes[0].Label)
+	assert.Equal(t, `<section class="footnotes"><ol><li id="fn-1">B <a href="#fnref-1" class="footnote-backref">&#8617;</a></li><li id="fn-2">A <a href="#fnref-2" class="footnote-backref">&#8617;</a></li></ol></section>`, ast.RenderHTML(section))
+	// Undefined footnote references are rendered literally.
+	assert.Equal(t, `[^c]<sup class="footnote-ref"><a href="#fn-1" id="fnref-1">1</a></sup>`+
+		`<sup class="footnote-ref"><a href="#fn-2" id="fnref-2">2</a></sup>`+
+		`<sup class="footnote-ref"><a href="#fn-1" id="fnref-1-2">1</a></sup>`, f.Resolve(refs))
+	// Undefined references and unused definitions are reported at their source positions.
+	assert.Equal(t, 2, len(got))
+	assert.Equal(t, options.CallbackMessage{Kind: "error", Code: "undefined-footnote", Text: "undefined footnote: [^c]", Line: 3, Column: 2}, got[0])
+	assert.Equal(t, options.CallbackMessage{Kind: "warning", Code: "unused-footnote", Text: "unused footnote: [^d]", Line: 9, Column: 1}, got[1])
+	// References whose placeholders are not retained are ignored.
+	got = nil
+	f = document.New().Footnotes
+	f.Options.UpdateOptions(options.RenderOptions{Callback: func(message options.CallbackMessage) { got = append(got, message) }})
+	f.Define("x", nil)
+	f.Reference([]string{"[^x]", "x"})
+	f.Reference([]string{"[^y]", "y"})
+	f.Retain("")
+	assert.True(t, f.Section() == nil)
+	assert.Equal(t, 1, len(got))
+	assert.Equal(t, "unused-footnote", got[0].Code)
+	// Footnote ids are unique.
+	f = document.New().Footnotes
+	f.BlockAttributes.NewID("fn-1")
+	f.Define("x", nil)
+	ref := f.Reference([]string{"[^x]", "x"})
+	f.Section()
+	assert.Equal(t, `<sup class="footnote-ref"><a href="#fn-1-2" id="fnref-1">1</a></sup>`, f.Resolve(ref))
+}
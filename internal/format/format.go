@@ -0,0 +1,114 @@
+/*
+  Canonical source formatting.
+*/
+
+package format
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/srackham/go-rimu/v11/internal/options"
+)
+
+// Formatter collects the format edits of a single document.
+type Formatter struct {
+	Options   *options.Options
+	edits     []options.Edit // Collected format edits.
+	formatted map[int]string // Formatted source lines keyed by line number.
+	collect   bool
+}
+
+// Collect enables format edit collection and returns the edits collected
+// since the previous call.
+func (f *Formatter) Collect(enable bool) (edits []options.Edit) {
+	edits = f.edits
+	f.edits = nil
+	f.formatted = nil
+	f.collect = enable
+	return
+}
+
+// IsFormatting returns true if format edits are being collected.
+func (f *Formatter) IsFormatting() bool {
+	return f.collect
+}
+
+// Edit saves format edits if format edits are being collected. Edits with a
+// zero Line are located at the start of the current source. Edits that do not
+// change the source line are ignored.
+func (f *Formatter) Edit(edits ...options.Edit) {
+	if !f.collect {
+		return
+	}
+	for _, edit := range edits {
+		if edit.Line == 0 {
+			edit.Line = f.Options.LineNo()
+		}
+		if edit.Line == 0 || edit.Old == edit.New && !edit.Delete {
+			continue
+		}
+		if f.formatted == nil {
+			f.formatted = map[int]string{}
+		}
+		if _, found := f.formatted[edit.Line]; found {
+			continue // Lines are only formatted once.
+		}
+		f.formatted[edit.Line] = edit.New
+		f.edits = append(f.edits, edit)
+	}
+}
+
+// Formatted returns the formatted text of source line number lineNo (text is
+// the unformatted line).
+func (f *Formatter) Formatted(lineNo int, text string) string {
+	if s, found := f.formatted[lineNo]; found {
+		return s
+	}
+	return text
+}
+
+// Apply applies format edits to source text. Edits whose Old text is not the
+// whole source line (e.g. lines generated by macro expansion) are skipped.
+func Apply(source string, edits []options.Edit) string {
+	lines := regexp.MustCompile(`\r\n|\r|\n`).Split(source, -1)
+	deleted := make([]bool, len(lines))
+	for _, edit := range edits {
+		if edit.Line < 1 || edit.Line > len(lines) || lines[edit.Line-1] != edit.Old {
+			continue
+		}
+		if edit.Delete {
+			deleted[edit.Line-1] = true
+		} else {
+			lines[edit.Line-1] = edit.New
+		}
+	}
+	var result []string
+	for i, line := range lines {
+		if !deleted[i] {
+			result = append(result, line)
+		}
+	}
+	return strings.Join(result, "\n")
+}
+
+// Verify returns the edits that can be applied to source text without
+// changing the result of the same function. Edits are checked in batches:
+// a batch that changes the result is split in half until the changing
+// edits are isolated.
+func Verify(source string, edits []options.Edit, same func(text string) bool) []options.Edit {
+	var kept []options.Edit
+	var verify func(batch []options.Edit)
+	verify = func(batch []options.Edit) {
+		switch {
+		case len(batch) == 0:
+		case same(Apply(source, append(append([]options.Edit(nil), kept...), batch...))):
+			kept = append(kept, batch...)
+		case len(batch) > 1:
+			verify(batch[:len(batch)/2])
+			verify(batch[len(batch)/2:])
+		}
+	}
+	verify(edits)
+	return kept
+}
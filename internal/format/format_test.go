@@ -0,0 +1,53 @@
+package format
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/srackham/go-rimu/v11/internal/assert"
+	"github.com/srackham/go-rimu/v11/internal/options"
+)
+
+func TestFormatter(t *testing.T) {
+	f := &Formatter{Options: &options.Options{}}
+	f.Edit(options.Edit{Line: 1, Old: "a", New: "A"})
+	assert.False(t, f.IsFormatting())
+	assert.Equal(t, 0, len(f.Collect(true)))
+	assert.True(t, f.IsFormatting())
+	f.Options.SetSource(options.Source{Lines: []string{"b"}, LineNos: []int{2}})
+	f.Edit(options.Edit{Old: "b", New: "B"}, options.Edit{Line: 3, Old: "c", New: "c"}, options.Edit{Line: 4, Old: "", Delete: true})
+	f.Edit(options.Edit{Line: 2, Old: "b", New: "X"}) // Lines are only formatted once.
+	assert.Equal(t, "B", f.Formatted(2, "b"))
+	assert.Equal(t, "c", f.Formatted(3, "c"))
+	edits := f.Collect(false)
+	assert.Equal(t, 2, len(edits))
+	assert.Equal(t, 2, edits[0].Line)
+	assert.False(t, f.IsFormatting())
+	assert.Equal(t, "b", f.Formatted(2, "b"))
+}
+
+func TestApply(t *testing.T) {
+	edits := []options.Edit{
+		{Line: 1, Old: "a", New: "A"},
+		{Line: 2, Old: "x", New: "X"}, // Skipped: not the whole line.
+		{Line: 3, Old: "", Delete: true},
+		{Line: 9, Old: "z", New: "Z"},
+	}
+	assert.Equal(t, "A\nxy\nc", Apply("a\r\nxy\n\nc", edits))
+}
+
+func TestVerify(t *testing.T) {
+	source := "a\nb\nc\nd\ne\nf\ng\nh"
+	var edits []options.Edit
+	for i, line := range strings.Split(source, "\n") {
+		edits = append(edits, options.Edit{Line: i + 1, Old: line, New: strings.ToUpper(line)})
+	}
+	calls := 0
+	same := func(text string) bool {
+		calls++
+		return !strings.Contains(text, "C") && !strings.Contains(text, "F")
+	}
+	assert.Equal(t, "A\nB\nc\nD\nE\nf\nG\nH", Apply(source, Verify(source, edits, same)))
+	assert.True(t, calls < len(edits)*2)
+	assert.Equal(t, 0, len(Verify(source, nil, same)))
+}
@@ -0,0 +1,76 @@
+/*
+  Document front matter.
+*/
+
+package frontmatter
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/srackham/go-rimu/v11/internal/iotext"
+)
+
+// DELIMITER is the optional front matter opening and closing delimiter line.
+const DELIMITER = "---"
+
+// Matches a front matter field. $1 = key, $2 = value.
+var MATCH_FIELD = regexp.MustCompile(`^([a-zA-Z][\w\-]*)\s*:\s*(.*)$`)
+
+// Read reads front matter from the start of the reader and returns the field
+// values keyed by lower case field name. If there is no front matter the
+// reader is not advanced and nil is returned.
+//
+// Front matter is either a block of "key: value" lines terminated by a blank
+// line or the end of the source, or lines enclosed by "---" delimiter lines
+// (enclosed lines that are not fields, such as comments, are ignored).
+// Values enclosed in single or double quotes are unquoted.
+func Read(reader *iotext.Reader) map[string]string {
+	start := reader.Pos
+	if reader.Eof() {
+		return nil
+	}
+	delimited := reader.Cursor() == DELIMITER
+	if delimited {
+		reader.Next()
+	}
+	result := map[string]string{}
+	for {
+		if reader.Eof() {
+			if delimited {
+				reader.Pos = start // Unterminated.
+				return nil
+			}
+			break
+		}
+		line := reader.Cursor()
+		if delimited && line == DELIMITER || !delimited && strings.TrimSpace(line) == "" {
+			if delimited {
+				reader.Next() // Skip closing delimiter.
+			}
+			break
+		}
+		match := MATCH_FIELD.FindStringSubmatch(line)
+		if match == nil && !delimited {
+			reader.Pos = start // Not front matter.
+			return nil
+		}
+		if match != nil {
+			result[strings.ToLower(match[1])] = unquote(strings.TrimSpace(match[2]))
+		}
+		reader.Next()
+	}
+	if len(result) == 0 && !delimited {
+		reader.Pos = start
+		return nil
+	}
+	return result
+}
+
+// unquote strips enclosing single or double quotes from value.
+func unquote(value string) string {
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+		return value[1 : len(value)-1]
+	}
+	return value
+}
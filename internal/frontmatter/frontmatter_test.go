@@ -0,0 +1,37 @@
+package frontmatter
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/srackham/go-rimu/v11/internal/assert"
+	"github.com/srackham/go-rimu/v11/internal/iotext"
+)
+
+func TestRead(t *testing.T) {
+	tests := []struct {
+		source string
+		want   map[string]string
+		cursor string // Reader cursor line after reading front matter ("" if at EOF).
+	}{
+		{"", nil, ""},
+		{"Hello", nil, "Hello"},
+		{"Title: Hello\nlang: en\n\nText", map[string]string{"title": "Hello", "lang": "en"}, ""},
+		{"title: Hello", map[string]string{"title": "Hello"}, ""},
+		{"title: Hello\nNot a field", nil, "title: Hello"},
+		{"---\ntitle: 'Hello: World'\n# Comment\ndate: \"2026\"\n---\nText", map[string]string{"title": "Hello: World", "date": "2026"}, "Text"},
+		{"---\n---\nText", map[string]string{}, "Text"},
+		{"---\ntitle: Hello", nil, "---"},
+	}
+	for _, tt := range tests {
+		reader := iotext.NewReader(tt.source)
+		got := Read(reader)
+		assert.Equal(t, tt.want == nil, got == nil)
+		assert.Equal(t, fmt.Sprint(tt.want), fmt.Sprint(got))
+		if tt.cursor == "" {
+			assert.True(t, reader.Eof() || reader.Cursor() == "")
+		} else {
+			assert.Equal(t, tt.cursor, reader.Cursor())
+		}
+	}
+}
@@ -0,0 +1,308 @@
+/*
+  Server-side code block syntax highlighting.
+*/
+
+package highlight
+
+import (
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// Token classes (HTML span element class names).
+const (
+	Comment  = "hl-comment"
+	Keyword  = "hl-keyword"
+	Literal  = "hl-literal" // e.g. true, false, nil.
+	Meta     = "hl-meta"    // e.g. preprocessor directives, annotations, decorators.
+	Number   = "hl-number"
+	String   = "hl-string"
+	Tag      = "hl-tag"  // Markup tags.
+	Type     = "hl-type" // Built-in types and functions.
+	Variable = "hl-variable"
+)
+
+// Token is a highlighted fragment of source code.
+type Token struct {
+	Class string // Token class (blank for plain text).
+	Text  string
+}
+
+type rule struct {
+	class string // Blank for plain text.
+	match *regexp.Regexp
+}
+
+// Lexer splits source code into highlighted tokens.
+type Lexer struct {
+	rules []rule
+}
+
+// language is a lexer specification.
+type language struct {
+	names         []string    // Language name followed by aliases.
+	lineComments  []string    // Line comment prefixes.
+	blockComments [][2]string // Block comment delimiters.
+	strings       []string    // String delimiters.
+	keywords      []string
+	types         []string
+	literals      []string
+	ignoreCase    bool   // Case insensitive keywords, types and literals.
+	extra         []rule // Language specific rules (matched first).
+	markup        bool   // HTML/XML markup.
+}
+
+var (
+	MATCH_NUMBER     = regexp.MustCompile(`^(?:0[xX][\da-fA-F_]+|\d[\d_]*(?:\.\d[\d_]*)?(?:[eE][+-]?\d+)?)`)
+	MATCH_IDENTIFIER = regexp.MustCompile(`^[\pL_$][\pL\pN_$]*`)
+)
+
+var cKeywords = []string{"auto", "break", "case", "const", "continue", "default", "do", "else", "enum", "extern", "for", "goto", "if", "inline", "register", "restrict", "return", "sizeof", "static", "struct", "switch", "typedef", "union", "volatile", "while"}
+var cTypes = []string{"bool", "char", "double", "float", "int", "long", "short", "signed", "size_t", "unsigned", "void"}
+var jsKeywords = []string{"async", "await", "break", "case", "catch", "class", "const", "continue", "debugger", "default", "delete", "do", "else", "export", "extends", "finally", "for", "from", "function", "if", "import", "in", "instanceof", "let", "new", "of", "return", "static", "super", "switch", "this", "throw", "try", "typeof", "var", "void", "while", "yield"}
+var jsLiterals = []string{"false", "Infinity", "NaN", "null", "true", "undefined"}
+
+var languages = []language{
+	{
+		names:         []string{"go", "golang"},
+		lineComments:  []string{"//"},
+		blockComments: [][2]string{{"/*", "*/"}},
+		strings:       []string{`"`, `'`, "`"},
+		keywords:      []string{"break", "case", "chan", "const", "continue", "default", "defer", "else", "fallthrough", "for", "func", "go", "goto", "if", "import", "interface", "map", "package", "range", "return", "select", "struct", "switch", "type", "var"},
+		types:         []string{"any", "append", "bool", "byte", "cap", "close", "complex128", "complex64", "copy", "delete", "error", "float32", "float64", "int", "int16", "int32", "int64", "int8", "len", "make", "new", "panic", "print", "println", "recover", "rune", "string", "uint", "uint16", "uint32", "uint64", "uint8", "uintptr"},
+		literals:      []string{"false", "iota", "nil", "true"},
+	},
+	{
+		names:         []string{"c", "h"},
+		lineComments:  []string{"//"},
+		blockComments: [][2]string{{"/*", "*/"}},
+		strings:       []string{`"`, `'`},
+		keywords:      cKeywords,
+		types:         cTypes,
+		literals:      []string{"false", "NULL", "true"},
+		extra:         []rule{{Meta, regexp.MustCompile(`^#[ \t]*\w+`)}},
+	},
+	{
+		names:         []string{"cpp", "c++", "cc", "hpp"},
+		lineComments:  []string{"//"},
+		blockComments: [][2]string{{"/*", "*/"}},
+		strings:       []string{`"`, `'`},
+		keywords:      append([]string{"catch", "class", "constexpr", "delete", "friend", "namespace", "new", "noexcept", "operator", "override", "private", "protected", "public", "template", "this", "throw", "try", "typename", "using", "virtual"}, cKeywords...),
+		types:         append([]string{"std", "string", "vector"}, cTypes...),
+		literals:      []string{"false", "NULL", "nullptr", "true"},
+		extra:         []rule{{Meta, regexp.MustCompile(`^#[ \t]*\w+`)}},
+	},
+	{
+		names:         []string{"java"},
+		lineComments:  []string{"//"},
+		blockComments: [][2]string{{"/*", "*/"}},
+		strings:       []string{`"`, `'`},
+		keywords:      []string{"abstract", "assert", "break", "case", "catch", "class", "continue", "default", "do", "else", "enum", "extends", "final", "finally", "for", "if", "implements", "import", "instanceof", "interface", "new", "package", "private", "protected", "public", "record", "return", "static", "super", "switch", "synchronized", "this", "throw", "throws", "try", "var", "volatile", "while"},
+		types:         []string{"boolean", "byte", "char", "double", "float", "int", "long", "Object", "short", "String", "void"},
+		literals:      []string{"false", "null", "true"},
+		extra:         []rule{{Meta, regexp.MustCompile(`^@\w+`)}},
+	},
+	{
+		names:         []string{"javascript", "js", "jsx", "mjs"},
+		lineComments:  []string{"//"},
+		blockComments: [][2]string{{"/*", "*/"}},
+		strings:       []string{`"`, `'`, "`"},
+		keywords:      jsKeywords,
+		literals:      jsLiterals,
+	},
+	{
+		names:         []string{"typescript", "ts", "tsx"},
+		lineComments:  []string{"//"},
+		blockComments: [][2]string{{"/*", "*/"}},
+		strings:       []string{`"`, `'`, "`"},
+		keywords:      append([]string{"abstract", "as", "declare", "enum", "implements", "interface", "keyof", "namespace", "private", "protected", "public", "readonly", "type"}, jsKeywords...),
+		types:         []string{"any", "bigint", "boolean", "never", "number", "object", "string", "symbol", "unknown"},
+		literals:      jsLiterals,
+	},
+	{
+		names:        []string{"python", "py"},
+		lineComments: []string{"#"},
+		strings:      []string{`"""`, `'''`, `"`, `'`},
+		keywords:     []string{"and", "as", "assert", "async", "await", "break", "case", "class", "continue", "def", "del", "elif", "else", "except", "finally", "for", "from", "global", "if", "import", "in", "is", "lambda", "match", "nonlocal", "not", "or", "pass", "raise", "return", "try", "while", "with", "yield"},
+		types:        []string{"bool", "bytes", "dict", "float", "int", "len", "list", "object", "print", "range", "set", "str", "tuple", "type"},
+		literals:     []string{"False", "None", "True"},
+		extra:        []rule{{Meta, regexp.MustCompile(`^@[\w.]+`)}},
+	},
+	{
+		names:        []string{"ruby", "rb"},
+		lineComments: []string{"#"},
+		strings:      []string{`"`, `'`},
+		keywords:     []string{"alias", "and", "begin", "break", "case", "class", "def", "do", "else", "elsif", "end", "ensure", "for", "if", "in", "module", "next", "not", "or", "redo", "rescue", "retry", "return", "self", "super", "then", "undef", "unless", "until", "when", "while", "yield"},
+		literals:     []string{"false", "nil", "true"},
+		extra:        []rule{{Literal, regexp.MustCompile(`^:\w+`)}, {Variable, regexp.MustCompile(`^@{1,2}\w+`)}},
+	},
+	{
+		names:         []string{"rust", "rs"},
+		lineComments:  []string{"//"},
+		blockComments: [][2]string{{"/*", "*/"}},
+		strings:       []string{`"`},
+		keywords:      []string{"as", "async", "await", "break", "const", "continue", "crate", "dyn", "else", "enum", "extern", "fn", "for", "if", "impl", "in", "let", "loop", "match", "mod", "move", "mut", "pub", "ref", "return", "self", "Self", "static", "struct", "super", "trait", "type", "unsafe", "use", "where", "while"},
+		types:         []string{"bool", "Box", "char", "f32", "f64", "i128", "i16", "i32", "i64", "i8", "isize", "Option", "Result", "str", "String", "u128", "u16", "u32", "u64", "u8", "usize", "Vec"},
+		literals:      []string{"Err", "false", "None", "Ok", "Some", "true"},
+		extra:         []rule{{String, regexp.MustCompile(`^'(?:\\.|[^'\\\n])'`)}, {Meta, regexp.MustCompile(`^\w+!`)}},
+	},
+	{
+		names:        []string{"bash", "sh", "shell", "zsh"},
+		lineComments: []string{"#"},
+		strings:      []string{`"`, `'`},
+		keywords:     []string{"break", "case", "continue", "do", "done", "elif", "else", "esac", "export", "fi", "for", "function", "if", "in", "local", "return", "select", "then", "until", "while"},
+		types:        []string{"cd", "echo", "eval", "exec", "exit", "printf", "read", "set", "shift", "source", "test", "trap", "unset"},
+		extra:        []rule{{Variable, regexp.MustCompile(`^\$(?:\{[^}\n]*\}|\w+|[@*#?$!-])`)}},
+	},
+	{
+		names:    []string{"json"},
+		strings:  []string{`"`},
+		literals: []string{"false", "null", "true"},
+	},
+	{
+		names:        []string{"yaml", "yml"},
+		lineComments: []string{"#"},
+		strings:      []string{`"`, `'`},
+		literals:     []string{"false", "no", "null", "true", "yes"},
+	},
+	{
+		names:         []string{"css"},
+		blockComments: [][2]string{{"/*", "*/"}},
+		strings:       []string{`"`, `'`},
+		extra:         []rule{{Keyword, regexp.MustCompile(`^@[\w-]+`)}, {Number, regexp.MustCompile(`^#[\da-fA-F]{3,8}\b`)}, {"", regexp.MustCompile(`^[\w-]+`)}},
+	},
+	{
+		names:         []string{"sql"},
+		lineComments:  []string{"--"},
+		blockComments: [][2]string{{"/*", "*/"}},
+		strings:       []string{`'`},
+		keywords:      []string{"all", "alter", "and", "as", "asc", "begin", "between", "by", "case", "commit", "create", "default", "delete", "desc", "distinct", "drop", "else", "end", "exists", "foreign", "from", "group", "having", "in", "index", "inner", "insert", "into", "is", "join", "key", "left", "like", "limit", "not", "on", "or", "order", "outer", "primary", "references", "right", "rollback", "select", "set", "table", "then", "union", "update", "values", "view", "when", "where"},
+		types:         []string{"boolean", "char", "date", "float", "int", "integer", "numeric", "real", "text", "timestamp", "varchar"},
+		literals:      []string{"false", "null", "true"},
+		ignoreCase:    true,
+	},
+	{
+		names:  []string{"html", "xml", "svg"},
+		markup: true,
+	},
+}
+
+var lexers = map[string]*Lexer{}
+
+func init() {
+	for _, lang := range languages {
+		lexer := newLexer(lang)
+		for _, name := range lang.names {
+			lexers[name] = lexer
+		}
+	}
+}
+
+// newLexer compiles a language specification.
+func newLexer(lang language) *Lexer {
+	lexer := &Lexer{}
+	add := func(class string, pattern string) {
+		lexer.rules = append(lexer.rules, rule{class, regexp.MustCompile(pattern)})
+	}
+	if lang.markup {
+		add(Comment, `^<!--[\s\S]*?(?:-->|\z)`)
+		add(Meta, `^<[!?][^>]*>?`)
+		add(Tag, `^</?[\w:.-]+(?:[^>"']|"[^"]*"|'[^']*')*>?`)
+		add(Literal, `^&#?\w+;`)
+		return lexer
+	}
+	lexer.rules = append(lexer.rules, lang.extra...)
+	for _, prefix := range lang.lineComments {
+		add(Comment, `^`+regexp.QuoteMeta(prefix)+`[^\n]*`)
+	}
+	for _, delims := range lang.blockComments {
+		add(Comment, `^`+regexp.QuoteMeta(delims[0])+`[\s\S]*?(?:`+regexp.QuoteMeta(delims[1])+`|\z)`)
+	}
+	for _, delim := range lang.strings {
+		q := regexp.QuoteMeta(delim)
+		switch {
+		case len(delim) > 1: // Multi-line string.
+			add(String, `^`+q+`[\s\S]*?(?:`+q+`|\z)`)
+		case delim == "`": // Multi-line template or raw string.
+			add(String, "^`[^`]*`?")
+		default: // Single line string with backslash escapes.
+			add(String, `^`+q+`(?:\\.|[^`+q+`\\\n])*`+q+`?`)
+		}
+	}
+	flags := ""
+	if lang.ignoreCase {
+		flags = "(?i)"
+	}
+	for _, words := range []struct {
+		class string
+		words []string
+	}{{Keyword, lang.keywords}, {Type, lang.types}, {Literal, lang.literals}} {
+		if len(words.words) > 0 {
+			add(words.class, flags+`^(?:`+quoteWords(words.words)+`)\b`)
+		}
+	}
+	lexer.rules = append(lexer.rules, rule{Number, MATCH_NUMBER}, rule{"", MATCH_IDENTIFIER})
+	return lexer
+}
+
+// quoteWords returns the words as a regular expression alternation.
+func quoteWords(words []string) string {
+	quoted := make([]string, len(words))
+	for i, w := range words {
+		quoted[i] = regexp.QuoteMeta(w)
+	}
+	return strings.Join(quoted, "|")
+}
+
+// Lookup returns the lexer for a language name or alias (nil if there is no
+// lexer for the language). Names are case insensitive and "language-" and
+// "lang-" prefixes are ignored.
+func Lookup(name string) *Lexer {
+	name = strings.ToLower(name)
+	name = strings.TrimPrefix(name, "language-")
+	name = strings.TrimPrefix(name, "lang-")
+	return lexers[name]
+}
+
+// Languages returns the names of the highlighted languages.
+func Languages() (result []string) {
+	for _, lang := range languages {
+		result = append(result, lang.names[0])
+	}
+	return
+}
+
+// Tokens splits source code into tokens. Adjacent plain text is returned as a
+// single token.
+func (l *Lexer) Tokens(code string) (result []Token) {
+	plain := 0 // Start of pending plain text.
+	flush := func(end int) {
+		if end > plain {
+			result = append(result, Token{Text: code[plain:end]})
+		}
+	}
+	for i := 0; i < len(code); {
+		matched := false
+		for _, r := range l.rules {
+			loc := r.match.FindStringIndex(code[i:])
+			if loc == nil || loc[1] == 0 {
+				continue
+			}
+			if r.class != "" {
+				flush(i)
+				result = append(result, Token{Class: r.class, Text: code[i : i+loc[1]]})
+				plain = i + loc[1]
+			}
+			i += loc[1]
+			matched = true
+			break
+		}
+		if !matched {
+			_, size := utf8.DecodeRuneInString(code[i:])
+			i += size
+		}
+	}
+	flush(len(code))
+	return
+}
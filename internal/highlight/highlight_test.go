@@ -0,0 +1,60 @@
+package highlight
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/srackham/go-rimu/v11/internal/assert"
+)
+
+// render returns the tokens as "class:text" strings (plain text is unprefixed).
+func render(tokens []Token) string {
+	var result []string
+	for _, t := range tokens {
+		if t.Class == "" {
+			result = append(result, t.Text)
+		} else {
+			result = append(result, strings.TrimPrefix(t.Class, "hl-")+":"+t.Text)
+		}
+	}
+	return strings.Join(result, "|")
+}
+
+func TestTokens(t *testing.T) {
+	tests := []struct {
+		lang string
+		code string
+		want string
+	}{
+		{"go", "", ""},
+		{"go", "foo", "foo"},
+		{"go", "if x != nil { return 42 } // c", "keyword:if| x != |literal:nil| { |keyword:return| |number:42| } |comment:// c"},
+		{"go", "iffy := `a\nb` + \"c\\\"d\"", "iffy := |string:`a\nb`| + |string:\"c\\\"d\""},
+		{"go", "/* a\nb */x1", "comment:/* a\nb */|x1"},
+		{"golang", "var s string", "keyword:var| s |type:string"},
+		{"language-go", "func", "keyword:func"},
+		{"Python", "def f(): return None # x", "keyword:def| f(): |keyword:return| |literal:None| |comment:# x"},
+		{"py", `"""a` + "\n" + `b"""`, `string:"""a` + "\n" + `b"""`},
+		{"sql", "Select 'it''s' -- c", "keyword:Select| |string:'it'|string:'s'| |comment:-- c"},
+		{"bash", `echo "$HOME" $1 # c`, `type:echo| |string:"$HOME"| |variable:$1| |comment:# c`},
+		{"c", "#include <stdio.h>\nint x;", "meta:#include| <stdio.h>\n|type:int| x;"},
+		{"html", `<!-- c --><a href="x>">A &amp; B</a>`, `comment:<!-- c -->|tag:<a href="x>">|A |literal:&amp;| B|tag:</a>`},
+		{"json", `{"a": [1.5e3, true]}`, `{|string:"a"|: [|number:1.5e3|, |literal:true|]}`},
+		{"go", `"unterminated`, `string:"unterminated`},
+	}
+	for _, tt := range tests {
+		lexer := Lookup(tt.lang)
+		assert.True(t, lexer != nil)
+		assert.Equal(t, tt.want, render(lexer.Tokens(tt.code)))
+	}
+}
+
+func TestLookup(t *testing.T) {
+	assert.True(t, Lookup("lang-js") != nil)
+	assert.True(t, Lookup("TS") != nil)
+	assert.True(t, Lookup("unknown") == nil)
+	assert.True(t, Lookup("") == nil)
+	for _, name := range Languages() {
+		assert.True(t, Lookup(name) != nil)
+	}
+}
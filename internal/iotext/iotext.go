@@ -1,41 +1,127 @@
 package iotext
 
 import (
+	"bufio"
+	"errors"
+	"io"
 	"regexp"
 	"strings"
 	"unicode/utf8"
 
-	"github.com/srackham/go-rimu/v11/internal/options"
+	"github.com/srackham/go-rimu/v11/internal/ast"
+	"github.com/srackham/go-rimu/v11/internal/utils/stringlist"
 )
 
+// ErrInvalidUTF8 is the streaming Reader error for invalid UTF-8 input.
+var ErrInvalidUTF8 = errors.New("invalid UTF-8 input")
+
 /*
   Reader class.
 */
 // Reader state.
 type Reader struct {
-	Lines []string
-	Pos   int // Line index of current line.
+	Lines   []string
+	LineNos []int         // Source line numbers of Lines (1-based).
+	Pos     int           // Line index of current line.
+	Err     error         // The first read error (streaming readers only).
+	src     *bufio.Reader // Unread streaming input (nil if all lines have been read).
+	lineNo  int           // Source line number of the last line read.
 }
 
 // NewReader returns a new reader for text string.
+// text must be valid UTF-8.
 func NewReader(text string) *Reader {
-	if !utf8.ValidString(text) {
-		options.ErrorCallback("invalid UTF-8 input")
-		text = ""
-	}
 	r := new(Reader)
+	r.Lines = regexp.MustCompile(`\r\n|\r|\n`).Split(sanitize(text), -1)
+	r.LineNos = make([]int, len(r.Lines))
+	for i := range r.Lines {
+		r.lineNo++
+		r.LineNos[i] = r.lineNo
+	}
+	return r
+}
+
+// NewStreamReader returns a new reader that reads lines from src on demand.
+// Reading stops at the first read error or invalid UTF-8 line, the error is
+// saved in the reader's Err field.
+func NewStreamReader(src io.Reader) *Reader {
+	return &Reader{src: bufio.NewReader(src)}
+}
+
+// Replace characters that are used internally.
+func sanitize(text string) string {
 	text = strings.Replace(text, "\u0000", " ", -1) // Used internally by spans package.
 	text = strings.Replace(text, "\u0001", " ", -1) // Used internally by spans package.
 	text = strings.Replace(text, "\u0002", " ", -1) // Used internally by macros package.
-	r.Lines = regexp.MustCompile(`\r\n|\r|\n`).Split(text, -1)
-	return r
+	return text
+}
+
+// readLines appends the next chunk of streamed input to the reader lines.
+// Line terminators are the same as NewReader's i.e. \r\n, \r or \n.
+func (r *Reader) readLines() {
+	chunk, err := r.src.ReadString('\n')
+	if err == nil {
+		chunk = strings.TrimSuffix(chunk, "\n")
+		chunk = strings.TrimSuffix(chunk, "\r")
+	} else {
+		// The last line is terminated by EOF or a read error.
+		if err != io.EOF {
+			r.Err = err
+		}
+		r.src = nil
+	}
+	if !utf8.ValidString(chunk) {
+		r.lineNo++ // The invalid line.
+		r.Err = ErrInvalidUTF8
+		r.src = nil
+		return
+	}
+	for _, line := range strings.Split(sanitize(chunk), "\r") {
+		r.lineNo++
+		r.Lines = append(r.Lines, line)
+		r.LineNos = append(r.LineNos, r.lineNo)
+	}
 }
 
 // Eof returns true is reader is at end of text.
 func (r *Reader) Eof() bool {
+	for r.Pos >= len(r.Lines) && r.src != nil {
+		r.readLines()
+	}
 	return r.Pos >= len(r.Lines)
 }
 
+// Discard frees lines preceding the cursor line.
+func (r *Reader) Discard() {
+	for i := 0; i < r.Pos; i++ {
+		r.Lines[i] = ""
+	}
+	r.Lines = r.Lines[r.Pos:]
+	r.LineNos = r.LineNos[r.Pos:]
+	r.Pos = 0
+}
+
+// LineNo returns the source line number of the cursor line.
+// At EOF it returns the number of the last line read.
+func (r *Reader) LineNo() int {
+	if r.Eof() {
+		return r.lineNo
+	}
+	return r.LineNos[r.Pos]
+}
+
+// InsertLines inserts lines after the cursor line. The inserted lines are
+// attributed to the cursor line's source line number.
+func (r *Reader) InsertLines(lines ...string) {
+	lineNo := r.LineNo()
+	lineNos := make([]int, len(lines))
+	for i := range lineNos {
+		lineNos[i] = lineNo
+	}
+	r.Lines = stringlist.StringList(r.Lines).InsertAt(r.Pos+1, lines...)
+	r.LineNos = append(r.LineNos[:r.Pos+1], append(lineNos, r.LineNos[r.Pos+1:]...)...)
+}
+
 // SetCursor sets the reader cursor line.
 func (r *Reader) SetCursor(value string) {
 	if r.Eof() {
@@ -91,9 +177,9 @@ func (r *Reader) SkipBlankLines() {
 /*
   Writer class.
 */
-// Writer is a container for lines of text.
+// Writer is a container for rendered document tree nodes.
 type Writer struct {
-	Buffer []string // Appending an array is faster than string concatenation.
+	Buffer []ast.Node
 }
 
 // NewWriter return a new empty Writer.
@@ -101,10 +187,31 @@ func NewWriter() *Writer {
 	return &Writer{}
 }
 
+// Write appends raw HTML text.
 func (w *Writer) Write(s string) {
-	w.Buffer = append(w.Buffer, s)
+	w.Buffer = append(w.Buffer, &ast.HTML{Text: s})
 }
 
+// Append appends document tree nodes.
+func (w *Writer) Append(nodes ...ast.Node) {
+	w.Buffer = append(w.Buffer, nodes...)
+}
+
+// String returns the buffered nodes rendered as HTML.
 func (w *Writer) String() string {
-	return strings.Join(w.Buffer, "")
+	return ast.RenderHTML(w.Buffer...)
+}
+
+// WriteTo writes the buffered nodes rendered as HTML to out and empties the buffer.
+func (w *Writer) WriteTo(out io.Writer) (n int64, err error) {
+	for _, node := range w.Buffer {
+		var m int
+		m, err = io.WriteString(out, ast.RenderHTML(node))
+		n += int64(m)
+		if err != nil {
+			break
+		}
+	}
+	w.Buffer = nil
+	return
 }
@@ -1,10 +1,13 @@
 package iotext
 
 import (
+	"bytes"
 	"regexp"
+	"strings"
 	"testing"
 
 	"github.com/srackham/go-rimu/v11/internal/assert"
+	"github.com/srackham/go-rimu/v11/internal/ast"
 )
 
 func TestReader(t *testing.T) {
@@ -57,11 +60,71 @@ func TestReader(t *testing.T) {
 	assert.Panics(t, func() { reader.SetCursor("foo") })
 }
 
+func TestStreamReader(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"", []string{""}},
+		{"Hello", []string{"Hello"}},
+		{"Hello\n", []string{"Hello", ""}},
+		{"Hello\r\nWorld!\rFoo\nBar\r", []string{"Hello", "World!", "Foo", "Bar", ""}},
+		{"x\r\r\ny\u0000", []string{"x", "", "y "}},
+	}
+	for _, tt := range tests {
+		reader := NewStreamReader(strings.NewReader(tt.text))
+		var got []string
+		for !reader.Eof() {
+			got = append(got, reader.Cursor())
+			reader.Next()
+		}
+		assert.EqualValues(t, tt.want, got)
+		assert.EqualValues(t, NewReader(tt.text).Lines, got)
+		assert.True(t, reader.Err == nil)
+	}
+	reader := NewStreamReader(strings.NewReader("Hello\nWorld!\n\xbb\n"))
+	reader.Next()
+	reader.Discard()
+	assert.Equal(t, "World!", reader.Cursor())
+	assert.Equal(t, 1, len(reader.Lines))
+	reader.Next()
+	assert.True(t, reader.Eof())
+	assert.True(t, reader.Err == ErrInvalidUTF8)
+	assert.Equal(t, 3, reader.LineNo())
+}
+
+func TestLineNos(t *testing.T) {
+	for _, reader := range []*Reader{NewReader("a\nb\r\nc"), NewStreamReader(strings.NewReader("a\nb\r\nc"))} {
+		assert.Equal(t, 1, reader.LineNo())
+		reader.InsertLines("x", "y")
+		reader.Next()
+		assert.Equal(t, "x", reader.Cursor())
+		assert.Equal(t, 1, reader.LineNo())
+		reader.Next()
+		reader.Next()
+		reader.Discard()
+		assert.Equal(t, "b", reader.Cursor())
+		assert.Equal(t, 2, reader.LineNo())
+		reader.Next()
+		assert.Equal(t, 3, reader.LineNo())
+		reader.Next()
+		assert.True(t, reader.Eof())
+		assert.Equal(t, 3, reader.LineNo())
+	}
+}
+
 func TestWriter(t *testing.T) {
 	writer := NewWriter()
 	writer.Write("Hello")
-	assert.Equal(t, "Hello", writer.Buffer[0])
+	assert.Equal(t, "Hello", writer.Buffer[0].(*ast.HTML).Text)
 	writer.Write("World!")
-	assert.Equal(t, "World!", writer.Buffer[1])
-	assert.Equal(t, "HelloWorld!", writer.String())
+	assert.Equal(t, "World!", writer.Buffer[1].(*ast.HTML).Text)
+	writer.Append(&ast.Text{Text: "<>"})
+	assert.Equal(t, "HelloWorld!&lt;&gt;", writer.String())
+	var out bytes.Buffer
+	n, err := writer.WriteTo(&out)
+	assert.True(t, err == nil)
+	assert.Equal(t, int64(19), n)
+	assert.Equal(t, "HelloWorld!&lt;&gt;", out.String())
+	assert.Equal(t, "", writer.String())
 }
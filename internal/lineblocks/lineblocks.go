@@ -2,12 +2,18 @@ package lineblocks
 
 import (
 	"fmt"
+	"io/fs"
+	"path"
 	"regexp"
+	"strconv"
 	"strings"
+	"unicode/utf8"
 
+	"github.com/srackham/go-rimu/v11/internal/ast"
 	"github.com/srackham/go-rimu/v11/internal/blockattributes"
 	"github.com/srackham/go-rimu/v11/internal/delimitedblocks"
 	"github.com/srackham/go-rimu/v11/internal/expansion"
+	"github.com/srackham/go-rimu/v11/internal/format"
 	"github.com/srackham/go-rimu/v11/internal/iotext"
 	"github.com/srackham/go-rimu/v11/internal/macros"
 	"github.com/srackham/go-rimu/v11/internal/options"
@@ -20,149 +26,231 @@ import (
 type Definition struct {
 	match       *regexp.Regexp
 	replacement string
-	name        string // Optional unique identifier.
+	name        string // Unique identifier.
 	filter      LineBlockFilter
 	verify      LineBlockVerify // Additional match verification checks.
 }
 
-type LineBlockFilter = func(match []string, reader *iotext.Reader, def Definition) string
-type LineBlockVerify = func(match []string, reader *iotext.Reader) bool // Additional match verification checks.
+// LineBlockFilter returns the rendered document tree node or nil if there is nothing to render.
+type LineBlockFilter = func(lb *LineBlocks, match []string, reader *iotext.Reader, def Definition) ast.Node
+type LineBlockVerify = func(lb *LineBlocks, match []string, reader *iotext.Reader) bool // Additional match verification checks.
+
+// LineBlocks renders the Line Blocks of a single document.
+type LineBlocks struct {
+	Options         *options.Options
+	Formatter       *format.Formatter
+	Quotes          *quotes.Quotes
+	Replacements    *replacements.Replacements
+	Spans           *spans.Spans
+	Macros          *macros.Macros
+	BlockAttributes *blockattributes.BlockAttributes
+	DelimitedBlocks *delimitedblocks.DelimitedBlocks
+	ApiParse        func(source string, lineNos []int) []ast.Node // document package dependency injection.
+	defs            []Definition                                  // Built-in and registered definitions, nil if none have been registered.
+	includes        stringlist.StringList                         // FS paths of the files that are being included.
+	Headers         []*ast.Header                                 // Parsed Header nodes.
+	HeaderLines     []int                                         // Source line numbers of Headers.
+	TOCs            []*ast.HTML                                   // Parsed table of contents nodes.
+	sections        [6]int                                        // Section number counters.
+	illegalMacros   stringlist.StringList                         // Reported illegal section numbering macro names.
+}
+
+// CustomDefinition is a Line Block definition registered with the API.
+type CustomDefinition struct {
+	Name  string         // Unique identifier.
+	Match *regexp.Regexp // Matches the whole line.
+	// Before is the name of the definition that the line is matched before,
+	// defaults to "header" (custom lines are matched after comments, macro
+	// invocation lines and definitions).
+	Before string
+	// Verify performs additional match verification checks.
+	Verify func(match []string) bool
+	// Filter returns the HTML rendered from the match, macro invocations in the
+	// match groups have been expanded. Block Attributes are injected into the
+	// first HTML tag.
+	Filter func(match []string) string
+}
 
 var defs = []Definition{
 	// Prefix match with backslash to allow escaping.
 
 	// Comment line.
 	{
+		name:  "comment",
 		match: regexp.MustCompile(`^\\?\/{2}(.*)$`),
 	},
 	// Expand lines prefixed with a macro invocation prior to all other processing.
 	// macro name = $1, macro value = $2
 	{
+		name:  "macro-invocation",
 		match: macros.MATCH_LINE,
-		verify: func(match []string, reader *iotext.Reader) bool {
+		verify: func(lb *LineBlocks, match []string, reader *iotext.Reader) bool {
 			if macros.LITERAL_DEF_OPEN.MatchString(match[0]) || macros.EXPRESSION_DEF_OPEN.MatchString(match[0]) {
 				// Do not process macro definitions.
 				return false
 			}
 			// Silent because any macro expansion errors will be subsequently addressed downstream.
-			value := macros.Render(match[0], true)
+			value := lb.Macros.Render(match[0], true)
 			if strings.HasPrefix(value, match[0]) || strings.Contains(value, "\n"+match[0]) {
 				// The leading macro invocation expansion failed or contains itself.
 				// This stops infinite recursion.
 				return false
 			}
 			// Insert the macro value into the reader just ahead of the cursor.
-			reader.Lines = stringlist.StringList(reader.Lines).InsertAt(reader.Pos+1, strings.Split(value, "\n")...)
+			reader.InsertLines(strings.Split(value, "\n")...)
 			return true
 		},
-		filter: func(_ []string, _ *iotext.Reader, _ Definition) string {
-			return "" // Already processed in the `verify` function.
+		filter: func(_ *LineBlocks, _ []string, _ *iotext.Reader, _ Definition) ast.Node {
+			return nil // Already processed in the `verify` function.
 		},
 	},
 	// Delimited Block definition.
 	// name = $1, definition = $2
 	{
+		name:  "delimited-block-definition",
 		match: regexp.MustCompile(`^\\?\|([\w\-]+)\|\s*=\s*'(.*)'$`),
-		filter: func(match []string, _ *iotext.Reader, _ Definition) string {
-			if options.IsSafeModeNz() {
-				return "" // Skip if a safe mode is set.
+		filter: func(lb *LineBlocks, match []string, _ *iotext.Reader, _ Definition) ast.Node {
+			if lb.Options.IsSafeModeNz() {
+				return nil // Skip if a safe mode is set.
 			}
-			match[2] = spans.ReplaceInline(match[2], expansion.Options{Macros: true})
-			delimitedblocks.SetDefinition(match[1], match[2])
-			return ""
+			match[2] = lb.Spans.ReplaceInline(match[2], expansion.Options{Macros: true})
+			lb.DelimitedBlocks.SetDefinition(match[1], match[2])
+			return nil
 		},
 	},
 	// Quote definition.
 	// quote = $1, openTag = $2, separator = $3, closeTag = $4
 	{
+		name:  "quote-definition",
 		match: regexp.MustCompile(`^(\S{1,2})\s*=\s*'([^|]*)(\|{1,2})(.*)'$`),
-		filter: func(match []string, _ *iotext.Reader, _ Definition) string {
-			if options.IsSafeModeNz() {
-				return "" // Skip if a safe mode is set.
+		filter: func(lb *LineBlocks, match []string, _ *iotext.Reader, _ Definition) ast.Node {
+			if lb.Options.IsSafeModeNz() {
+				return nil // Skip if a safe mode is set.
 			}
-			quotes.SetDefinition(quotes.Definition{
+			lb.Quotes.SetDefinition(quotes.Definition{
 				Quote:    match[1],
-				OpenTag:  spans.ReplaceInline(match[2], expansion.Options{Macros: true}),
-				CloseTag: spans.ReplaceInline(match[4], expansion.Options{Macros: true}),
+				OpenTag:  lb.Spans.ReplaceInline(match[2], expansion.Options{Macros: true}),
+				CloseTag: lb.Spans.ReplaceInline(match[4], expansion.Options{Macros: true}),
 				Spans:    match[3] == "|",
 			})
-			return ""
+			return nil
 		},
 	},
 	// Replacement definition.
 	// pattern = $1, flags = $2, replacement = $3
 	{
+		name:  "replacement-definition",
 		match: regexp.MustCompile(`^\\?\/(.+)\/([igm]*)\s*=\s*'(.*)'$`),
-		filter: func(match []string, _ *iotext.Reader, _ Definition) string {
-			if options.IsSafeModeNz() {
-				return "" // Skip if a safe mode is set.
+		filter: func(lb *LineBlocks, match []string, _ *iotext.Reader, _ Definition) ast.Node {
+			if lb.Options.IsSafeModeNz() {
+				return nil // Skip if a safe mode is set.
 			}
 			pattern := match[1]
 			flags := match[2]
 			replacement := match[3]
-			replacement = spans.ReplaceInline(replacement, expansion.Options{Macros: true})
-			replacements.SetDefinition(pattern, flags, replacement)
-			return ""
+			replacement = lb.Spans.ReplaceInline(replacement, expansion.Options{Macros: true})
+			lb.Replacements.SetDefinition(pattern, flags, replacement)
+			return nil
 		},
 	},
 	// Macro definition.
 	// name = $1, value = $2
 	{
+		name:  "macro-definition",
 		match: macros.LINE_DEF,
-		verify: func(match []string, reader *iotext.Reader) bool {
+		verify: func(_ *LineBlocks, match []string, reader *iotext.Reader) bool {
 			// Necessary because Go regexps do not support regexp backreferences,
 			return match[2] == match[4] // Leading and trailing quote must match.
 		},
-		filter: func(match []string, _ *iotext.Reader, _ Definition) string {
+		filter: func(lb *LineBlocks, match []string, _ *iotext.Reader, _ Definition) ast.Node {
 			name := match[1]
 			quote := match[2]
 			value := match[3]
-			value = spans.ReplaceInline(value, expansion.Options{Macros: true})
-			macros.SetValue(name, value, quote)
-			return ""
+			if quote == "`" {
+				// Migrate to a literal value definition.
+				literal := match[0][:len(match[0])-len(value)-2] + "'" + value + "'"
+				lb.Options.DeprecationWarning("macro expression values are deprecated (the value is literal): "+match[0], match[0],
+					options.Edit{Old: match[0], New: literal})
+			}
+			lb.Formatter.Edit(options.Edit{Old: match[0], New: "{" + name + "} = '" + value + "'"})
+			value = lb.Spans.ReplaceInline(value, expansion.Options{Macros: true})
+			lb.Macros.SetValue(name, value)
+			return nil
 		},
 	},
+	// Table of contents.
+	// The TOC is generated by the document package once all headers have been parsed.
+	{
+		name:  "toc",
+		match: regexp.MustCompile(`^\\?\.toc$`),
+		filter: func(lb *LineBlocks, _ []string, _ *iotext.Reader, _ Definition) ast.Node {
+			toc := &ast.HTML{Text: "<nav></nav>"} // Placeholder (must not be blank).
+			lb.TOCs = append(lb.TOCs, toc)
+			return toc
+		},
+	},
+	// Include file.
+	// path = $1
+	{
+		name:   "include",
+		match:  regexp.MustCompile(`^\\?\.include\s+'(.+)'$`),
+		filter: includeFilter,
+	},
 	// Headers.
 	// $1 is ID, $2 is header text, $3 is the optional trailing ID.
 	{
-		match:       regexp.MustCompile(`^\\?([#=]{1,6})\s+(.+?)(?:\s+([#=]{1,6}))?$`),
-		replacement: "<h$1>$$2</h$1>",
-		verify: func(match []string, reader *iotext.Reader) bool {
+		name:  "header",
+		match: regexp.MustCompile(`^\\?([#=]{1,6})\s+(.+?)(?:\s+([#=]{1,6}))?$`),
+		verify: func(_ *LineBlocks, match []string, reader *iotext.Reader) bool {
 			// Necessary because Go regexps do not support regexp backreferences,
 			return match[3] == "" || match[3] == match[1] // Leading and trailing IDs must match.
 		},
-		filter: func(match []string, _ *iotext.Reader, def Definition) string {
-			match[1] = fmt.Sprint(len(match[1])) // Replace $1 with header number.
-			if macros.IsNotBlank("--header-ids") && blockattributes.Attrs.ID == "" {
-				blockattributes.Attrs.ID = blockattributes.Slugify(match[2])
+		filter: func(lb *LineBlocks, match []string, reader *iotext.Reader, _ Definition) ast.Node {
+			// Format with '#' markers and no trailing ID.
+			lb.Formatter.Edit(options.Edit{Old: match[0], New: strings.Repeat("#", len(match[1])) + " " + match[2]})
+			if lb.Macros.IsNotBlank("--header-ids") && lb.BlockAttributes.Attrs.ID == "" {
+				lb.BlockAttributes.Attrs.ID = lb.BlockAttributes.Slugify(match[2])
+			}
+			header := &ast.Header{
+				Level:    len(match[1]),
+				Children: lb.Spans.ParseInline(match[2], expansion.Options{Macros: true, Spans: true}),
 			}
-			return spans.ReplaceMatch(match, def.replacement, expansion.Options{Macros: true})
+			header.Number = lb.sectionNumber(header.Level)
+			lb.Headers = append(lb.Headers, header)
+			lb.HeaderLines = append(lb.HeaderLines, reader.LineNo())
+			return header
 		},
 	},
 	// Block image: <image:src|alt>
 	// src = $1, alt = $2
 	{
-		match:       regexp.MustCompile(`^\\?<image:([^\s|]+)\|(.+?)>$`),
-		replacement: "<img src=\"$1\" alt=\"$2\">",
+		name:   "image-alt",
+		match:  regexp.MustCompile(`^\\?<image:([^\s|]+)\|(.+?)>$`),
+		filter: imageFilter,
 	},
 	// Block image: <image:src>
 	// src = $1, alt = $1
 	{
-		match:       regexp.MustCompile(`^\\?<image:([^\s|]+?)>$`),
-		replacement: "<img src=\"$1\" alt=\"$1\">",
+		name:   "image",
+		match:  regexp.MustCompile(`^\\?<image:([^\s|]+?)>$`),
+		filter: imageFilter,
 	},
 	// DEPRECATED as of 3.4.0.
 	// Block anchor: <<#id>>
 	// id = $1
 	{
+		name:        "anchor",
 		match:       regexp.MustCompile(`^\\?<<#([a-zA-Z][\w\-]*)>>$`),
 		replacement: "<div id=\"$1\"></div>",
-		filter: func(match []string, _ *iotext.Reader, def Definition) string {
-			if options.SkipBlockAttributes() {
-				return ""
+		filter: func(lb *LineBlocks, match []string, _ *iotext.Reader, def Definition) ast.Node {
+			// Migrate to an empty division block with an id Block Attribute.
+			lb.Options.DeprecationWarning("block anchors are deprecated: "+match[0], match[0],
+				options.Edit{Old: match[0], New: ".#" + match[1] + "\n..\n.."})
+			if lb.Options.SkipBlockAttributes() {
+				return nil
 			} else {
 				// Default (non-filter) replacement processing.
-				return spans.ReplaceMatch(match, def.replacement, expansion.Options{Macros: true})
+				return &ast.HTML{Text: lb.Spans.ReplaceMatch(match, def.replacement, expansion.Options{Macros: true})}
 			}
 		},
 	},
@@ -171,60 +259,267 @@ var defs = []Definition{
 	{
 		name:  "attributes",
 		match: regexp.MustCompile(`^\\?\.[a-zA-Z#"\[+-].*$`), // A loose match because Block Attributes can contain macro references.
-		verify: func(match []string, _ *iotext.Reader) bool {
-			return blockattributes.Parse(match[0])
+		verify: func(lb *LineBlocks, match []string, _ *iotext.Reader) bool {
+			if !lb.BlockAttributes.Parse(match[0]) {
+				return false
+			}
+			lb.Formatter.Edit(options.Edit{Old: match[0], New: blockattributes.Format(match[0])})
+			return true
 		},
 	},
 	// API Option.
 	// name = $1, value = $2
 	{
+		name:  "api-option",
 		match: regexp.MustCompile(`^\\?\.(\w+)\s*=\s*'(.*)'$`),
-		filter: func(match []string, _ *iotext.Reader, _ Definition) string {
-			if !options.IsSafeModeNz() {
-				value := spans.ReplaceInline(match[2], expansion.Options{Macros: true})
-				options.SetOption(match[1], value)
+		filter: func(lb *LineBlocks, match []string, _ *iotext.Reader, _ Definition) ast.Node {
+			if !lb.Options.IsSafeModeNz() {
+				value := lb.Spans.ReplaceInline(match[2], expansion.Options{Macros: true})
+				lb.Options.SetOption(match[1], value)
 			}
-			return ""
+			return nil
 		},
 	},
 }
 
+// MAX_INCLUDE_DEPTH is the maximum nesting depth of included files.
+const MAX_INCLUDE_DEPTH = 10
+
+// includeFilter returns the document tree parsed from an included file.
+// Relative paths are resolved against the including file, paths starting with
+// a slash are resolved against the root of the file system.
+func includeFilter(lb *LineBlocks, match []string, reader *iotext.Reader, _ Definition) ast.Node {
+	if lb.Options.IsSafeModeNz() {
+		return nil // Skip if a safe mode is set.
+	}
+	name := lb.Spans.ReplaceInline(match[1], expansion.Options{Macros: true})
+	fsys := lb.Options.FS()
+	if fsys == nil {
+		lb.Options.ErrorCallbackNear(options.IllegalInclude, "include file system is not set: "+match[0], match[1])
+		return nil
+	}
+	root := strings.TrimPrefix(lb.Options.Path(), "/") // The document's FS path.
+	if root != "" {
+		root = path.Clean(root)
+	}
+	current := root
+	if len(lb.includes) > 0 {
+		current = lb.includes[len(lb.includes)-1]
+	}
+	var p string
+	if strings.HasPrefix(name, "/") {
+		p = path.Clean(name[1:])
+	} else {
+		p = path.Join(path.Dir(current), name)
+	}
+	switch {
+	case !fs.ValidPath(p):
+		lb.Options.ErrorCallbackNear(options.IllegalInclude, "illegal include path: "+name, match[1])
+		return nil
+	case p == root || lb.includes.Contains(p):
+		lb.Options.ErrorCallbackNear(options.IllegalInclude, "include cycle: "+p, match[1])
+		return nil
+	case len(lb.includes) >= MAX_INCLUDE_DEPTH:
+		lb.Options.ErrorCallbackNear(options.IllegalInclude, "maximum include depth exceeded: "+p, match[1])
+		return nil
+	}
+	data, err := fs.ReadFile(fsys, p)
+	if err != nil {
+		lb.Options.ErrorCallbackNear(options.IllegalInclude, "include file read error: "+err.Error(), match[1])
+		return nil
+	}
+	text := string(data)
+	if !utf8.ValidString(text) {
+		lb.Options.ErrorCallbackNear(options.InvalidUTF8, "invalid UTF-8 input: "+p, match[1])
+		return nil
+	}
+	lb.includes = append(lb.includes, p)
+	defer func() { lb.includes = lb.includes[:len(lb.includes)-1] }()
+	// Included lines are attributed to the include line.
+	lineNos := make([]int, len(iotext.NewReader(text).Lines))
+	for i := range lineNos {
+		lineNos[i] = reader.LineNo()
+	}
+	return &ast.Document{Children: lb.ApiParse(text, lineNos)}
+}
+
+// StartDocument resets the parsed headers, table of contents nodes, section
+// numbers and reported numbering macros at the start of a document.
+func (lb *LineBlocks) StartDocument() {
+	lb.Headers = nil
+	lb.HeaderLines = nil
+	lb.TOCs = nil
+	lb.sections = [6]int{}
+	lb.illegalMacros = nil
+}
+
+// sectionNumber returns the section number of the next header at level or a
+// blank string if the header is not numbered.
+// Numbering is enabled by a non-blank --header-numbers macro and is configured
+// by the --header-numbers-start (the first numbered level, defaults to 2),
+// --header-numbers-depth (the number of numbered levels, defaults to 2) and
+// --header-numbers-separator (defaults to ".") macros.
+func (lb *LineBlocks) sectionNumber(level int) string {
+	if !lb.Macros.IsNotBlank("--header-numbers") {
+		return ""
+	}
+	intMacro := func(name string, value int) int {
+		if s, found := lb.Macros.Value(name); found {
+			if n, err := strconv.Atoi(strings.TrimSpace(s)); err == nil && n >= 1 && n <= 6 {
+				return n
+			}
+			if !lb.illegalMacros.Contains(name) {
+				lb.illegalMacros = append(lb.illegalMacros, name) // Reported once per document.
+				lb.Options.ErrorCallback(options.IllegalMacroDefinition, "illegal "+name+" macro value: "+s)
+			}
+		}
+		return value
+	}
+	start := intMacro("--header-numbers-start", 2)
+	depth := intMacro("--header-numbers-depth", 2)
+	separator, found := lb.Macros.Value("--header-numbers-separator")
+	if !found {
+		separator = "."
+	}
+	i := level - start
+	if i < 0 || i >= depth {
+		return ""
+	}
+	lb.sections[i]++
+	for j := i + 1; j < len(lb.sections); j++ {
+		lb.sections[j] = 0
+	}
+	numbers := make([]string, i+1)
+	for j := range numbers {
+		numbers[j] = strconv.Itoa(lb.sections[j])
+	}
+	return strings.Join(numbers, separator)
+}
+
+// imageFilter returns a block image node.
+// src = $1, alt = $2 (defaults to $1)
+func imageFilter(lb *LineBlocks, match []string, _ *iotext.Reader, _ Definition) ast.Node {
+	alt := match[1]
+	if len(match) > 2 {
+		alt = match[2]
+	}
+	return &ast.Image{
+		Src: lb.Macros.Render(match[1], false),
+		Alt: lb.Macros.Render(alt, false),
+	}
+}
+
+// Register adds a new custom definition.
+func (lb *LineBlocks) Register(c CustomDefinition) error {
+	if c.Name == "" {
+		return fmt.Errorf("missing line block name")
+	}
+	if lb.getDefinition(c.Name) != -1 {
+		return fmt.Errorf("line block already defined: %s", c.Name)
+	}
+	if c.Match == nil {
+		return fmt.Errorf("missing line block match: %s", c.Name)
+	}
+	if c.Match.MatchString("") {
+		return fmt.Errorf("line block match matches the empty string: %s", c.Name)
+	}
+	if c.Before == "" {
+		c.Before = "header"
+	}
+	i := lb.getDefinition(c.Before)
+	if i == -1 {
+		return fmt.Errorf("illegal line block name: %s: before: %s", c.Name, c.Before)
+	}
+	def := Definition{
+		name:  c.Name,
+		match: c.Match,
+		filter: func(lb *LineBlocks, match []string, _ *iotext.Reader, _ Definition) ast.Node {
+			if c.Filter == nil {
+				return nil
+			}
+			for i := 1; i < len(match); i++ {
+				match[i] = lb.Macros.Render(match[i], false)
+			}
+			return &ast.HTML{Text: c.Filter(match)}
+		},
+	}
+	if c.Verify != nil {
+		def.verify = func(_ *LineBlocks, match []string, _ *iotext.Reader) bool {
+			return c.Verify(match)
+		}
+	}
+	all := lb.definitions()
+	lb.defs = append(append(append([]Definition{}, all[:i]...), def), all[i:]...)
+	return nil
+}
+
+// definitions returns the built-in and registered definitions in match order.
+func (lb *LineBlocks) definitions() []Definition {
+	if lb.defs == nil {
+		return defs
+	}
+	return lb.defs
+}
+
+// getDefinition returns the index of the named definition or -1 if not found.
+func (lb *LineBlocks) getDefinition(name string) int {
+	for i, def := range lb.definitions() {
+		if def.name == name {
+			return i
+		}
+	}
+	return -1
+}
+
 // If the next element in the reader is a valid line block render it
 // and return true, else return false.
-func Render(reader *iotext.Reader, writer *iotext.Writer, allowed stringlist.StringList) bool {
+func (lb *LineBlocks) Render(reader *iotext.Reader, writer *iotext.Writer, allowed stringlist.StringList) bool {
 	if reader.Eof() {
 		panic("premature eof")
 	}
-	for _, def := range defs {
+	saved := lb.Options.SetSource(options.Source{Lines: []string{reader.Cursor()}, LineNos: []int{reader.LineNo()}})
+	defer lb.Options.SetSource(saved)
+	for _, def := range lb.definitions() {
 		if len(allowed) > 0 && !allowed.Contains(def.name) {
 			continue
 		}
 		match := def.match.FindStringSubmatch(reader.Cursor())
-		if match != nil {
+		if match != nil && match[0] != "" {
 			if match[0][0] == '\\' {
 				// Drop backslash escape and continue.
 				reader.SetCursor(reader.Cursor()[1:])
 				continue
 			}
-			if def.verify != nil && !def.verify(match, reader) {
+			if def.verify != nil && !def.verify(lb, match, reader) {
 				continue
 			}
-			var text string
+			var node ast.Node
 			if def.filter == nil {
-				text = spans.ReplaceMatch(match, def.replacement, expansion.Options{Macros: true})
+				node = &ast.HTML{Text: lb.Spans.ReplaceMatch(match, def.replacement, expansion.Options{Macros: true})}
 			} else {
-				text = def.filter(match, reader, def)
+				node = def.filter(lb, match, reader, def)
 			}
-			if text != "" {
-				text = blockattributes.Inject(text)
-				writer.Write(text)
+			if doc, ok := node.(*ast.Document); ok {
+				// Included document nodes.
 				reader.Next()
-				if !reader.Eof() {
-					writer.Write("\n") // Add a trailing '\n' if there are more lines.
+				if len(doc.Children) > 0 {
+					writer.Append(doc.Children...)
+					if !reader.Eof() {
+						writer.Append(&ast.Newline{}) // Add a trailing '\n' if there are more lines.
+					}
 				}
-			} else {
+				return true
+			}
+			if node != nil && !ast.IsBlank(node) {
+				*ast.AttributesOf(node) = lb.BlockAttributes.Consume(ast.OpenTag(node))
+				writer.Append(node)
 				reader.Next()
+				if !reader.Eof() {
+					writer.Append(&ast.Newline{}) // Add a trailing '\n' if there are more lines.
+				}
+				return true
 			}
+			reader.Next()
 			return true
 		}
 	}
@@ -1,9 +1,10 @@
-package lineblocks
+package lineblocks_test
 
 import (
 	"testing"
 
 	"github.com/srackham/go-rimu/v11/internal/assert"
+	"github.com/srackham/go-rimu/v11/internal/document"
 	"github.com/srackham/go-rimu/v11/internal/iotext"
 )
 
@@ -24,9 +25,10 @@ func TestRender(t *testing.T) {
 		{`{foo}='bar'`, ``},
 	}
 	for _, tt := range tests {
+		doc := document.New()
 		reader := iotext.NewReader(tt.source)
 		writer := iotext.NewWriter()
-		Render(reader, writer, nil)
+		doc.LineBlocks.Render(reader, writer, nil)
 		got := writer.String()
 		assert.Equal(t, tt.want, got)
 	}
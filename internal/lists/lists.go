@@ -4,12 +4,16 @@ import (
 	"regexp"
 	"strings"
 
+	"github.com/srackham/go-rimu/v11/internal/ast"
 	"github.com/srackham/go-rimu/v11/internal/blockattributes"
 	"github.com/srackham/go-rimu/v11/internal/delimitedblocks"
 	"github.com/srackham/go-rimu/v11/internal/expansion"
+	"github.com/srackham/go-rimu/v11/internal/format"
 	"github.com/srackham/go-rimu/v11/internal/iotext"
 	"github.com/srackham/go-rimu/v11/internal/lineblocks"
+	"github.com/srackham/go-rimu/v11/internal/options"
 	"github.com/srackham/go-rimu/v11/internal/spans"
+	"github.com/srackham/go-rimu/v11/internal/toc"
 	"github.com/srackham/go-rimu/v11/internal/utils/stringlist"
 )
 
@@ -22,8 +26,20 @@ type Definition struct {
 	itemCloseTag string
 	termOpenTag  string // Definition lists only.
 	termCloseTag string // Definition lists only.
+	tasks        bool   // Items with a checkbox marker are task list items.
 }
 
+// Task is a task list item.
+type Task struct {
+	Text string // Plain text (excluding the checkbox marker).
+	Done bool   // True if the task is checked.
+	Line int    // Source line number.
+}
+
+// MATCH_TASK matches the checkbox marker at the start of task list item text.
+// $1 is the checkbox state, $2 is the item text.
+var MATCH_TASK = regexp.MustCompile(`^\[([ xX])\](?:\s+(.*))?$`)
+
 // ItemInfo contains information about a matched list item element.
 type ItemInfo struct {
 	match []string
@@ -42,6 +58,7 @@ var defs = []Definition{
 		listCloseTag: "</ul>",
 		itemOpenTag:  "<li>",
 		itemCloseTag: "</li>",
+		tasks:        true,
 	},
 	// Ordered lists.
 	// $1 is list ID $2 is item text.
@@ -70,10 +87,25 @@ const noMatch = "NO_MATCH" // "no matching list item found" list ID constant.
 // noMatchItem returns "no matching list item found" constant.
 func noMatchItem() ItemInfo { return ItemInfo{id: noMatch} }
 
-var ids []string // Stack of open list IDs.
+// Lists renders the lists of a single document.
+type Lists struct {
+	ids             []string // Stack of open list IDs.
+	Options         *options.Options
+	Formatter       *format.Formatter
+	Spans           *spans.Spans
+	BlockAttributes *blockattributes.BlockAttributes
+	LineBlocks      *lineblocks.LineBlocks
+	DelimitedBlocks *delimitedblocks.DelimitedBlocks
+	Tasks           []Task // Task list items of the current document.
+}
+
+// StartDocument resets per-document state.
+func (l *Lists) StartDocument() {
+	l.Tasks = nil
+}
 
 // Render list item in reader to writer.
-func Render(reader *iotext.Reader, writer *iotext.Writer) bool {
+func (l *Lists) Render(reader *iotext.Reader, writer *iotext.Writer) bool {
 	if reader.Eof() {
 		panic("premature eof")
 	}
@@ -81,24 +113,30 @@ func Render(reader *iotext.Reader, writer *iotext.Writer) bool {
 	if startItem.id == noMatch {
 		return false
 	}
-	ids = nil
-	renderList(startItem, reader, writer)
+	l.ids = nil
+	l.renderList(startItem, reader, writer)
 	// ids should now be empty.
-	if len(ids) != 0 {
+	if len(l.ids) != 0 {
 		panic("list stack failure")
 	}
 	return true
 }
 
-func renderList(item ItemInfo, reader *iotext.Reader, writer *iotext.Writer) ItemInfo {
-	ids = append(ids, item.id)
-	writer.Write(blockattributes.Inject(item.def.listOpenTag))
+func (l *Lists) renderList(item ItemInfo, reader *iotext.Reader, writer *iotext.Writer) ItemInfo {
+	l.ids = append(l.ids, item.id)
+	list := &ast.List{
+		BlockAttributes: l.BlockAttributes.Consume(item.def.listOpenTag),
+		ID:              item.id,
+		OpenTag:         item.def.listOpenTag,
+		CloseTag:        item.def.listCloseTag,
+	}
+	writer.Append(list)
 	for {
-		nextItem := renderListItem(item, reader, writer)
+		listItem, nextItem := l.renderListItem(item, reader)
+		list.Items = append(list.Items, listItem)
 		if nextItem.id == noMatch || nextItem.id != item.id {
 			// End of list or next item belongs to ancestor.
-			writer.Write(item.def.listCloseTag)
-			ids = ids[:len(ids)-1] // pop
+			l.ids = l.ids[:len(l.ids)-1] // pop
 			return nextItem
 		}
 		item = nextItem
@@ -106,24 +144,41 @@ func renderList(item ItemInfo, reader *iotext.Reader, writer *iotext.Writer) Ite
 }
 
 // Render the current list item, return the next list item or null if there are no more items.
-func renderListItem(item ItemInfo, reader *iotext.Reader, writer *iotext.Writer) ItemInfo {
+func (l *Lists) renderListItem(item ItemInfo, reader *iotext.Reader) (*ast.ListItem, ItemInfo) {
 	def := item.def
 	match := item.match
-	var text string
+	listItem := &ast.ListItem{
+		OpenTag:  def.itemOpenTag,
+		CloseTag: def.itemCloseTag,
+	}
+	source := options.Source{Lines: []string{reader.Cursor()}, LineNos: []int{reader.LineNo()}}
+	saved := l.Options.SetSource(source)
+	defer l.Options.SetSource(saved)
+	l.formatItem(item, reader.Cursor())
 	if len(match) == 4 { // 3 match groups => definition list.
-		attrs := blockattributes.Attrs
-		writer.Write(blockattributes.Inject(def.termOpenTag))
+		attrs := l.BlockAttributes.Attrs
+		listItem.TermAttributes = l.BlockAttributes.Consume(def.termOpenTag)
 		attrs.ID = ""
-		blockattributes.Attrs = attrs // Restore consumed block attributes.
-		text = spans.ReplaceInline(match[1], expansion.Options{Macros: true, Spans: true})
-		writer.Write(text)
-		writer.Write(def.termCloseTag)
+		l.BlockAttributes.Attrs = attrs // Restore consumed block attributes.
+		listItem.TermOpenTag = def.termOpenTag
+		listItem.TermCloseTag = def.termCloseTag
+		listItem.Term = l.Spans.ParseInline(match[1], expansion.Options{Macros: true, Spans: true})
 	}
-	writer.Write(blockattributes.Inject(def.itemOpenTag))
+	listItem.BlockAttributes = l.BlockAttributes.Consume(def.itemOpenTag)
 	// Process item text from first line.
-	itemLines := iotext.NewWriter()
-	text = match[len(match)-1]
-	itemLines.Write(text + "\n")
+	itemLines := []string{match[len(match)-1]}
+	task := -1 // Index of the item's task.
+	if def.tasks {
+		if m := MATCH_TASK.FindStringSubmatch(itemLines[0]); m != nil {
+			listItem.Task = true
+			listItem.Checked = m[1] != " "
+			listItem.OpenTag = `<li class="task-list-item">`
+			itemLines[0] = m[2]
+			// The task is recorded before any child list tasks.
+			task = len(l.Tasks)
+			l.Tasks = append(l.Tasks, Task{Done: listItem.Checked, Line: reader.LineNo()})
+		}
+	}
 	// Process remainder of list item i.e. item text, optional attached block, optional child list.
 	reader.Next()
 	attachedLines := iotext.NewWriter()
@@ -131,7 +186,7 @@ func renderListItem(item ItemInfo, reader *iotext.Reader, writer *iotext.Writer)
 	attachedDone := false
 	var nextItem ItemInfo
 	for {
-		blankLines = consumeBlockAttributes(reader, attachedLines)
+		blankLines = l.consumeBlockAttributes(reader, attachedLines)
 		if blankLines >= 2 || blankLines == -1 {
 			// EOF or two or more blank lines terminates list.
 			nextItem = noMatchItem()
@@ -139,11 +194,11 @@ func renderListItem(item ItemInfo, reader *iotext.Reader, writer *iotext.Writer)
 		}
 		nextItem = matchItem(reader)
 		if nextItem.id != noMatch {
-			if stringlist.StringList(ids).IndexOf(nextItem.id) != -1 {
+			if stringlist.StringList(l.ids).IndexOf(nextItem.id) != -1 {
 				// Next item belongs to current list or a parent list.
 			} else {
 				// Render child list.
-				nextItem = renderList(nextItem, reader, attachedLines)
+				nextItem = l.renderList(nextItem, reader, attachedLines)
 			}
 			break
 		}
@@ -151,54 +206,129 @@ func renderListItem(item ItemInfo, reader *iotext.Reader, writer *iotext.Writer)
 			break // Multiple attached blocks are not permitted.
 		}
 		if blankLines == 0 {
-			savedIds := ids
-			ids = nil
-			if delimitedblocks.Render(reader, attachedLines, []string{"comment", "code", "division", "html", "quote"}) {
+			savedIds := l.ids
+			l.ids = nil
+			if l.DelimitedBlocks.Render(reader, attachedLines, []string{"comment", "code", "division", "html", "quote"}) {
 				attachedDone = true
 			} else {
 				// Item body line.
-				itemLines.Write(reader.Cursor() + "\n")
+				itemLines = append(itemLines, reader.Cursor())
+				source.Lines = append(source.Lines, reader.Cursor())
+				source.LineNos = append(source.LineNos, reader.LineNo())
 				reader.Next()
 			}
-			ids = savedIds
+			l.ids = savedIds
 		} else if blankLines == 1 {
-			if delimitedblocks.Render(reader, attachedLines, []string{"indented", "quote-paragraph"}) {
+			if l.DelimitedBlocks.Render(reader, attachedLines, []string{"indented", "quote-paragraph"}) {
 				attachedDone = true
 			} else {
 				break
 			}
 		}
 	}
-	// Write item text.
-	text = strings.TrimSpace(itemLines.String())
-	text = spans.ReplaceInline(text, expansion.Options{Macros: true, Spans: true})
-	writer.Write(text)
-	// Write attachment and child list.
-	writer.Buffer = append(writer.Buffer, attachedLines.Buffer...)
-	// Close list item.
-	writer.Write(def.itemCloseTag)
-	return nextItem
+	// Item text.
+	l.Options.SetSource(source)
+	text := strings.TrimSpace(strings.Join(itemLines, "\n"))
+	listItem.Children = l.Spans.ParseInline(text, expansion.Options{Macros: true, Spans: true})
+	if task != -1 {
+		l.Tasks[task].Text = toc.Text(listItem.Children...)
+	}
+	// Attachment and child list.
+	listItem.Children = append(listItem.Children, attachedLines.Buffer...)
+	return listItem, nextItem
+}
+
+// formatIDs are the canonical list IDs of each list type ordered by nesting depth.
+var formatIDs = map[string][]string{
+	"<ul>": {"-", "*", "**", "***", "****", "+"},
+	"<ol>": {".", "..", "...", "...."},
+	"<dl>": {"::", ":::", "::::"},
+}
+
+// MATCH_ITEM_NUMBER matches the optional ordered list item number.
+var MATCH_ITEM_NUMBER = regexp.MustCompile(`^\s*(\d*)`)
+
+// formatItem saves the format edit that replaces the list item's ID with the
+// canonical ID for its nesting depth and indents the item two spaces for each
+// parent list.
+func (l *Lists) formatItem(item ItemInfo, line string) {
+	if !l.Formatter.IsFormatting() {
+		return
+	}
+	// The canonical ID is determined by the number of parent lists of the same type.
+	depth := 0
+	for _, id := range l.ids[:len(l.ids)-1] {
+		if listType(id) == item.def.listOpenTag {
+			depth++
+		}
+	}
+	id := formatIDs[item.def.listOpenTag][depth]
+	indent := strings.Repeat("  ", len(l.ids)-1)
+	match := item.match
+	var formatted string
+	switch item.def.listOpenTag {
+	case "<ul>":
+		formatted = indent + id + " " + match[2]
+	case "<ol>":
+		formatted = indent + MATCH_ITEM_NUMBER.FindStringSubmatch(line)[1] + id + " " + match[2]
+	case "<dl>":
+		formatted = indent + match[1] + id
+		if text := strings.TrimSpace(match[3]); text != "" {
+			formatted += " " + strings.TrimLeft(match[3], " \t")
+		}
+	}
+	l.Formatter.Edit(options.Edit{Old: line, New: formatted})
+}
+
+// listType returns the list open tag of a list ID.
+func listType(id string) string {
+	switch id[0] {
+	case '.':
+		return "<ol>"
+	case ':':
+		return "<dl>"
+	}
+	return "<ul>"
 }
 
 // Consume blank lines and Block Attributes.
 // Return number of blank lines read or -1 if EOF.
-func consumeBlockAttributes(reader *iotext.Reader, writer *iotext.Writer) int {
+func (l *Lists) consumeBlockAttributes(reader *iotext.Reader, writer *iotext.Writer) int {
 	blanks := 0
 	for {
 		if reader.Eof() {
 			return -1
 		}
-		if lineblocks.Render(reader, writer, []string{"attributes"}) {
+		if l.LineBlocks.Render(reader, writer, []string{"attributes"}) {
 			continue
 		}
 		if reader.Cursor() != "" {
 			return blanks
 		}
+		if l.Formatter.IsFormatting() && (blanks >= 2 || trailingBlank(reader)) {
+			// Two blank lines terminate the list, the rest are deleted by the
+			// formatter (along with trailing blank lines other than the last).
+			l.Formatter.Edit(options.Edit{Line: reader.LineNo(), Old: "", Delete: true})
+		}
 		blanks++
 		reader.Next()
 	}
 }
 
+// trailingBlank returns true if the reader lines following the cursor line
+// are all blank (and there is at least one).
+func trailingBlank(reader *iotext.Reader) bool {
+	if reader.Pos+1 >= len(reader.Lines) {
+		return false
+	}
+	for _, line := range reader.Lines[reader.Pos+1:] {
+		if line != "" {
+			return false
+		}
+	}
+	return true
+}
+
 // Check if the line at the reader cursor matches a list related element.
 // Unescape escaped list items in reader.
 // If it does not match a list related element return null.
@@ -1,10 +1,12 @@
-package lists
+package lists_test
 
 import (
 	"testing"
 
 	"github.com/srackham/go-rimu/v11/internal/assert"
+	"github.com/srackham/go-rimu/v11/internal/document"
 	"github.com/srackham/go-rimu/v11/internal/iotext"
+	"github.com/srackham/go-rimu/v11/internal/lists"
 )
 
 func TestRender(t *testing.T) {
@@ -13,12 +15,25 @@ func TestRender(t *testing.T) {
 		want string
 	}{
 		{`- foo`, `<ul><li>foo</li></ul>`},
+		{"- [ ] foo\n- [x] bar", `<ul><li class="task-list-item"><input type="checkbox" disabled> foo</li><li class="task-list-item"><input type="checkbox" checked disabled> bar</li></ul>`},
+		{`. [x] foo`, `<ol><li>[x] foo</li></ol>`},
 	}
 	for _, tt := range tests {
+		doc := document.New()
 		reader := iotext.NewReader(tt.in)
 		writer := iotext.NewWriter()
-		Render(reader, writer)
+		doc.Lists.Render(reader, writer)
 		got := writer.String()
 		assert.Equal(t, tt.want, got)
 	}
 }
+
+func TestTasks(t *testing.T) {
+	doc := document.New()
+	reader := iotext.NewReader("- [ ] foo\n  * [X] *bar*\n- baz\n- [x] qux")
+	doc.Lists.Render(reader, iotext.NewWriter())
+	assert.Equal(t, 3, len(doc.Lists.Tasks))
+	assert.Equal(t, lists.Task{Text: "foo", Done: false, Line: 1}, doc.Lists.Tasks[0])
+	assert.Equal(t, lists.Task{Text: "bar", Done: true, Line: 2}, doc.Lists.Tasks[1])
+	assert.Equal(t, lists.Task{Text: "qux", Done: true, Line: 4}, doc.Lists.Tasks[2])
+}
@@ -0,0 +1,643 @@
+/*
+  Language Server Protocol (LSP) server for Rimu Markup.
+*/
+
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/srackham/go-rimu/v11/internal/ast"
+	"github.com/srackham/go-rimu/v11/internal/document"
+	"github.com/srackham/go-rimu/v11/internal/macros"
+	"github.com/srackham/go-rimu/v11/internal/options"
+	"github.com/srackham/go-rimu/v11/internal/toc"
+)
+
+// JSON-RPC error codes.
+const (
+	ParseError     = -32700
+	InvalidRequest = -32600
+	MethodNotFound = -32601
+	InvalidParams  = -32602
+	RequestFailed  = -32803
+)
+
+// LSP enumeration values.
+const (
+	severityError       = 1
+	severityWarning     = 2
+	severityInfo        = 3
+	completionVariable  = 6
+	completionClass     = 7
+	symbolString        = 15
+	textDocumentSyncAll = 1
+)
+
+var (
+	// MATCH_MACRO matches a macro invocation or definition name. $1 is the name.
+	MATCH_MACRO = regexp.MustCompile(`\\?\{([\w\-]+)[!=|?}]`)
+	// MATCH_MACRO_PREFIX matches a partial macro name at the end of a line prefix.
+	MATCH_MACRO_PREFIX = regexp.MustCompile(`(?:^|[^\\])\{[\w\-]*$`)
+	// MATCH_CLASS_PREFIX matches a Block Attributes or delimiter line prefix
+	// that is followed by class names.
+	MATCH_CLASS_PREFIX = regexp.MustCompile(`^(?:\.|\.{2,}|"{2,}|>{2,}|` + "`{2,}" + `)[\w\s-]*$`)
+	// MATCH_MACRO_NAME matches a legal macro name.
+	MATCH_MACRO_NAME = regexp.MustCompile(`^[\w\-]+$`)
+)
+
+// message is a JSON-RPC request, response or notification.
+type message struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id,omitempty"`
+	Method  string           `json:"method,omitempty"`
+	Params  json.RawMessage  `json:"params,omitempty"`
+}
+
+type response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result"`
+}
+
+type errorResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Error   responseError   `json:"error"`
+}
+
+type responseError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type notification struct {
+	JSONRPC string      `json:"jsonrpc"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params"`
+}
+
+type position struct {
+	Line      int `json:"line"`
+	Character int `json:"character"` // UTF-16 code units.
+}
+
+type textRange struct {
+	Start position `json:"start"`
+	End   position `json:"end"`
+}
+
+type location struct {
+	URI   string    `json:"uri"`
+	Range textRange `json:"range"`
+}
+
+type diagnostic struct {
+	Range    textRange `json:"range"`
+	Severity int       `json:"severity"`
+	Code     string    `json:"code"`
+	Source   string    `json:"source"`
+	Message  string    `json:"message"`
+}
+
+type textEdit struct {
+	Range   textRange `json:"range"`
+	NewText string    `json:"newText"`
+}
+
+type completionItem struct {
+	Label  string `json:"label"`
+	Kind   int    `json:"kind"`
+	Detail string `json:"detail,omitempty"`
+}
+
+type documentSymbol struct {
+	Name           string            `json:"name"`
+	Detail         string            `json:"detail,omitempty"`
+	Kind           int               `json:"kind"`
+	Range          textRange         `json:"range"`
+	SelectionRange textRange         `json:"selectionRange"`
+	Children       []*documentSymbol `json:"children,omitempty"`
+}
+
+type textDocumentParams struct {
+	TextDocument struct {
+		URI  string `json:"uri"`
+		Text string `json:"text"`
+	} `json:"textDocument"`
+	Position       position `json:"position"`
+	NewName        string   `json:"newName"`
+	ContentChanges []struct {
+		Text string `json:"text"`
+	} `json:"contentChanges"`
+}
+
+// textDocument is an open Rimu document and the results of parsing it.
+type textDocument struct {
+	uri         string
+	lines       []string
+	diagnostics []options.CallbackMessage
+	macros      []macros.Definition // Macro definitions in source order.
+	references  []macros.Reference  // Macro invocations.
+	values      map[string]string   // Macro values after parsing.
+	headings    []toc.Heading
+	classes     []string // Sorted Block Attributes class names.
+}
+
+// Server is an LSP server that reads JSON-RPC messages from in and writes
+// them to out.
+type Server struct {
+	in       *bufio.Reader
+	out      io.Writer
+	docs     map[string]*textDocument // Open documents keyed by URI.
+	shutdown bool                     // True once a shutdown request has been received.
+}
+
+// Serve runs an LSP server that reads client messages from in and writes
+// server messages to out. It returns when an exit notification is received
+// or when in is exhausted. An error is returned if a message cannot be read
+// or written or if exit is received before a shutdown request.
+func Serve(in io.Reader, out io.Writer) error {
+	s := &Server{in: bufio.NewReader(in), out: out, docs: map[string]*textDocument{}}
+	for {
+		body, err := s.read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var msg message
+		if err := json.Unmarshal(body, &msg); err != nil {
+			if err := s.replyError(json.RawMessage("null"), ParseError, "parse error: "+err.Error()); err != nil {
+				return err
+			}
+			continue
+		}
+		if msg.Method == "exit" {
+			if !s.shutdown {
+				return errors.New("exit notification received before shutdown request")
+			}
+			return nil
+		}
+		if err := s.handle(&msg); err != nil {
+			return err
+		}
+	}
+}
+
+// read returns the body of the next message.
+func (s *Server) read() ([]byte, error) {
+	length := -1
+	for {
+		line, err := s.in.ReadString('\n')
+		if err != nil {
+			if err == io.EOF && line == "" && length == -1 {
+				return nil, io.EOF
+			}
+			return nil, fmt.Errorf("message header: %w", err)
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+		if value := strings.TrimPrefix(line, "Content-Length:"); value != line {
+			if length, err = strconv.Atoi(strings.TrimSpace(value)); err != nil {
+				return nil, fmt.Errorf("illegal Content-Length header: %s", line)
+			}
+		}
+	}
+	if length < 0 {
+		return nil, errors.New("missing Content-Length header")
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(s.in, body); err != nil {
+		return nil, fmt.Errorf("message body: %w", err)
+	}
+	return body, nil
+}
+
+// write writes a message to the client.
+func (s *Server) write(v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(s.out, "Content-Length: %d\r\n\r\n%s", len(body), body)
+	return err
+}
+
+func (s *Server) reply(id json.RawMessage, result interface{}) error {
+	return s.write(response{JSONRPC: "2.0", ID: id, Result: result})
+}
+
+func (s *Server) replyError(id json.RawMessage, code int, text string) error {
+	return s.write(errorResponse{JSONRPC: "2.0", ID: id, Error: responseError{Code: code, Message: text}})
+}
+
+func (s *Server) notify(method string, params interface{}) error {
+	return s.write(notification{JSONRPC: "2.0", Method: method, Params: params})
+}
+
+// handle processes a client request or notification.
+func (s *Server) handle(msg *message) error {
+	var params textDocumentParams
+	if len(msg.Params) > 0 {
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			if msg.ID == nil {
+				return nil // Notifications are not answered.
+			}
+			return s.replyError(*msg.ID, InvalidParams, "invalid params: "+err.Error())
+		}
+	}
+	if msg.ID == nil {
+		// Notifications.
+		uri := params.TextDocument.URI
+		switch msg.Method {
+		case "textDocument/didOpen":
+			return s.update(uri, params.TextDocument.Text)
+		case "textDocument/didChange":
+			if n := len(params.ContentChanges); n > 0 {
+				return s.update(uri, params.ContentChanges[n-1].Text) // Full text synchronization.
+			}
+		case "textDocument/didClose":
+			delete(s.docs, uri)
+			return s.notify("textDocument/publishDiagnostics", map[string]interface{}{"uri": uri, "diagnostics": []diagnostic{}})
+		}
+		return nil // Other notifications are ignored.
+	}
+	id := *msg.ID
+	if s.shutdown {
+		return s.replyError(id, InvalidRequest, "server is shutting down")
+	}
+	doc := s.docs[params.TextDocument.URI]
+	switch msg.Method {
+	case "initialize":
+		return s.reply(id, map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				"textDocumentSync":       textDocumentSyncAll,
+				"hoverProvider":          true,
+				"definitionProvider":     true,
+				"completionProvider":     map[string]interface{}{"triggerCharacters": []string{"{", "."}},
+				"documentSymbolProvider": true,
+				"renameProvider":         true,
+			},
+			"serverInfo": map[string]string{"name": "rimulsp"},
+		})
+	case "shutdown":
+		s.shutdown = true
+		return s.reply(id, nil)
+	case "textDocument/definition":
+		if doc == nil {
+			return s.reply(id, nil)
+		}
+		return s.reply(id, doc.definition(params.Position))
+	case "textDocument/hover":
+		if doc == nil {
+			return s.reply(id, nil)
+		}
+		return s.reply(id, doc.hover(params.Position))
+	case "textDocument/completion":
+		if doc == nil {
+			return s.reply(id, nil)
+		}
+		return s.reply(id, doc.completion(params.Position))
+	case "textDocument/documentSymbol":
+		if doc == nil {
+			return s.reply(id, nil)
+		}
+		return s.reply(id, doc.symbols())
+	case "textDocument/rename":
+		if doc == nil {
+			return s.reply(id, nil)
+		}
+		if !MATCH_MACRO_NAME.MatchString(params.NewName) || strings.HasPrefix(params.NewName, "--") {
+			return s.replyError(id, InvalidParams, "illegal macro name: "+params.NewName)
+		}
+		if name, _ := doc.macroAt(params.Position); strings.HasPrefix(name, "--") {
+			return s.replyError(id, RequestFailed, "predefined macros cannot be renamed: "+name)
+		}
+		edits := doc.rename(params.Position, params.NewName)
+		if edits == nil {
+			return s.replyError(id, RequestFailed, "no macro at the rename position")
+		}
+		return s.reply(id, map[string]interface{}{"changes": map[string][]textEdit{doc.uri: edits}})
+	}
+	return s.replyError(id, MethodNotFound, "method not found: "+msg.Method)
+}
+
+// update parses the document text and publishes its diagnostics.
+func (s *Server) update(uri string, text string) error {
+	doc := parse(uri, text)
+	s.docs[uri] = doc
+	return s.notify("textDocument/publishDiagnostics", map[string]interface{}{"uri": uri, "diagnostics": doc.diagnosticList()})
+}
+
+// parse returns the document parsed from text. Include elements are resolved
+// relative to file URIs. A parser panic is reported as a diagnostic.
+func parse(uri string, text string) (result *textDocument) {
+	result = &textDocument{uri: uri, lines: regexp.MustCompile(`\r\n|\r|\n`).Split(text, -1)}
+	defer func() {
+		if r := recover(); r != nil {
+			result.diagnostics = append(result.diagnostics, options.CallbackMessage{Kind: options.Error, Text: fmt.Sprintf("internal error: %v", r)})
+		}
+	}()
+	opts := options.RenderOptions{Callback: func(message options.CallbackMessage) {
+		result.diagnostics = append(result.diagnostics, message)
+	}}
+	if u, err := url.Parse(uri); err == nil && u.Scheme == "file" && strings.HasPrefix(u.Path, "/") {
+		var fsys fs.FS = os.DirFS("/")
+		opts.FS = fsys
+		opts.Path = u.Path[1:]
+	}
+	doc := document.New()
+	doc.UpdateOptions(opts)
+	tree := doc.Parse(text)
+	result.macros = doc.Macros.Definitions
+	result.references = doc.Macros.References
+	result.values = doc.Macros.Values()
+	result.headings = doc.Headings
+	classes := map[string]bool{}
+	ast.Walk(tree, func(n ast.Node) bool {
+		if attrs := ast.AttributesOf(n); attrs != nil {
+			for _, class := range strings.Fields(attrs.Classes) {
+				classes[class] = true
+			}
+		}
+		return true
+	})
+	for class := range classes {
+		result.classes = append(result.classes, class)
+	}
+	sort.Strings(result.classes)
+	return result
+}
+
+// line returns the text of the zero-based line number (blank if it does not
+// exist).
+func (d *textDocument) line(n int) string {
+	if n < 0 || n >= len(d.lines) {
+		return ""
+	}
+	return d.lines[n]
+}
+
+// diagnosticList returns the document's callback messages as LSP diagnostics.
+// Diagnostics extend from the callback message column to the end of the line.
+func (d *textDocument) diagnosticList() []diagnostic {
+	result := []diagnostic{}
+	for _, m := range d.diagnostics {
+		line := 0
+		if m.Line > 0 {
+			line = m.Line - 1
+		}
+		text := d.line(line)
+		start := columnOffset(text, m.Column)
+		severity := severityError
+		switch m.Kind {
+		case options.Warning:
+			severity = severityWarning
+		case options.Info:
+			severity = severityInfo
+		}
+		result = append(result, diagnostic{
+			Range:    d.rangeOf(line, start, len(text)),
+			Severity: severity,
+			Code:     m.Code,
+			Source:   "rimu",
+			Message:  m.Text,
+		})
+	}
+	return result
+}
+
+// rangeOf returns the LSP range of the byte offsets start and end of line n.
+func (d *textDocument) rangeOf(n int, start int, end int) textRange {
+	text := d.line(n)
+	return textRange{
+		Start: position{Line: n, Character: utf16Len(text[:start])},
+		End:   position{Line: n, Character: utf16Len(text[:end])},
+	}
+}
+
+// macroAt returns the name of the macro that is invoked or defined at pos
+// along with the range of the name. The name is blank if there is no macro
+// at pos.
+func (d *textDocument) macroAt(pos position) (string, textRange) {
+	text := d.line(pos.Line)
+	offset := byteOffset(text, pos.Character)
+	for _, loc := range MATCH_MACRO.FindAllStringSubmatchIndex(text, -1) {
+		if text[loc[0]] != '\\' && offset >= loc[0] && offset <= loc[3] {
+			return text[loc[2]:loc[3]], d.rangeOf(pos.Line, loc[2], loc[3])
+		}
+	}
+	return "", textRange{}
+}
+
+// definition returns the locations of the definitions of the macro at pos.
+func (d *textDocument) definition(pos position) []location {
+	name, _ := d.macroAt(pos)
+	result := []location{}
+	if name == "" {
+		return result
+	}
+	for _, def := range d.macros {
+		if def.Name == name {
+			result = append(result, location{URI: d.uri, Range: d.nameRange(def.Line-1, name)})
+		}
+	}
+	return result
+}
+
+// nameRange returns the range of the first macro name in line n (the start of
+// the line if it is not found e.g. the definition was in an included file).
+func (d *textDocument) nameRange(n int, name string) textRange {
+	if offset := d.nameOffset(n, name); offset >= 0 {
+		return d.rangeOf(n, offset, offset+len(name))
+	}
+	return d.rangeOf(n, 0, 0)
+}
+
+// nameOffset returns the byte offset of the first macro name in line n (-1 if
+// it is not found).
+func (d *textDocument) nameOffset(n int, name string) int {
+	text := d.line(n)
+	for _, loc := range MATCH_MACRO.FindAllStringSubmatchIndex(text, -1) {
+		if text[loc[0]] != '\\' && text[loc[2]:loc[3]] == name {
+			return loc[2]
+		}
+	}
+	return -1
+}
+
+// hover returns the hover content of the macro at pos: the definition in
+// effect at pos (the first definition if the macro is defined after pos).
+func (d *textDocument) hover(pos position) interface{} {
+	name, r := d.macroAt(pos)
+	if name == "" {
+		return nil
+	}
+	var def *macros.Definition
+	for i := range d.macros {
+		if d.macros[i].Name == name && (def == nil || d.macros[i].Line <= pos.Line+1) {
+			def = &d.macros[i]
+		}
+	}
+	value, found := d.values[name]
+	if def != nil {
+		value, found = def.Value, true
+	}
+	if !found {
+		return nil
+	}
+	return map[string]interface{}{
+		"contents": map[string]string{
+			"kind":  "markdown",
+			"value": "```rimu\n{" + name + "} = '" + value + "'\n```",
+		},
+		"range": r,
+	}
+}
+
+// completion returns the macro names following a '{' or the class names
+// following Block Attributes and delimiters.
+func (d *textDocument) completion(pos position) []completionItem {
+	text := d.line(pos.Line)
+	prefix := text[:byteOffset(text, pos.Character)]
+	result := []completionItem{}
+	switch {
+	case MATCH_MACRO_PREFIX.MatchString(prefix):
+		names := make([]string, 0, len(d.values))
+		for name := range d.values {
+			if name != "--" {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			result = append(result, completionItem{Label: name, Kind: completionVariable, Detail: d.values[name]})
+		}
+	case MATCH_CLASS_PREFIX.MatchString(prefix):
+		for _, class := range d.classes {
+			result = append(result, completionItem{Label: class, Kind: completionClass})
+		}
+	}
+	return result
+}
+
+// symbols returns the document outline: headers nested by level.
+func (d *textDocument) symbols() []*documentSymbol {
+	result := []*documentSymbol{}
+	var parents []*documentSymbol // Enclosing header symbols.
+	var levels []int              // Enclosing header levels.
+	for _, h := range d.headings {
+		n := h.Line - 1
+		name := h.Title()
+		if name == "" {
+			name = strings.TrimSpace(d.line(n))
+		}
+		r := d.rangeOf(n, 0, len(d.line(n)))
+		symbol := &documentSymbol{Name: name, Detail: "h" + strconv.Itoa(h.Level), Kind: symbolString, Range: r, SelectionRange: r}
+		for len(levels) > 0 && levels[len(levels)-1] >= h.Level {
+			parents = parents[:len(parents)-1]
+			levels = levels[:len(levels)-1]
+		}
+		if len(parents) == 0 {
+			result = append(result, symbol)
+		} else {
+			parent := parents[len(parents)-1]
+			parent.Children = append(parent.Children, symbol)
+		}
+		parents = append(parents, symbol)
+		levels = append(levels, h.Level)
+	}
+	return result
+}
+
+// rename returns the edits that rename the macro at pos in the macro
+// definitions and invocations that were parsed from the document (macro
+// invocation syntax in e.g. code blocks and comments is not renamed). Returns
+// nil if there is no macro at pos.
+func (d *textDocument) rename(pos position, newName string) []textEdit {
+	name, _ := d.macroAt(pos)
+	if name == "" {
+		return nil
+	}
+	offsets := map[[2]int]bool{} // Line numbers and byte offsets of the renamed names.
+	for _, def := range d.macros {
+		if offset := d.nameOffset(def.Line-1, name); def.Name == name && offset >= 0 {
+			offsets[[2]int{def.Line - 1, offset}] = true
+		}
+	}
+	for _, ref := range d.references {
+		n := ref.Line - 1
+		text := d.line(n)
+		start := columnOffset(text, ref.Column)
+		// Skip references that are not in the document text e.g. in included
+		// files or in lines generated by macro expansion.
+		if loc := MATCH_MACRO.FindStringSubmatchIndex(text[start:]); ref.Name == name && loc != nil && loc[0] == 0 && text[start] == '{' && text[start+loc[2]:start+loc[3]] == name {
+			offsets[[2]int{n, start + loc[2]}] = true
+		}
+	}
+	result := []textEdit{}
+	for offset := range offsets {
+		result = append(result, textEdit{Range: d.rangeOf(offset[0], offset[1], offset[1]+len(name)), NewText: newName})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i].Range.Start, result[j].Range.Start
+		return a.Line < b.Line || a.Line == b.Line && a.Character < b.Character
+	})
+	return result
+}
+
+// columnOffset returns the byte offset in line of the 1-based rune column
+// (the end of the line if column is past the end).
+func columnOffset(line string, column int) int {
+	offset := 0
+	for i := 1; i < column && offset < len(line); i++ {
+		_, size := utf8.DecodeRuneInString(line[offset:])
+		offset += size
+	}
+	return offset
+}
+
+// utf16Len returns the number of UTF-16 code units in s.
+func utf16Len(s string) (n int) {
+	for _, r := range s {
+		if r >= 0x10000 {
+			n += 2
+		} else {
+			n++
+		}
+	}
+	return
+}
+
+// byteOffset returns the byte offset in line of the UTF-16 code unit offset
+// character (the end of the line if character is past the end).
+func byteOffset(line string, character int) int {
+	units := 0
+	for i, r := range line {
+		if units >= character {
+			return i
+		}
+		if r >= 0x10000 {
+			units += 2
+		} else {
+			units++
+		}
+	}
+	return len(line)
+}
@@ -0,0 +1,155 @@
+package lsp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/srackham/go-rimu/v11/internal/assert"
+)
+
+const uri = "untitled:test.rmu"
+
+// exchange serves the client messages and returns the server messages.
+func exchange(t *testing.T, messages ...string) []string {
+	var in, out bytes.Buffer
+	for _, m := range messages {
+		fmt.Fprintf(&in, "Content-Length: %d\r\n\r\n%s", len(m), m)
+	}
+	assert.True(t, Serve(&in, &out) == nil)
+	var result []string
+	for _, m := range strings.SplitAfter(out.String(), "\r\n\r\n")[1:] {
+		result = append(result, strings.SplitN(m, "Content-Length:", 2)[0])
+	}
+	return result
+}
+
+func open(text string) string {
+	data, _ := json.Marshal(text)
+	return `{"jsonrpc":"2.0","method":"textDocument/didOpen","params":{"textDocument":{"uri":"` + uri + `","text":` + string(data) + `}}}`
+}
+
+func request(method string, line int, character int, extra string) string {
+	return fmt.Sprintf(`{"jsonrpc":"2.0","id":1,"method":"%s","params":{"textDocument":{"uri":"%s"},"position":{"line":%d,"character":%d}%s}}`,
+		method, uri, line, character, extra)
+}
+
+func TestProtocol(t *testing.T) {
+	out := exchange(t,
+		`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{}}`,
+		`{"jsonrpc":"2.0","method":"initialized","params":{}}`,
+		`{"jsonrpc":"2.0","id":2,"method":"foo"}`,
+		`{"jsonrpc":"2.0","method":"$/foo"}`,
+		`not json`,
+		`{"jsonrpc":"2.0","id":3,"method":"shutdown"}`,
+		`{"jsonrpc":"2.0","id":4,"method":"textDocument/hover"}`,
+		`{"jsonrpc":"2.0","method":"exit"}`,
+	)
+	assert.Equal(t, 5, len(out))
+	assert.Contains(t, out[0], `"textDocumentSync":1`)
+	assert.Contains(t, out[0], `"triggerCharacters":["{","."]`)
+	assert.Equal(t, `{"jsonrpc":"2.0","id":2,"error":{"code":-32601,"message":"method not found: foo"}}`, out[1])
+	assert.Contains(t, out[2], `"id":null,"error":{"code":-32700`)
+	assert.Equal(t, `{"jsonrpc":"2.0","id":3,"result":null}`, out[3])
+	assert.Contains(t, out[4], `"code":-32600`)
+
+	err := Serve(strings.NewReader("Content-Length: 33\r\n\r\n"+`{"jsonrpc":"2.0","method":"exit"}`), &bytes.Buffer{})
+	assert.Equal(t, "exit notification received before shutdown request", err.Error())
+	err = Serve(strings.NewReader("\r\n"), &bytes.Buffer{})
+	assert.Equal(t, "missing Content-Length header", err.Error())
+}
+
+func TestDiagnostics(t *testing.T) {
+	out := exchange(t, open("# Title\n\nHello {world}\n\n<p>Raw</p>"))
+	assert.Equal(t, 1, len(out))
+	assert.Contains(t, out[0], `"method":"textDocument/publishDiagnostics"`)
+	assert.Contains(t, out[0], `"range":{"start":{"line":2,"character":6},"end":{"line":2,"character":13}},"severity":1,"code":"undefined-macro","source":"rimu"`)
+
+	out = exchange(t,
+		open("Hello"),
+		`{"jsonrpc":"2.0","method":"textDocument/didChange","params":{"textDocument":{"uri":"`+uri+`"},"contentChanges":[{"text":"{x}"}]}}`,
+		`{"jsonrpc":"2.0","method":"textDocument/didClose","params":{"textDocument":{"uri":"`+uri+`"}}}`,
+	)
+	assert.Equal(t, 3, len(out))
+	assert.Contains(t, out[0], `"diagnostics":[]`)
+	assert.Contains(t, out[1], `"code":"undefined-macro"`)
+	assert.Contains(t, out[2], `"diagnostics":[]`)
+}
+
+func TestMacros(t *testing.T) {
+	text := "{x} = 'one'\n\n{x} and \\{x} and 😀{x}\n\n{x} = 'two'\n\n{x=}{y}"
+	out := exchange(t, open(text),
+		request("textDocument/definition", 2, 1, ""),
+		request("textDocument/hover", 2, 2, ""),
+		request("textDocument/hover", 2, 20, ""),
+		request("textDocument/hover", 6, 1, ""),
+		request("textDocument/hover", 2, 5, ""),
+		request("textDocument/rename", 0, 1, `,"newName":"z"`),
+		request("textDocument/rename", 0, 1, `,"newName":"a b"`),
+	)
+	assert.Equal(t, 8, len(out))
+	assert.Equal(t, `{"jsonrpc":"2.0","id":1,"result":[`+
+		`{"uri":"`+uri+`","range":{"start":{"line":0,"character":1},"end":{"line":0,"character":2}}},`+
+		`{"uri":"`+uri+`","range":{"start":{"line":4,"character":1},"end":{"line":4,"character":2}}}]}`, out[1])
+	assert.Contains(t, out[2], `"value":"`+"```rimu\\n{x} = 'one'\\n```"+`"`)
+	assert.Contains(t, out[2], `"range":{"start":{"line":2,"character":1},"end":{"line":2,"character":2}}`)
+	// The emoji is two UTF-16 code units.
+	assert.Contains(t, out[3], `"range":{"start":{"line":2,"character":20},"end":{"line":2,"character":21}}`)
+	assert.Contains(t, out[4], "{x} = 'two'")
+	assert.Equal(t, `{"jsonrpc":"2.0","id":1,"result":null}`, out[5])
+	assert.Equal(t, 5, strings.Count(out[6], `"newText":"z"`))
+	assert.False(t, strings.Contains(out[6], `"line":2,"character":10`))
+	assert.Contains(t, out[7], `"code":-32602`)
+
+	// Only macro definitions and invocations that are parsed are renamed.
+	text = "{x} = 'one'\n\n``\n{x}\n``\n\n// {x}\n\n{x} `{x}`\n\n{--header-ids}"
+	out = exchange(t, open(text),
+		request("textDocument/rename", 8, 1, `,"newName":"z"`),
+		request("textDocument/rename", 10, 2, `,"newName":"z"`),
+		request("textDocument/rename", 8, 1, `,"newName":"--z"`),
+	)
+	assert.Equal(t, 4, len(out))
+	assert.Equal(t, `{"jsonrpc":"2.0","id":1,"result":{"changes":{"`+uri+`":[`+
+		`{"range":{"start":{"line":0,"character":1},"end":{"line":0,"character":2}},"newText":"z"},`+
+		`{"range":{"start":{"line":8,"character":1},"end":{"line":8,"character":2}},"newText":"z"},`+
+		`{"range":{"start":{"line":8,"character":6},"end":{"line":8,"character":7}},"newText":"z"}]}}}`, out[1])
+	assert.Equal(t, `{"jsonrpc":"2.0","id":1,"error":{"code":-32803,"message":"predefined macros cannot be renamed: --header-ids"}}`, out[2])
+	assert.Contains(t, out[3], `"code":-32602`)
+}
+
+func TestCompletion(t *testing.T) {
+	text := "{x} = 'one'\n\n.info\nParagraph\n\n..\nDivision\n..\n\nHello {\n\n.\n\n..warning x\nText\n..\n"
+	out := exchange(t, open(text),
+		request("textDocument/completion", 9, 7, ""),
+		request("textDocument/completion", 11, 1, ""),
+		request("textDocument/completion", 5, 2, ""),
+		request("textDocument/completion", 3, 4, ""),
+	)
+	assert.Equal(t, 5, len(out))
+	assert.Contains(t, out[1], `{"label":"x","kind":6,"detail":"one"}`)
+	assert.False(t, strings.Contains(out[1], `"label":"--"`))
+	assert.Equal(t, `{"jsonrpc":"2.0","id":1,"result":[{"label":"info","kind":7},{"label":"warning","kind":7},{"label":"x","kind":7}]}`, out[2])
+	assert.Contains(t, out[3], `{"label":"info","kind":7}`)
+	assert.Equal(t, `{"jsonrpc":"2.0","id":1,"result":[]}`, out[4])
+}
+
+func TestSymbols(t *testing.T) {
+	text := "# One\n\n## Two\n\n### Three\n\n## Four\n\n# Five"
+	out := exchange(t, open(text), request("textDocument/documentSymbol", 0, 0, ""))
+	assert.Equal(t, 2, len(out))
+	var result struct {
+		Result []*documentSymbol `json:"result"`
+	}
+	assert.True(t, json.Unmarshal([]byte(out[1]), &result) == nil)
+	symbols := result.Result
+	assert.Equal(t, 2, len(symbols))
+	assert.Equal(t, "One", symbols[0].Name)
+	assert.Equal(t, 2, len(symbols[0].Children))
+	assert.Equal(t, "Three", symbols[0].Children[0].Children[0].Name)
+	assert.Equal(t, 4, symbols[0].Children[0].Children[0].Range.Start.Line)
+	assert.Equal(t, "Four", symbols[0].Children[1].Name)
+	assert.Equal(t, "Five", symbols[1].Name)
+	assert.Equal(t, 8, symbols[1].Range.Start.Line)
+}
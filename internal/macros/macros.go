@@ -2,19 +2,16 @@ package macros
 
 import (
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/srackham/go-rimu/v11/internal/options"
 	"github.com/srackham/go-rimu/v11/internal/spans"
 	"github.com/srackham/go-rimu/v11/internal/utils/re"
 )
 
-func init() {
-	Init()
-	spans.MacrosRender = Render
-}
-
 // Matches a line starting with a macro invocation. $1 = macro invocation.
 var MATCH_LINE = regexp.MustCompile(`^({(?:[\w\-]+)(?:[!=|?](?:|.*?[^\\]))?}).*$`)
 
@@ -34,20 +31,50 @@ type Macro struct {
 	value string
 }
 
-var defs []Macro
+// Definition is a macro definition parsed from the current document.
+type Definition struct {
+	Name  string // Macro name (excluding the existential '?' suffix).
+	Value string // Macro value.
+	Line  int    // Source line number.
+}
+
+// Reference is the source location of a macro invocation parsed from the
+// current document.
+type Reference struct {
+	Name   string // Macro name.
+	Line   int    // Source line number.
+	Column int    // Source column number of the opening '{'.
+}
+
+// Macros contains the macro definitions of a single document.
+type Macros struct {
+	defs        []Macro
+	Options     *options.Options
+	Spans       *spans.Spans
+	Definitions []Definition       // Macro definitions of the current document in source order.
+	References  []Reference        // Macro invocations of the current document in parse order.
+	referenced  map[Reference]bool // Recorded References.
+}
+
+// StartDocument resets per-document state.
+func (m *Macros) StartDocument() {
+	m.Definitions = nil
+	m.References = nil
+	m.referenced = nil
+}
 
 // Reset definitions to defaults.
-func Init() {
+func (m *Macros) Init() {
 	// Initialize predefined macros.
-	defs = []Macro{
+	m.defs = []Macro{
 		{name: "--", value: ""},
 		{name: "--header-ids", value: ""},
 	}
 }
 
 // Return true if macro is defined.
-func IsDefined(name string) bool {
-	for _, def := range defs {
+func (m *Macros) IsDefined(name string) bool {
+	for _, def := range m.defs {
 		if def.name == name {
 			return true
 		}
@@ -56,8 +83,8 @@ func IsDefined(name string) bool {
 }
 
 // Return named macro value. If it is not defined found is false.
-func Value(name string) (value string, found bool) {
-	for _, def := range defs {
+func (m *Macros) Value(name string) (value string, found bool) {
+	for _, def := range m.defs {
 		if def.name == name {
 			return def.value, true
 		}
@@ -66,44 +93,103 @@ func Value(name string) (value string, found bool) {
 }
 
 // Return true if macro value is non-blank.
-func IsNotBlank(name string) bool {
-	value, found := Value(name)
+func (m *Macros) IsNotBlank(name string) bool {
+	value, found := m.Value(name)
 	return found && value != ""
 }
 
 // Set named macro value or add it if it doesn't exist.
 // If the name ends with '?' then don't set the macro if it already exists.
-// `quote` is a single character: ' if a literal value, ` if an expression value.
-func SetValue(name string, value string, quote string) {
-	if options.SkipMacroDefs() {
+func (m *Macros) SetValue(name string, value string) {
+	if m.Options.SkipMacroDefs() {
 		return // Skip if a safe mode is set.
 	}
+	m.Definitions = append(m.Definitions, Definition{Name: strings.TrimSuffix(name, "?"), Value: value, Line: m.Options.LineNo()})
+	m.setValue(name, value)
+}
+
+func (m *Macros) setValue(name string, value string) {
 	existential := false
 	if strings.HasSuffix(name, "?") {
 		name = strings.TrimSuffix(name, "?")
 		existential = true
 	}
 	if name == "--" && value != "" {
-		options.ErrorCallback("the predefined blank '--' macro cannot be redefined")
+		m.Options.ErrorCallback(options.IllegalMacroDefinition, "the predefined blank '--' macro cannot be redefined")
 		return
 	}
-	if quote == "`" {
-		options.ErrorCallback("unsupported: expression macro values: `" + value + "`")
-	}
-	for i, def := range defs {
+	for i, def := range m.defs {
 		if def.name == name {
 			if !existential {
-				defs[i].value = value
+				m.defs[i].value = value
 			}
 			return
 		}
 	}
-	defs = append(defs, Macro{name: name, value: value})
+	m.defs = append(m.defs, Macro{name: name, value: value})
+}
+
+// Preload sets named macro values regardless of safe mode, values are set in
+// name order. Illegal macro names are reported by the callback.
+func (m *Macros) Preload(values map[string]string) {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !regexp.MustCompile(`^[\w\-]+\??$`).MatchString(name) {
+			m.Options.ErrorCallback(options.IllegalMacroDefinition, "illegal macro name: "+name)
+			continue
+		}
+		m.setValue(name, values[name])
+	}
+}
+
+// Values returns a copy of the macro definitions.
+func (m *Macros) Values() map[string]string {
+	result := make(map[string]string, len(m.defs))
+	for _, def := range m.defs {
+		result[def.name] = def.value
+	}
+	return result
+}
+
+// reference records the locations of the unescaped occurrences of the named
+// macro's invocation in the current source. Invocations generated by macro
+// expansion are not in the source and are not recorded.
+func (m *Macros) reference(name string, invocation string) {
+	invocation = strings.SplitN(invocation, "\n", 2)[0]
+	source := m.Options.Source()
+	for i, line := range source.Lines {
+		if i >= len(source.LineNos) {
+			break
+		}
+		for offset := 0; ; {
+			j := strings.Index(line[offset:], invocation)
+			if j < 0 {
+				break
+			}
+			j += offset
+			offset = j + len(invocation)
+			if j > 0 && line[j-1] == '\\' {
+				continue // Escaped.
+			}
+			ref := Reference{Name: name, Line: source.LineNos[i], Column: utf8.RuneCountInString(line[:j]) + 1}
+			if m.referenced == nil {
+				m.referenced = map[Reference]bool{}
+			}
+			if !m.referenced[ref] {
+				m.referenced[ref] = true
+				m.References = append(m.References, ref)
+			}
+		}
+	}
 }
 
 // Render all macro invocations in text string.
 // Render Simple invocations first, followed by Parametized, Inclusion and Exclusion invocations.
-func Render(text string, silent bool) (result string) {
+func (m *Macros) Render(text string, silent bool) (result string) {
 	MATCH_COMPLEX := regexp.MustCompile(`(?s)\\?\{([\w\-]+)([!=|?](?:|.*?[^\\]))}`) // Parametrized, Inclusion and Exclusion invocations.
 	MATCH_SIMPLE := regexp.MustCompile(`\\?\{([\w\-]+)()}`)                         // Simple macro invocation.
 	result = text
@@ -112,18 +198,22 @@ func Render(text string, silent bool) (result string) {
 			if match[0][0] == '\\' {
 				return match[0][1:]
 			}
+			m.reference(match[1], match[0])
 			params := match[2]
 			if params != "" && params[0] == '?' { // DEPRECATED: Existential macro invocation.
 				if !silent {
-					options.ErrorCallback("existential macro invocations are deprecated: " + match[0])
+					// Migrate to an existential macro definition at the start of the document.
+					m.Options.DeprecationWarning("existential macro invocations are deprecated: "+match[0], match[0],
+						options.Edit{Old: match[0], New: "{" + match[1] + "}"},
+						options.Edit{Line: 1, New: "{" + match[1] + "?}='" + params[1:] + "'"})
 				}
 				return match[0]
 			}
 			name := match[1]
-			value, found := Value(name)
+			value, found := m.Value(name)
 			if !found {
 				if !silent {
-					options.ErrorCallback("undefined macro: " + match[0] + ": " + text)
+					m.Options.ErrorCallbackNear(options.UndefinedMacro, "undefined macro: "+match[0]+": "+text, match[0])
 				}
 				return match[0]
 			}
@@ -171,7 +261,7 @@ func Render(text string, silent bool) (result string) {
 						}
 					}
 					if p1 == "$$" {
-						param = spans.Render(param)
+						param = m.Spans.Render(param)
 					}
 					return param
 				}, -1)
@@ -181,7 +271,7 @@ func Render(text string, silent bool) (result string) {
 				pre, err := regexp.Compile("^" + pattern + "$")
 				if err != nil {
 					if !silent {
-						options.ErrorCallback("illegal macro regular expression: " + pattern + ": " + text)
+						m.Options.ErrorCallbackNear(options.IllegalRegExp, "illegal macro regular expression: "+pattern+": "+text, match[0])
 					}
 					return match[0]
 				}
@@ -195,7 +285,7 @@ func Render(text string, silent bool) (result string) {
 					return ""
 				}
 			default:
-				options.ErrorCallback("illegal macro syntax: " + match[0])
+				m.Options.ErrorCallbackNear(options.IllegalMacroSyntax, "illegal macro syntax: "+match[0], match[0])
 				return ""
 			}
 
@@ -1,33 +1,36 @@
-package macros
+package macros_test
 
 import (
 	"testing"
 
 	"github.com/srackham/go-rimu/v11/internal/assert"
+	"github.com/srackham/go-rimu/v11/internal/document"
+	"github.com/srackham/go-rimu/v11/internal/macros"
+	"github.com/srackham/go-rimu/v11/internal/options"
 )
 
 func TestValues(t *testing.T) {
-	Init()
-	assert.Equal(t, 2, len(defs))
-	got, found := Value("--")
+	m := document.New().Macros
+	assert.Equal(t, 2, len(m.Values()))
+	got, found := m.Value("--")
 	assert.True(t, found)
 	assert.Equal(t, "", got)
 
-	SetValue("foo", "bar", "'")
-	assert.Equal(t, 3, len(defs))
-	got, found = Value("foo")
+	m.SetValue("foo", "bar")
+	assert.Equal(t, 3, len(m.Values()))
+	got, found = m.Value("foo")
 	assert.True(t, found)
 	assert.Equal(t, "bar", got)
 
-	SetValue("foo?", "baz", "'")
-	assert.Equal(t, 3, len(defs))
-	got, found = Value("foo")
+	m.SetValue("foo?", "baz")
+	assert.Equal(t, 3, len(m.Values()))
+	got, found = m.Value("foo")
 	assert.True(t, found)
 	assert.Equal(t, "bar", got)
 
-	SetValue("foo", "baz", "'")
-	assert.Equal(t, 3, len(defs))
-	got, found = Value("foo")
+	m.SetValue("foo", "baz")
+	assert.Equal(t, 3, len(m.Values()))
+	got, found = m.Value("foo")
 	assert.True(t, found)
 	assert.Equal(t, "baz", got)
 }
@@ -40,8 +43,34 @@ func TestRender(t *testing.T) {
 		{"", ""},
 		{"{--}{--header-ids}", ""},
 	}
+	m := document.New().Macros
 	for _, tt := range tests {
-		got := Render(tt.text, false)
+		got := m.Render(tt.text, false)
 		assert.Equal(t, tt.want, got)
 	}
 }
+
+func TestDefinitions(t *testing.T) {
+	m := document.New().Macros
+	m.SetValue("foo", "bar")
+	m.SetValue("foo?", "baz")
+	assert.Equal(t, 2, len(m.Definitions))
+	assert.Equal(t, "foo", m.Definitions[1].Name)
+	assert.Equal(t, "baz", m.Definitions[1].Value)
+	m.StartDocument()
+	assert.Equal(t, 0, len(m.Definitions))
+}
+
+func TestReferences(t *testing.T) {
+	m := document.New().Macros
+	m.SetValue("x", "1")
+	m.Options.SetSource(options.Source{Lines: []string{"{x} \\{x}", "😀{x} {x|a}"}, LineNos: []int{3, 4}})
+	m.Render("{x} \\{x}\n😀{x} {x|a}", false)
+	m.Render("{x}", true)
+	assert.Equal(t, 3, len(m.References))
+	assert.Equal(t, macros.Reference{Name: "x", Line: 3, Column: 1}, m.References[0])
+	assert.Equal(t, macros.Reference{Name: "x", Line: 4, Column: 2}, m.References[1])
+	assert.Equal(t, macros.Reference{Name: "x", Line: 4, Column: 6}, m.References[2])
+	m.StartDocument()
+	assert.Equal(t, 0, len(m.References))
+}
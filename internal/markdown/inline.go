@@ -0,0 +1,327 @@
+package markdown
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+// Markdown inline elements.
+var (
+	MATCH_AUTOLINK    = regexp.MustCompile(`^<(?:[a-zA-Z][a-zA-Z0-9+.\-]{1,31}:[^\s<>]*|[\w.!#$%&'*+/=?^{|}~\-]+@[a-zA-Z0-9](?:[a-zA-Z0-9\-.]*[a-zA-Z0-9])?)>`)
+	MATCH_INLINE_HTML = regexp.MustCompile(`(?s)^(?:<[a-zA-Z][\w\-]*(?:\s+[a-zA-Z_:][\w.:\-]*(?:\s*=\s*(?:[^\s"'=<>` + "`" + `]+|'[^']*'|"[^"]*"))?)*\s*/?>|</[a-zA-Z][\w\-]*\s*>|<!--.*?-->)`)
+	MATCH_RIMU_LINK   = regexp.MustCompile(`^\[[^[]*?\]\(\S+?\)`)
+	MATCH_RIMU_MACRO  = regexp.MustCompile(`\{[\w\-]`)
+	MATCH_RIMU_URL    = regexp.MustCompile(`^<(?:\S+?\|(?s:.*?)|[^|\s]+?)>`)
+)
+
+// inline returns Markdown inline text converted to Rimu Markup. Emphasis,
+// strong emphasis and strikethrough are the same in Rimu and are not changed.
+func (c *converter) inline(text string) string {
+	var b strings.Builder
+	literal := 0 // Text before this index is inside an escaped Rimu link.
+	for i := 0; i < len(text); {
+		switch text[i] {
+		case '\\':
+			if i+1 < len(text) {
+				b.WriteString(escape(text[i+1:]))
+				i += 2
+				continue
+			}
+		case '`':
+			if code, n := codeSpan(text[i:]); n > 0 {
+				b.WriteString(code)
+				i += n
+				continue
+			}
+			// Unmatched backtick strings are literal.
+			n := len(text[i:]) - len(strings.TrimLeft(text[i:], "`"))
+			b.WriteString(strings.Repeat("&#96;", n))
+			i += n
+			continue
+		case '!', '[':
+			if s, n := c.link(text[i:]); n > 0 {
+				b.WriteString(s)
+				i += n
+				continue
+			}
+			if text[i] == '!' && strings.HasPrefix(text[i:], "![") {
+				b.WriteString("![")
+				i += 2
+				continue
+			}
+		case '<':
+			m := MATCH_AUTOLINK.FindString(text[i:])
+			if m == "" {
+				m = MATCH_INLINE_HTML.FindString(text[i:])
+			}
+			if m != "" {
+				b.WriteString(m)
+				i += len(m)
+				continue
+			}
+			if i >= literal {
+				if m := MATCH_RIMU_URL.FindString(text[i:]); m != "" {
+					b.WriteString(`\`) // Not a Rimu link or anchor.
+					literal = i + len(m)
+				}
+			}
+		case '{':
+			if loc := MATCH_RIMU_MACRO.FindStringIndex(text[i:]); loc != nil && loc[0] == 0 {
+				b.WriteString(`\`) // Not a Rimu macro invocation.
+			}
+		}
+		b.WriteByte(text[i])
+		i++
+	}
+	return b.String()
+}
+
+// MARKDOWN_PUNCTUATION contains the characters that can be backslash escaped.
+const MARKDOWN_PUNCTUATION = "!\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~"
+
+// escape returns the Rimu equivalent of a Markdown backslash escaped
+// character (text starts with the escaped character).
+func escape(text string) string {
+	c := text[0]
+	switch {
+	case c == '\n':
+		return " \\\n" // Hard line break.
+	case strings.IndexByte("*_`<&{", c) >= 0:
+		return `\` + text[:1]
+	case c == '[' && MATCH_RIMU_LINK.MatchString(text):
+		return `\[`
+	case strings.IndexByte("!\"#$%'()+,-./:;=>?@[\\]^|~}", c) >= 0:
+		return text[:1]
+	}
+	return `\` + text[:1]
+}
+
+// codeSpan returns the Rimu code quote converted from the Markdown code span
+// at the start of text and the length of the code span. The returned length
+// is zero if there is no code span.
+func codeSpan(text string) (string, int) {
+	n := len(text) - len(strings.TrimLeft(text, "`"))
+	delimiter := text[:n]
+	for i := n; i < len(text); {
+		j := strings.Index(text[i:], delimiter)
+		if j < 0 {
+			break
+		}
+		j += i
+		end := j + n
+		if end < len(text) && text[end] == '`' {
+			// Skip longer backtick strings.
+			i = end + len(text[end:]) - len(strings.TrimLeft(text[end:], "`"))
+			continue
+		}
+		code := strings.ReplaceAll(text[n:j], "\n", " ")
+		if len(code) > 2 && code[0] == ' ' && code[len(code)-1] == ' ' && strings.TrimSpace(code) != "" {
+			code = code[1 : len(code)-1]
+		}
+		code = MATCH_RIMU_MACRO.ReplaceAllString(code, `\$0`)
+		if code != "" && code == strings.TrimSpace(code) && !strings.Contains(code, "`") {
+			return "`" + code + "`", end
+		}
+		return "<code>" + html.EscapeString(code) + "</code>", end
+	}
+	return "", 0
+}
+
+// link returns the Rimu link or image converted from the Markdown link or image
+// at the start of text and the length of the Markdown link or image. The
+// returned length is zero if there is no link or image.
+func (c *converter) link(text string) (string, int) {
+	image := strings.HasPrefix(text, "!")
+	start := 1
+	if image {
+		if !strings.HasPrefix(text, "![") {
+			return "", 0
+		}
+		start = 2
+	}
+	end := closingBracket(text, start-1)
+	if end < 0 {
+		return "", 0
+	}
+	label := text[start:end]
+	if strings.HasPrefix(label, "^") && !image {
+		// Footnote reference.
+		return "[^" + footnoteLabel(label[1:]) + "]", end + 1
+	}
+	url, title := "", ""
+	n := 0
+	switch {
+	case strings.HasPrefix(text[end+1:], "("):
+		var ok bool
+		url, title, n, ok = destination(text[end+1:])
+		if !ok {
+			return "", 0
+		}
+	case strings.HasPrefix(text[end+1:], "["):
+		close := strings.Index(text[end+1:], "]")
+		if close < 0 {
+			return "", 0
+		}
+		ref := text[end+2 : end+1+close]
+		if ref == "" {
+			ref = label
+		}
+		r, ok := c.refs[normalizeLabel(ref)]
+		if !ok {
+			return "", 0
+		}
+		url, title = r.url, r.title
+		n = close + 1
+	default:
+		r, ok := c.refs[normalizeLabel(label)]
+		if !ok {
+			return "", 0
+		}
+		url, title = r.url, r.title
+	}
+	length := end + 1 + n
+	url = strings.NewReplacer(" ", "%20", "|", "%7C", ">", "%3E", "<", "%3C").Replace(url)
+	titleAttr := ""
+	if quoted, ok := attribute(title); ok && title != "" {
+		titleAttr = " title=" + quoted
+	}
+	if image {
+		alt := plainText(label)
+		switch {
+		case titleAttr != "" || strings.Contains(alt, ">"):
+			return `<img src="` + html.EscapeString(url) + `" alt="` + html.EscapeString(alt) + `"` + titleAttr + `>`, length
+		case alt == "":
+			return "<image:" + url + ">", length
+		}
+		return "<image:" + url + "|" + alt + ">", length
+	}
+	if titleAttr == "" && (label == "" || label == url) {
+		return "<" + url + ">", length
+	}
+	linkText := c.inline(label)
+	if label == "" {
+		linkText = c.inline(url)
+	}
+	if titleAttr != "" || strings.Contains(linkText, ">") {
+		return `<a href="` + html.EscapeString(url) + `"` + titleAttr + `>` + linkText + "</a>", length
+	}
+	return "<" + url + "|" + linkText + ">", length
+}
+
+// attribute returns s quoted as a Rimu inline HTML tag attribute value and
+// true, or false if s contains characters that are not allowed in Rimu inline
+// tags (<, > and &) or both single and double quotes.
+func attribute(s string) (string, bool) {
+	s = strings.ReplaceAll(s, "{", `\{`) // Escape macro invocations.
+	switch {
+	case strings.ContainsAny(s, "<>&"):
+		return "", false
+	case !strings.Contains(s, `"`):
+		return `"` + s + `"`, true
+	case !strings.Contains(s, "'"):
+		return "'" + s + "'", true
+	}
+	return "", false
+}
+
+// closingBracket returns the index of the bracket closing the bracket at
+// text[open] (or -1 if it is not closed). Escaped brackets, brackets in code
+// spans and nested brackets are skipped.
+func closingBracket(text string, open int) int {
+	depth := 0
+	for i := open; i < len(text); i++ {
+		switch text[i] {
+		case '\\':
+			i++
+		case '`':
+			if _, n := codeSpan(text[i:]); n > 0 {
+				i += n - 1
+			}
+		case '[':
+			depth++
+		case ']':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// destination parses an inline link destination and optional title, text
+// starts with the opening parenthesis. Returns the unescaped URL, the
+// unescaped title, the length of the destination and title and false if the
+// destination is not valid.
+func destination(text string) (string, string, int, bool) {
+	i := 1
+	skipSpace := func() {
+		for i < len(text) && strings.IndexByte(" \t\n", text[i]) >= 0 {
+			i++
+		}
+	}
+	skipSpace()
+	url, title := "", ""
+	if i < len(text) && text[i] == '<' {
+		end := strings.IndexAny(text[i+1:], ">\n")
+		if end < 0 || text[i+1+end] != '>' {
+			return "", "", 0, false
+		}
+		url = text[i+1 : i+1+end]
+		i += end + 2
+	} else {
+		depth := 0
+		start := i
+		for ; i < len(text); i++ {
+			c := text[i]
+			if c == '\\' && i+1 < len(text) {
+				i++
+				continue
+			}
+			if c == '(' {
+				depth++
+			} else if c == ')' {
+				if depth == 0 {
+					break
+				}
+				depth--
+			} else if c <= ' ' {
+				break
+			}
+		}
+		url = text[start:i]
+	}
+	skipSpace()
+	if i < len(text) && strings.IndexByte(`"'(`, text[i]) >= 0 {
+		close := text[i]
+		if close == '(' {
+			close = ')'
+		}
+		end := strings.IndexByte(text[i+1:], close)
+		if end < 0 {
+			return "", "", 0, false
+		}
+		title = unescape(text[i+1 : i+1+end])
+		i += end + 2
+		skipSpace()
+	}
+	if i >= len(text) || text[i] != ')' {
+		return "", "", 0, false
+	}
+	return unescape(url), title, i + 1, true
+}
+
+// unescape returns text with backslash escapes removed and character
+// references replaced by the characters.
+func unescape(text string) string {
+	text = regexp.MustCompile(`\\([[:punct:]])`).ReplaceAllString(text, "$1")
+	return html.UnescapeString(text)
+}
+
+// plainText returns Markdown inline text without emphasis and code
+// delimiters and with backslash escapes removed.
+func plainText(text string) string {
+	text = regexp.MustCompile("[*_`]+").ReplaceAllString(text, "")
+	return regexp.MustCompile(`\\([[:punct:]])`).ReplaceAllString(text, "$1")
+}
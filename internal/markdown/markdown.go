@@ -0,0 +1,612 @@
+/*
+  Markdown (CommonMark/GFM) to Rimu Markup source converter.
+*/
+
+package markdown
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// Markdown block elements.
+var (
+	MATCH_FENCE           = regexp.MustCompile("^( {0,3})(`{3,}|~{3,})[ \t]*(.*)$")
+	MATCH_ATX_HEADER      = regexp.MustCompile(`^ {0,3}(#{1,6})(?:[ \t]+(.*?))??(?:[ \t]+#+)?[ \t]*$`)
+	MATCH_SETEXT          = regexp.MustCompile(`^ {0,3}(=+|-+)[ \t]*$`)
+	MATCH_THEMATIC_BREAK  = regexp.MustCompile(`^ {0,3}(?:(?:\*[ \t]*){3,}|(?:-[ \t]*){3,}|(?:_[ \t]*){3,})$`)
+	MATCH_BLOCKQUOTE      = regexp.MustCompile(`^ {0,3}> ?`)
+	MATCH_LIST_ITEM       = regexp.MustCompile(`^( {0,3})([-+*]|(\d{1,9})[.)])(?:( +)(.*))?$`)
+	MATCH_HTML_BLOCK      = regexp.MustCompile(`(?i)^ {0,3}<(?:!--|\?|![A-Z]|!\[CDATA\[|(?:script|pre|style|textarea)(?:[\s>]|$)|/?(?:address|article|aside|base|basefont|blockquote|body|caption|center|col|colgroup|dd|details|dialog|dir|div|dl|dt|fieldset|figcaption|figure|footer|form|frame|frameset|h[1-6]|head|header|hr|html|iframe|legend|li|link|main|menu|menuitem|nav|noframes|ol|optgroup|option|p|param|search|section|summary|table|tbody|td|tfoot|th|thead|title|tr|track|ul)(?:[\s/>]|$))`)
+	MATCH_FOOTNOTE        = regexp.MustCompile(`^ {0,3}\[\^([^\]\s]+)\]:[ \t]*(.*)$`)
+	MATCH_TABLE_DELIMITER = regexp.MustCompile(`^ {0,3}\|?[ \t]*:?-+:?[ \t]*(?:\|[ \t]*:?-+:?[ \t]*)*\|?[ \t]*$`)
+	MATCH_LINK_DEFINITION = regexp.MustCompile(`^ {0,3}\[((?:[^\]\\]|\\.)+)\]:[ \t]*(<[^>\n]*>|\S+)(?:[ \t]+("(?:[^"\\]|\\.)*"|'(?:[^'\\]|\\.)*'|\((?:[^)\\]|\\.)*\)))?[ \t]*$`)
+)
+
+// Rimu elements that are escaped when they start a converted paragraph.
+var (
+	MATCH_RIMU_BLOCK = []*regexp.Regexp{
+		regexp.MustCompile(`^\/{2}`),                                         // Comment line.
+		regexp.MustCompile(`^\/\*+$`),                                        // Comment block.
+		regexp.MustCompile(`^[#=]{1,6}\s+\S`),                                // Header.
+		regexp.MustCompile(`^\.[a-zA-Z#"\[+-]`),                              // Block Attributes.
+		regexp.MustCompile(`^\.(toc$|include\s|\w+\s*=)`),                    // .toc, .include and API options.
+		regexp.MustCompile(`^\{[\w\-]+\??\}\s*=`),                            // Macro definition.
+		regexp.MustCompile(`^\|[\w\-]+\|\s*=\s*'`),                           // Delimited Block definition.
+		regexp.MustCompile(`^\/.+\/[igm]*\s*=\s*'`),                          // Replacement definition.
+		regexp.MustCompile(`^<<#[a-zA-Z][\w\-]*>>$`),                         // Anchor.
+		regexp.MustCompile("^(\\.{2,}|\"{2,}|>{2,}|-{2,}|`{2,})[\\w\\s-]*$"), // Block delimiter.
+		regexp.MustCompile(`(?i)^!{2,}[ \t]*(note|tip|important|caution|warning|danger)\b`),
+		regexp.MustCompile(`^\|.*\|$`),        // Table.
+		regexp.MustCompile(`^>`),              // Quote paragraph.
+		regexp.MustCompile(`^\[\^[\w\-]+\]:`), // Footnote.
+		MATCH_RIMU_ITEM,
+	}
+	MATCH_RIMU_HEADER_END = regexp.MustCompile(`\s[#=]{1,6}$`)
+	MATCH_RIMU_ITEM       = regexp.MustCompile(`^\s*(-|\+|\*{1,4}|\d*\.{1,4})\s+|^\s*.*[^:]:{2,4}(\s|$)`)
+)
+
+// Rimu list IDs by nesting level.
+var (
+	bulletIDs  = []string{"-", "*", "**", "***", "****", "+"}
+	orderedIDs = []string{".", "..", "...", "...."}
+)
+
+// converter converts a single Markdown document.
+type converter struct {
+	refs map[string]linkRef // Link reference definitions keyed by normalized label.
+}
+
+// linkRef is a link reference definition.
+type linkRef struct {
+	url   string
+	title string // Unescaped title (blank if there is no title).
+}
+
+// block is a converted block element.
+type block struct {
+	text   string
+	para   bool   // Paragraph (the text is not escaped).
+	source string // Paragraph Markdown inline text.
+	list   bool
+}
+
+// ToRimu returns Markdown (CommonMark/GFM) text converted to Rimu Markup.
+// Headers, fenced and indented code, block quotes, lists, links, images and
+// footnotes are converted to their Rimu equivalents. Reference links are
+// inlined, links and images with titles are converted to HTML tags and tables
+// are converted to HTML blocks. Titles containing <, > or & characters (which
+// are not allowed in Rimu inline HTML tags) are dropped.
+func ToRimu(text string) string {
+	c := &converter{refs: map[string]linkRef{}}
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = expandTabs(line)
+	}
+	lines = c.definitions(lines)
+	result := c.join(c.blocks(lines, 0, 0))
+	if result == "" {
+		return ""
+	}
+	return result + "\n"
+}
+
+// definitions returns lines with link reference definitions removed. The
+// definitions are saved for inlining.
+func (c *converter) definitions(lines []string) (result []string) {
+	fence := ""
+	paragraph := false
+	for _, line := range lines {
+		if fence != "" {
+			if m := MATCH_FENCE.FindStringSubmatch(line); m != nil && m[2][0] == fence[0] && len(m[2]) >= len(fence) && strings.TrimSpace(m[3]) == "" {
+				fence = ""
+			}
+			result = append(result, line)
+			continue
+		}
+		if m := MATCH_FENCE.FindStringSubmatch(line); m != nil {
+			fence = m[2]
+		} else if m := MATCH_LINK_DEFINITION.FindStringSubmatch(line); m != nil && !paragraph && !strings.HasPrefix(m[1], "^") {
+			label := normalizeLabel(m[1])
+			if _, ok := c.refs[label]; !ok {
+				title := ""
+				if m[3] != "" {
+					title = unescape(m[3][1 : len(m[3])-1])
+				}
+				c.refs[label] = linkRef{url: strings.TrimSuffix(strings.TrimPrefix(m[2], "<"), ">"), title: title}
+			}
+			continue
+		}
+		paragraph = strings.TrimSpace(line) != "" && indentOf(line) < 4
+		result = append(result, line)
+	}
+	return
+}
+
+// join returns converted blocks separated by blank lines. Two blank lines
+// terminate a list that would otherwise absorb the next block.
+func (c *converter) join(blocks []block) string {
+	var b strings.Builder
+	for i, blk := range blocks {
+		text := blk.text
+		if blk.para {
+			text = c.escapeBlock(text, blk.source)
+		}
+		if i > 0 {
+			b.WriteString("\n\n")
+			if blocks[i-1].list && (blk.list || strings.HasPrefix(text, ">") || MATCH_RIMU_ITEM.MatchString(text)) {
+				b.WriteString("\n")
+			}
+		}
+		b.WriteString(text)
+	}
+	return b.String()
+}
+
+// blocks returns the converted blocks of Markdown lines. bullets and ordered
+// are the number of enclosing unordered and ordered lists.
+func (c *converter) blocks(lines []string, bullets int, ordered int) (result []block) {
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		switch {
+		case strings.TrimSpace(line) == "":
+			i++
+		case MATCH_FENCE.MatchString(line) && !(line[indentOf(line)] == '`' && strings.Contains(MATCH_FENCE.FindStringSubmatch(line)[3], "`")):
+			var blk block
+			blk, i = c.fencedCode(lines, i)
+			result = append(result, blk)
+		case MATCH_ATX_HEADER.MatchString(line):
+			m := MATCH_ATX_HEADER.FindStringSubmatch(line)
+			result = append(result, c.header(len(m[1]), m[2]))
+			i++
+		case MATCH_THEMATIC_BREAK.MatchString(line):
+			result = append(result, block{text: "<hr>"})
+			i++
+		case MATCH_BLOCKQUOTE.MatchString(line):
+			var blk block
+			blk, i = c.blockquote(lines, i)
+			result = append(result, blk)
+		case MATCH_LIST_ITEM.MatchString(line):
+			var blk block
+			blk, i = c.list(lines, i, bullets, ordered)
+			result = append(result, blk)
+		case MATCH_HTML_BLOCK.MatchString(line):
+			start := i
+			for i < len(lines) && strings.TrimSpace(lines[i]) != "" {
+				i++
+			}
+			result = append(result, block{text: strings.Join(lines[start:i], "\n")})
+		case MATCH_FOOTNOTE.MatchString(line):
+			var blk block
+			blk, i = c.footnote(lines, i)
+			result = append(result, blk)
+		case i+1 < len(lines) && strings.Contains(line, "|") && MATCH_TABLE_DELIMITER.MatchString(lines[i+1]):
+			var blk block
+			blk, i = c.table(lines, i)
+			result = append(result, blk)
+		case indentOf(line) >= 4:
+			var code []string
+			for i < len(lines) && (strings.TrimSpace(lines[i]) == "" || indentOf(lines[i]) >= 4) {
+				code = append(code, strings.TrimPrefix(lines[i], "    "))
+				i++
+			}
+			for strings.TrimSpace(code[len(code)-1]) == "" {
+				code = code[:len(code)-1]
+			}
+			result = append(result, codeBlock(code, ""))
+		default:
+			var blk block
+			blk, i = c.paragraph(lines, i)
+			result = append(result, blk)
+		}
+	}
+	return
+}
+
+// interrupts returns true if line starts a block that interrupts a paragraph.
+func interrupts(line string) bool {
+	if m := MATCH_LIST_ITEM.FindStringSubmatch(line); m != nil {
+		// Empty items and ordered items not starting at 1 do not interrupt paragraphs.
+		return strings.TrimSpace(m[5]) != "" && (m[3] == "" || m[3] == "1")
+	}
+	return MATCH_FENCE.MatchString(line) || MATCH_ATX_HEADER.MatchString(line) ||
+		MATCH_THEMATIC_BREAK.MatchString(line) || MATCH_BLOCKQUOTE.MatchString(line) ||
+		MATCH_HTML_BLOCK.MatchString(line)
+}
+
+// paragraph converts a paragraph or a setext header starting at lines[i] and
+// returns the block and the index of the next line.
+func (c *converter) paragraph(lines []string, i int) (block, int) {
+	var text []string
+	for i < len(lines) && strings.TrimSpace(lines[i]) != "" {
+		if len(text) > 0 {
+			if m := MATCH_SETEXT.FindStringSubmatch(lines[i]); m != nil {
+				level := 1
+				if m[1][0] == '-' {
+					level = 2
+				}
+				return c.header(level, strings.Join(text, " ")), i + 1
+			}
+			if interrupts(lines[i]) {
+				break
+			}
+		}
+		text = append(text, strings.TrimLeft(lines[i], " "))
+		i++
+	}
+	source := hardBreaks(text)
+	return block{text: c.inline(source), para: true, source: source}, i
+}
+
+// header returns a Rimu header.
+func (c *converter) header(level int, text string) block {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return block{text: fmt.Sprintf("<h%d></h%d>", level, level)}
+	}
+	// A trailing Rimu header marker is not part of the header text.
+	text = MATCH_RIMU_HEADER_END.ReplaceAllStringFunc(c.inline(text), func(marker string) string {
+		return regexp.MustCompile(`[#=]`).ReplaceAllStringFunc(marker, func(s string) string {
+			return fmt.Sprintf("&#%d;", s[0])
+		})
+	})
+	return block{text: strings.Repeat("#", level) + " " + text}
+}
+
+// fencedCode converts the fenced code block starting at lines[i] and returns
+// the block and the index of the next line.
+func (c *converter) fencedCode(lines []string, i int) (block, int) {
+	m := MATCH_FENCE.FindStringSubmatch(lines[i])
+	indent, fence := len(m[1]), m[2]
+	info := strings.Fields(html.UnescapeString(m[3]))
+	var code []string
+	for i++; i < len(lines); i++ {
+		if m := MATCH_FENCE.FindStringSubmatch(lines[i]); m != nil && m[2][0] == fence[0] && len(m[2]) >= len(fence) && strings.TrimSpace(m[3]) == "" {
+			i++
+			break
+		}
+		line := lines[i]
+		n := indentOf(line)
+		if n > indent {
+			n = indent
+		}
+		code = append(code, line[n:])
+	}
+	class := ""
+	if len(info) > 0 {
+		class = strings.TrimPrefix(info[0], "language-")
+	}
+	return codeBlock(code, class), i
+}
+
+// codeBlock returns a Rimu code block. The class name is dropped if it is not
+// a valid Rimu class name.
+func codeBlock(code []string, class string) block {
+	if !regexp.MustCompile(`^[\w-]+$`).MatchString(class) {
+		class = ""
+	}
+	delimiter := delimiterFor("``", code)
+	lines := append([]string{delimiter + class}, code...)
+	return block{text: strings.Join(append(lines, delimiter), "\n")}
+}
+
+// delimiterFor returns the shortest delimiter (repeating the delimiter
+// characters) that does not match a line of the block content.
+func delimiterFor(delimiter string, content []string) string {
+	for {
+		found := false
+		for _, line := range content {
+			if line == delimiter || strings.HasPrefix(line, delimiter) && strings.Trim(line, delimiter[:1]) == "" {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return delimiter
+		}
+		delimiter += delimiter[:1]
+	}
+}
+
+// blockquote converts the block quote starting at lines[i] and returns the
+// block and the index of the next line. A quoted paragraph is converted to a
+// Rimu quote paragraph, other quotes to a Rimu quote block.
+func (c *converter) blockquote(lines []string, i int) (block, int) {
+	var content []string
+	for i < len(lines) && strings.TrimSpace(lines[i]) != "" {
+		if loc := MATCH_BLOCKQUOTE.FindStringIndex(lines[i]); loc != nil {
+			content = append(content, lines[i][loc[1]:])
+		} else if interrupts(lines[i]) {
+			break
+		} else {
+			content = append(content, lines[i]) // Lazy continuation line.
+		}
+		i++
+	}
+	blocks := c.blocks(content, 0, 0)
+	if len(blocks) == 1 && blocks[0].para {
+		text := blocks[0].text
+		if strings.HasPrefix(text, ">") {
+			text = "&#62;" + text[1:] // Otherwise the first line could be a Rimu quote block delimiter.
+		}
+		return block{text: ">" + strings.ReplaceAll(text, "\n", "\n>")}, i
+	}
+	text := c.join(blocks)
+	delimiter := delimiterFor(`""`, strings.Split(text, "\n"))
+	return block{text: delimiter + "\n" + text + "\n" + delimiter}, i
+}
+
+// list converts the list starting at lines[i] and returns the block and the
+// index of the next line.
+func (c *converter) list(lines []string, i int, bullets int, ordered int) (block, int) {
+	var items []string
+	first := MATCH_LIST_ITEM.FindStringSubmatch(lines[i])
+	kind := listKind(first)
+	number := 1
+	if first[3] != "" {
+		fmt.Sscan(first[3], &number)
+	}
+	for i < len(lines) {
+		m := MATCH_LIST_ITEM.FindStringSubmatch(lines[i])
+		if m == nil || listKind(m) != kind {
+			break
+		}
+		// Item content lines are indented to the item content column.
+		width := len(m[1]) + len(m[2]) + len(m[4])
+		content := []string{m[5]}
+		if m[5] == "" || len(m[4]) > 4 {
+			width = len(m[1]) + len(m[2]) + 1
+			content = []string{strings.TrimPrefix(m[4], " ") + m[5]}
+		}
+		for i++; i < len(lines); i++ {
+			line := lines[i]
+			switch {
+			case strings.TrimSpace(line) == "":
+				content = append(content, "")
+				continue
+			case indentOf(line) >= width:
+				content = append(content, line[width:])
+				continue
+			case content[len(content)-1] != "" && !interrupts(line) && !MATCH_LIST_ITEM.MatchString(line):
+				content = append(content, line) // Lazy continuation line.
+				continue
+			}
+			break
+		}
+		// Trailing blank lines separate items.
+		for len(content) > 1 && content[len(content)-1] == "" {
+			content = content[:len(content)-1]
+		}
+		id := bulletIDs[min(bullets, len(bulletIDs)-1)]
+		children := func() []block { return c.blocks(content, bullets+1, ordered) }
+		if kind[0] != '-' {
+			id = orderedIDs[min(ordered, len(orderedIDs)-1)]
+			if ordered == 0 {
+				id = fmt.Sprintf("%d.", number)
+				number++
+			}
+			children = func() []block { return c.blocks(content, bullets, ordered+1) }
+		}
+		items = append(items, c.item(id, strings.Repeat("  ", bullets+ordered), children()))
+		// Blank lines between items.
+		for i < len(lines) && strings.TrimSpace(lines[i]) == "" && i+1 < len(lines) && MATCH_LIST_ITEM.MatchString(lines[i+1]) {
+			i++
+		}
+	}
+	return block{text: strings.Join(items, "\n"), list: true}, i
+}
+
+// listKind returns the kind of a matched list item: "-" followed by the bullet
+// character or "1" followed by the ordered list delimiter.
+func listKind(match []string) string {
+	if match[3] == "" {
+		return "-" + match[2]
+	}
+	return "1" + match[2][len(match[2])-1:]
+}
+
+// item returns a Rimu list item. The first paragraph is the item text, child
+// lists at the end of the item follow the item text and the remaining blocks
+// are attached in a division block.
+func (c *converter) item(id string, indent string, blocks []block) string {
+	text := ""
+	if len(blocks) > 0 && blocks[0].para {
+		text = blocks[0].text
+		blocks = blocks[1:]
+	}
+	lines := strings.Split(text, "\n")
+	for i := 1; i < len(lines); i++ {
+		if MATCH_RIMU_ITEM.MatchString(lines[i]) || strings.HasPrefix(lines[i], ".") {
+			lines[i] = `\` + lines[i]
+		}
+	}
+	result := indent + id + " " + strings.Join(lines, "\n"+indent+strings.Repeat(" ", len(id)+1))
+	children := len(blocks)
+	for children > 0 && blocks[children-1].list {
+		children--
+	}
+	if attached := blocks[:children]; len(attached) == 1 && !attached[0].para && !attached[0].list {
+		result += "\n" + attached[0].text
+	} else if len(attached) > 0 {
+		text := c.join(attached)
+		delimiter := delimiterFor("..", strings.Split(text, "\n"))
+		result += "\n" + delimiter + "\n" + text + "\n" + delimiter
+	}
+	for _, child := range blocks[children:] {
+		result += "\n" + child.text
+	}
+	return result
+}
+
+// footnote converts the footnote definition starting at lines[i] and returns
+// the block and the index of the next line.
+func (c *converter) footnote(lines []string, i int) (block, int) {
+	m := MATCH_FOOTNOTE.FindStringSubmatch(lines[i])
+	text := []string{m[2]}
+	for i++; i < len(lines) && strings.TrimSpace(lines[i]) != "" && !interrupts(lines[i]) && !MATCH_FOOTNOTE.MatchString(lines[i]); i++ {
+		text = append(text, strings.TrimSpace(lines[i]))
+	}
+	return block{text: "[^" + footnoteLabel(m[1]) + "]: " + c.inline(hardBreaks(text))}, i
+}
+
+// table converts the GFM table starting at lines[i] to an HTML block (with
+// span expansion enabled) and returns the block and the index of the next line.
+func (c *converter) table(lines []string, i int) (block, int) {
+	header := splitRow(lines[i])
+	var align []string
+	for _, cell := range splitRow(lines[i+1]) {
+		switch {
+		case strings.HasPrefix(cell, ":") && strings.HasSuffix(cell, ":"):
+			align = append(align, "center")
+		case strings.HasPrefix(cell, ":"):
+			align = append(align, "left")
+		case strings.HasSuffix(cell, ":"):
+			align = append(align, "right")
+		default:
+			align = append(align, "")
+		}
+	}
+	row := func(cells []string, tag string) string {
+		var b strings.Builder
+		b.WriteString("<tr>")
+		for j := range header {
+			b.WriteString("<" + tag)
+			if j < len(align) && align[j] != "" {
+				b.WriteString(` style="text-align:` + align[j] + `"`)
+			}
+			b.WriteString(">")
+			if j < len(cells) {
+				b.WriteString(c.inline(cells[j]))
+			}
+			b.WriteString("</" + tag + ">")
+		}
+		b.WriteString("</tr>")
+		return b.String()
+	}
+	result := []string{".+spans", "<table>", "<thead>", row(header, "th"), "</thead>"}
+	var body []string
+	for i += 2; i < len(lines) && strings.TrimSpace(lines[i]) != "" && !interrupts(lines[i]); i++ {
+		body = append(body, row(splitRow(lines[i]), "td"))
+	}
+	if len(body) > 0 {
+		result = append(result, "<tbody>")
+		result = append(result, body...)
+		result = append(result, "</tbody>")
+	}
+	return block{text: strings.Join(append(result, "</table>"), "\n")}, i
+}
+
+// splitRow returns the trimmed cells of a table row. Escaped pipe characters
+// are unescaped.
+func splitRow(line string) (cells []string) {
+	line = strings.TrimSpace(line)
+	line = strings.TrimPrefix(line, "|")
+	if strings.HasSuffix(line, "|") && !strings.HasSuffix(line, `\|`) {
+		line = line[:len(line)-1]
+	}
+	cell := ""
+	for i := 0; i < len(line); i++ {
+		switch {
+		case line[i] == '\\' && i+1 < len(line) && line[i+1] == '|':
+			cell += "|"
+			i++
+		case line[i] == '|':
+			cells = append(cells, strings.TrimSpace(cell))
+			cell = ""
+		default:
+			cell += line[i : i+1]
+		}
+	}
+	return append(cells, strings.TrimSpace(cell))
+}
+
+// hardBreaks returns paragraph lines joined with Markdown hard line breaks
+// (trailing double spaces) converted to backslash hard line breaks.
+func hardBreaks(lines []string) string {
+	for i := 0; i < len(lines)-1; i++ {
+		if strings.HasSuffix(lines[i], "  ") {
+			lines[i] = strings.TrimRight(lines[i], " ") + `\`
+		}
+	}
+	if len(lines) > 0 {
+		lines[len(lines)-1] = strings.TrimRight(lines[len(lines)-1], " ")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// escapeBlock returns the converted text of a paragraph with its first
+// character replaced by a character reference if the first line would be
+// parsed as a Rimu block element. A backslash escape is not used because it
+// is dropped by the first matching Rimu block definition and the unescaped line
+// can match another. source is the paragraph's Markdown inline text.
+func (c *converter) escapeBlock(text string, source string) string {
+	if !isRimuBlock(text) {
+		return text
+	}
+	if len(source) > 1 && source[0] == '\\' && strings.IndexByte(MARKDOWN_PUNCTUATION, source[1]) >= 0 {
+		source = source[1:] // Backslash escaped character.
+	}
+	r, n := utf8.DecodeRuneInString(source)
+	if escaped := fmt.Sprintf("&#%d;", r) + c.inline(source[n:]); !isRimuBlock(escaped) {
+		return escaped
+	}
+	// Character references do not escape Rimu list items such as "a:: b".
+	return `\` + text
+}
+
+// isRimuBlock returns true if the first line of text would be parsed as a Rimu
+// block element. A leading backslash is ignored because Rimu block definitions
+// drop it.
+func isRimuBlock(text string) bool {
+	line := strings.TrimPrefix(strings.SplitN(text, "\n", 2)[0], `\`)
+	for _, re := range MATCH_RIMU_BLOCK {
+		if re.MatchString(line) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeLabel returns a link reference label normalized for matching.
+func normalizeLabel(label string) string {
+	return strings.ToLower(strings.Join(strings.Fields(label), " "))
+}
+
+// footnoteLabel returns a footnote label with characters that are not valid
+// in Rimu footnote labels replaced by hyphens.
+func footnoteLabel(label string) string {
+	return regexp.MustCompile(`[^\w\-]`).ReplaceAllString(label, "-")
+}
+
+// indentOf returns the number of leading spaces in line.
+func indentOf(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " "))
+}
+
+// expandTabs returns line with tabs in the leading whitespace expanded to
+// four column tab stops.
+func expandTabs(line string) string {
+	indent := ""
+	for i, c := range line {
+		switch c {
+		case ' ':
+			indent += " "
+		case '\t':
+			indent += strings.Repeat(" ", 4-len(indent)%4)
+		default:
+			return indent + line[i:]
+		}
+	}
+	return indent
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,51 @@
+package markdown
+
+import (
+	"testing"
+
+	"github.com/srackham/go-rimu/v11/internal/assert"
+)
+
+func TestToRimu(t *testing.T) {
+	tests := []struct {
+		markdown string
+		want     string
+	}{
+		{"", ""},
+		{"Hello *World*", "Hello *World*\n"},
+		{"# One\n## Two ##\nThree\n=====\n\nFour\n----", "# One\n\n## Two\n\n# Three\n\n## Four\n"},
+		{"## C #", "## C\n"},
+		{"Setext *x* #\n---", "## Setext *x* &#35;\n"},
+		{"```go\nx := 1\n```", "``go\nx := 1\n``\n"},
+		{"~~~ c++ extra\n``\n~~~", "```\n``\n```\n"},
+		{"    code\n\n    more", "``\ncode\n\nmore\n``\n"},
+		{"> a\nb", ">a\n>b\n"},
+		{"> \\> a\n> \\> b", ">&#62; a\n>> b\n"},
+		{"> # H\n>\n> P", "\"\"\n# H\n\nP\n\"\"\n"},
+		{"* a\n* b\n  - c\n\n    d\n* [x] e", "- a\n- b\n  * c\n..\nd\n..\n- [x] e\n"},
+		{"1. a\n\n   ```\n   x\n   ```\n1. b\n   1. c", "1. a\n``\nx\n``\n2. b\n  .. c\n"},
+		{"- a\n\n1. b", "- a\n\n\n1. b\n"},
+		{"- a\n\n      code\n\n  para", "- a\n..\n``\ncode\n``\n\npara\n..\n"},
+		{"- a\n\n      code", "- a\n``\ncode\n``\n"},
+		{"[a](http://x.org \"T\") [b][r] [R] [c][] <http://y.org>\n\n[r]: http://r.org\n[c]: <http://c.org/a b>",
+			"<a href=\"http://x.org\" title=\"T\">a</a> <http://r.org|b> <http://r.org|R> <http://c.org/a%20b|c> <http://y.org>\n"},
+		{"[a](u 'say \"hi\"') [b](u (x &amp; y)) ![i](p.png \"{T}\") [c][r] [](v \"V\")\n\n[r]: w \"R\"",
+			"<a href=\"u\" title='say \"hi\"'>a</a> <u|b> <img src=\"p.png\" alt=\"i\" title=\"\\{T}\"> <a href=\"w\" title=\"R\">c</a> <a href=\"v\" title=\"V\">v</a>\n"},
+		{"[http://x.org](http://x.org) [](u) [*a* >](u)", "<http://x.org> <u> <a href=\"u\">*a* ></a>\n"},
+		{"![alt *x*](p.png) ![](q.png) [not a link]", "<image:p.png|alt x> <image:q.png> [not a link]\n"},
+		{"![Logo](logo.png)", "<image:logo.png|Logo>\n"},
+		{"`a` `` b`c `` ` `", "`a` <code>b`c</code> <code> </code>\n"},
+		{"\\*a\\* \\[x\\](y) \\# \\\\ {m} `{m}`", "\\*a\\* \\[x](y) # \\ \\{m} `\\{m}`\n"},
+		{"a  \nb\\\nc  ", "a \\\nb \\\nc\n"},
+		{"x<b>y</b> <!-- c -->", "x<b>y</b> <!-- c -->\n"},
+		{"Note[^1].\n\n[^1]: The *note*.", "Note[^1].\n\n[^1]: The *note*.\n"},
+		{"| a | b |\n|:-|-:|\n| *x* | y \\| z |", ".+spans\n<table>\n<thead>\n<tr><th style=\"text-align:left\">a</th><th style=\"text-align:right\">b</th></tr>\n</thead>\n<tbody>\n<tr><td style=\"text-align:left\">*x*</td><td style=\"text-align:right\">y | z</td></tr>\n</tbody>\n</table>\n"},
+		{"***\n<div>\nx\n</div>", "<hr>\n\n<div>\nx\n</div>\n"},
+		{"// x\n\n.lead\n\nterm:: def\n\n|x|", "&#47;/ x\n\n&#46;lead\n\n\\term:: def\n\n&#124;x|\n"},
+		{"{x} = 'y'\n\n\\> x", "&#123;x} = 'y'\n\n&#62; x\n"},
+		{"See <<#x>> and <a|b>\n\n<<#x>>", "See \\<<#x>> and \\<a|b>\n\n&#60;\\<#x>>\n"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, ToRimu(tt.markdown))
+	}
+}
@@ -2,18 +2,14 @@ package options
 
 import (
 	"fmt"
+	"io/fs"
 	"strconv"
+	"strings"
+	"unicode/utf8"
 
 	"github.com/srackham/go-rimu/v11/internal/utils/str"
 )
 
-// document package dependency injection.
-var ApiInit func()
-
-func init() {
-	Init()
-}
-
 // RenderOptions sole use is for passing options into the public API.
 // Fields can be nil so that options can be selectively updated (if field is unspecified then do not update).
 type RenderOptions struct {
@@ -21,102 +17,233 @@ type RenderOptions struct {
 	HtmlReplacement interface{} // nil or string
 	Reset           interface{} // nil or bool
 	Callback        CallbackFunction
+	Macros          map[string]string // Macro values set before rendering (after Reset).
+	FS              fs.FS             // nil or file system used to resolve .include paths.
+	Path            interface{}       // nil or string (the document's FS path).
+	FrontMatter     interface{}       // nil or bool
+	Typography      interface{}       // nil or bool
 }
 
 type CallbackMessage struct {
-	Kind string
-	Text string
+	Kind   string // Severity: "error", "warning" or "info".
+	Code   string // Stable diagnostic code e.g. "undefined-macro".
+	Text   string
+	Line   int // Source line number (1-based), zero if unknown.
+	Column int // Source column number (1-based), zero if unknown.
+}
+
+// Diagnostic severities (CallbackMessage Kind values).
+const (
+	Error   = "error"   // The output is probably not what was intended.
+	Warning = "warning" // The output is valid but the source should be changed.
+	Info    = "info"    // Informational, no action is required.
+)
+
+// Diagnostic codes (CallbackMessage Code values).
+// Codes are stable: they are never changed or reused.
+const (
+	DeprecatedSyntax          = "deprecated-syntax"
+	DuplicateID               = "duplicate-id"
+	IllegalApiOption          = "illegal-api-option"
+	IllegalBlockDefinition    = "illegal-block-definition"
+	IllegalBlockOption        = "illegal-block-option"
+	IllegalInclude            = "illegal-include"
+	IllegalMacroDefinition    = "illegal-macro-definition"
+	IllegalMacroSyntax        = "illegal-macro-syntax"
+	IllegalRegExp             = "illegal-regexp"
+	InvalidUTF8               = "invalid-utf8"
+	UndefinedFootnote         = "undefined-footnote"
+	UndefinedMacro            = "undefined-macro"
+	UndefinedReplacementGroup = "undefined-replacement-group"
+	UnsupportedMarkdown       = "unsupported-markdown"
+	UnterminatedBlock         = "unterminated-block"
+	UnusedFootnote            = "unused-footnote"
+)
+
+// Severities maps diagnostic codes to their severity.
+var Severities = map[string]string{
+	DeprecatedSyntax:          Warning,
+	DuplicateID:               Error,
+	IllegalApiOption:          Error,
+	IllegalBlockDefinition:    Error,
+	IllegalBlockOption:        Error,
+	IllegalInclude:            Error,
+	IllegalMacroDefinition:    Error,
+	IllegalMacroSyntax:        Error,
+	IllegalRegExp:             Error,
+	InvalidUTF8:               Error,
+	UndefinedFootnote:         Error,
+	UndefinedMacro:            Error,
+	UndefinedReplacementGroup: Error,
+	UnsupportedMarkdown:       Warning,
+	UnterminatedBlock:         Error,
+	UnusedFootnote:            Warning,
 }
 
 // CallbackFunction is the API callback function type.
 type CallbackFunction func(message CallbackMessage)
 
-// Global option values.
-var safeMode int
-var htmlReplacement string
-var callback CallbackFunction
+// Source is the source text of the element that is being rendered. It is
+// used to locate callback messages.
+type Source struct {
+	Lines   []string
+	LineNos []int // Source line numbers of Lines (1-based).
+}
+
+// Edit is a source text edit that replaces deprecated syntax with its modern
+// equivalent. If Old is blank then New is inserted as a line before Line.
+// Format edits replace (or delete) the whole source line Old.
+type Edit struct {
+	Line   int    // Source line number (1-based), zero for the located line.
+	Old    string // Deprecated source text.
+	New    string // Replacement text (can contain multiple lines).
+	Delete bool   // Delete the line (format edits only).
+}
+
+// Options contains the option values of a single document.
+type Options struct {
+	safeMode        int
+	htmlReplacement string
+	callback        CallbackFunction
+	fsys            fs.FS
+	path            string
+	frontMatter     bool
+	typography      bool
+	source          Source
+	located         map[string]int // Number of times each near text has been located in the current source.
+	edits           []Edit         // Collected deprecated syntax edits.
+	collectEdits    bool
+	ApiInit         func() // document package dependency injection.
+}
 
 // Init resets options to default values.
-func Init() {
-	safeMode = 0
-	htmlReplacement = "<mark>replaced HTML</mark>"
-	callback = nil
+func (o *Options) Init() {
+	o.safeMode = 0
+	o.htmlReplacement = "<mark>replaced HTML</mark>"
+	o.callback = nil
+	o.fsys = nil
+	o.path = ""
+	o.frontMatter = false
+	o.typography = false
 }
 
 // Return true if safeMode is non-zero.
-func IsSafeModeNz() bool {
-	return safeMode != 0
+func (o *Options) IsSafeModeNz() bool {
+	return o.safeMode != 0
 }
 
 // Return true if Macro Definitions are ignored.
-func SkipMacroDefs() bool {
-	return safeMode != 0 && safeMode&0x8 == 0
+func (o *Options) SkipMacroDefs() bool {
+	return o.safeMode != 0 && o.safeMode&0x8 == 0
 }
 
 // Return true if Block Attribute elements are ignored.
-func SkipBlockAttributes() bool {
-	return safeMode&0x4 != 0
+func (o *Options) SkipBlockAttributes() bool {
+	return o.safeMode&0x4 != 0
 }
 
 // UpdateOptions processes non-nil opts fields.
 // Error callback option values are illegal.
-func UpdateOptions(opts RenderOptions) {
+func (o *Options) UpdateOptions(opts RenderOptions) {
 	// Install callback first to ensure option errors are logged.
 	if opts.Callback != nil {
-		callback = opts.Callback
+		o.callback = opts.Callback
 	}
 	// Reset takes priority.
 	if opts.Reset != nil {
-		SetOption("reset", fmt.Sprintf("%v", opts.Reset))
+		o.SetOption("reset", fmt.Sprintf("%v", opts.Reset))
 	}
 	// Install callback again in case it has been reset.
 	if opts.Callback != nil {
-		callback = opts.Callback
+		o.callback = opts.Callback
 	}
 	if opts.SafeMode != nil {
-		SetOption("safeMode", fmt.Sprintf("%v", opts.SafeMode))
+		o.SetOption("safeMode", fmt.Sprintf("%v", opts.SafeMode))
 	}
 	if opts.HtmlReplacement != nil {
-		SetOption("htmlReplacement", fmt.Sprintf("%v", opts.HtmlReplacement))
+		o.SetOption("htmlReplacement", fmt.Sprintf("%v", opts.HtmlReplacement))
+	}
+	if opts.FS != nil {
+		o.fsys = opts.FS
+	}
+	if opts.Path != nil {
+		o.path = fmt.Sprintf("%v", opts.Path)
 	}
+	if opts.FrontMatter != nil {
+		value := fmt.Sprintf("%v", opts.FrontMatter)
+		if b, err := strconv.ParseBool(value); err != nil {
+			o.ErrorCallback(IllegalApiOption, "illegal frontMatter API option value: "+value)
+		} else {
+			o.frontMatter = b
+		}
+	}
+	if opts.Typography != nil {
+		value := fmt.Sprintf("%v", opts.Typography)
+		if b, err := strconv.ParseBool(value); err != nil {
+			o.ErrorCallback(IllegalApiOption, "illegal typography API option value: "+value)
+		} else {
+			o.typography = b
+		}
+	}
+}
+
+// IsFrontMatter returns true if document front matter is processed.
+func (o *Options) IsFrontMatter() bool {
+	return o.frontMatter
+}
+
+// IsTypography returns true if typographic replacements are enabled by
+// default.
+func (o *Options) IsTypography() bool {
+	return o.typography
+}
+
+// FS returns the file system used to resolve included files (nil if not set).
+func (o *Options) FS() fs.FS {
+	return o.fsys
+}
+
+// Path returns the FS path of the document that is being rendered.
+func (o *Options) Path() string {
+	return o.path
 }
 
 // SetOption parses a named API option value.
 // Error callback if option values are illegal.
-func SetOption(name string, value string) {
+func (o *Options) SetOption(name string, value string) {
 	switch name {
 	case "safeMode":
 		n, err := strconv.ParseInt(value, 10, 64)
 		if err != nil || n < 0 || n > 15 {
-			ErrorCallback("illegal safeMode API option value: " + value)
+			o.ErrorCallback(IllegalApiOption, "illegal safeMode API option value: "+value)
 		} else {
-			safeMode = int(n)
+			o.safeMode = int(n)
 		}
 	case "htmlReplacement":
-		htmlReplacement = value
+		o.htmlReplacement = value
 	case "reset":
 		b, err := strconv.ParseBool(value)
 		if err != nil {
-			ErrorCallback("illegal reset API option value: " + value)
+			o.ErrorCallback(IllegalApiOption, "illegal reset API option value: "+value)
 		} else {
 			if b {
-				ApiInit()
+				o.ApiInit()
 			}
 		}
 	default:
-		ErrorCallback("illegal API option name: " + name)
+		o.ErrorCallback(IllegalApiOption, "illegal API option name: "+name)
 	}
 }
 
 // HtmlSafeModeFilter filters HTML based on current safeMode.
-func HtmlSafeModeFilter(html string) string {
-	switch safeMode & 0x3 {
+func (o *Options) HtmlSafeModeFilter(html string) string {
+	switch o.safeMode & 0x3 {
 	case 0: // Raw HTML (default behavior).
 		return html
 	case 1: // Drop HTML.
 		return ""
 	case 2: // Replace HTML with 'htmlReplacement' option string.
-		return htmlReplacement
+		return o.htmlReplacement
 	case 3: // Render HTML as text.
 		return str.ReplaceSpecialChars(html)
 	default:
@@ -124,8 +251,130 @@ func HtmlSafeModeFilter(html string) string {
 	}
 }
 
-func ErrorCallback(message string) {
-	if callback != nil {
-		callback(CallbackMessage{Kind: "error", Text: message})
+// SetSource sets the source text of the element that is being rendered and
+// returns the previous source text.
+func (o *Options) SetSource(source Source) (prev Source) {
+	prev = o.source
+	o.source = source
+	o.located = nil
+	return
+}
+
+// Source returns the source text of the element that is being rendered.
+func (o *Options) Source() Source {
+	return o.source
+}
+
+// LineNo returns the source line number of the start of the current source
+// (zero if it is unknown).
+func (o *Options) LineNo() int {
+	if len(o.source.LineNos) == 0 {
+		return 0
+	}
+	return o.source.LineNos[0]
+}
+
+// ErrorCallback reports a diagnostic located at the start of the current source.
+// The diagnostic severity is determined by the diagnostic code.
+func (o *Options) ErrorCallback(code string, message string) {
+	o.ErrorCallbackNear(code, message, "")
+}
+
+// ErrorCallbackNear reports a diagnostic located at the first occurrence of
+// the near text in the current source. If near is blank or is not found then
+// the diagnostic is located at the start of the current source.
+func (o *Options) ErrorCallbackNear(code string, message string, near string) {
+	o.report(code, message, near)
+}
+
+// ErrorCallbackAt reports a diagnostic located at a previously located source
+// line and column (see Locate).
+func (o *Options) ErrorCallbackAt(code string, message string, line int, col int) {
+	if o.callback != nil {
+		o.callback(CallbackMessage{Kind: Severities[code], Code: code, Text: message, Line: line, Column: col})
+	}
+}
+
+// Locate returns the source line and column numbers of the first occurrence of
+// near in the current source (the start of the current source if near is blank
+// or is not found) e.g. for diagnostics that are reported once the document
+// has been parsed.
+func (o *Options) Locate(near string) (line int, col int) {
+	return o.locate(near)
+}
+
+// DeprecationWarning reports deprecated syntax located at the first occurrence
+// of the near text in the current source. If edits are being collected the
+// edits that migrate the deprecated syntax are saved.
+func (o *Options) DeprecationWarning(message string, near string, edits ...Edit) {
+	line := o.report(DeprecatedSyntax, message, near)
+	if !o.collectEdits || line == 0 {
+		return
+	}
+	for _, edit := range edits {
+		if edit.Line == 0 {
+			edit.Line = line
+		}
+		o.edits = append(o.edits, edit)
+	}
+}
+
+// report calls the callback with a diagnostic and returns its line number.
+func (o *Options) report(code string, message string, near string) (line int) {
+	line, col := o.locate(near)
+	o.ErrorCallbackAt(code, message, line, col)
+	return
+}
+
+// CollectEdits enables deprecated syntax edit collection and returns the
+// edits collected since the previous call.
+func (o *Options) CollectEdits(enable bool) (edits []Edit) {
+	edits = o.edits
+	o.edits = nil
+	o.collectEdits = enable
+	return
+}
+
+// locate returns the source line and column numbers of the first line of text.
+// Successive calls with the same text locate successive occurrences of the
+// text in the current source.
+func (o *Options) locate(text string) (line int, col int) {
+	if len(o.source.LineNos) == 0 {
+		return 0, 0
+	}
+	line, col = o.source.LineNos[0], 1
+	text = strings.SplitN(text, "\n", 2)[0]
+	if text == "" {
+		return
+	}
+	if o.located == nil {
+		o.located = map[string]int{}
+	}
+	n := o.located[text] // Number of previously located occurrences.
+	o.located[text]++
+	first := true
+	for i, s := range o.source.Lines {
+		if i >= len(o.source.LineNos) {
+			break
+		}
+		offset := 0
+		for {
+			j := strings.Index(s[offset:], text)
+			if j < 0 {
+				break
+			}
+			j += offset
+			if first {
+				// Default to the first occurrence.
+				line, col = o.source.LineNos[i], utf8.RuneCountInString(s[:j])+1
+				first = false
+			}
+			if n == 0 {
+				return o.source.LineNos[i], utf8.RuneCountInString(s[:j]) + 1
+			}
+			n--
+			offset = j + len(text)
+		}
 	}
+	return
 }
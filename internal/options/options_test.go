@@ -7,69 +7,113 @@ import (
 )
 
 func TestInit(t *testing.T) {
-	Init()
-	assert.Equal(t, 0, safeMode)
-	assert.Equal(t, "<mark>replaced HTML</mark>", htmlReplacement)
-	assert.True(t, callback == nil)
+	o := &Options{}
+	o.Init()
+	assert.Equal(t, 0, o.safeMode)
+	assert.Equal(t, "<mark>replaced HTML</mark>", o.htmlReplacement)
+	assert.True(t, o.callback == nil)
 
 }
 
 func TestIsSafeModeNz(t *testing.T) {
-	Init()
-	assert.False(t, IsSafeModeNz())
-	safeMode = 1
-	assert.True(t, IsSafeModeNz())
+	o := &Options{}
+	o.Init()
+	assert.False(t, o.IsSafeModeNz())
+	o.safeMode = 1
+	assert.True(t, o.IsSafeModeNz())
 }
 
 func TestSkipMacroDefs(t *testing.T) {
-	Init()
-	assert.False(t, SkipMacroDefs())
-	safeMode = 1
-	assert.True(t, SkipMacroDefs())
-	safeMode = 1 + 8
-	assert.False(t, SkipMacroDefs())
+	o := &Options{}
+	o.Init()
+	assert.False(t, o.SkipMacroDefs())
+	o.safeMode = 1
+	assert.True(t, o.SkipMacroDefs())
+	o.safeMode = 1 + 8
+	assert.False(t, o.SkipMacroDefs())
 }
 
 func TestSkipBlockAttributes(t *testing.T) {
-	Init()
-	assert.False(t, SkipBlockAttributes())
-	safeMode = 1
-	assert.False(t, SkipBlockAttributes())
-	safeMode = 1 + 4
-	assert.True(t, SkipBlockAttributes())
+	o := &Options{}
+	o.Init()
+	assert.False(t, o.SkipBlockAttributes())
+	o.safeMode = 1
+	assert.False(t, o.SkipBlockAttributes())
+	o.safeMode = 1 + 4
+	assert.True(t, o.SkipBlockAttributes())
 }
 
 func TestUpdateOptions(t *testing.T) {
-	Init()
-	UpdateOptions(RenderOptions{SafeMode: 1})
-	assert.Equal(t, 1, safeMode)
-	assert.Equal(t, "<mark>replaced HTML</mark>", htmlReplacement)
-	UpdateOptions(RenderOptions{HtmlReplacement: "foo"})
-	assert.Equal(t, 1, safeMode)
-	assert.Equal(t, "foo", htmlReplacement)
+	o := &Options{}
+	o.Init()
+	o.UpdateOptions(RenderOptions{SafeMode: 1})
+	assert.Equal(t, 1, o.safeMode)
+	assert.Equal(t, "<mark>replaced HTML</mark>", o.htmlReplacement)
+	o.UpdateOptions(RenderOptions{HtmlReplacement: "foo"})
+	assert.Equal(t, 1, o.safeMode)
+	assert.Equal(t, "foo", o.htmlReplacement)
 }
 
 func TestSetOption(t *testing.T) {
-	Init()
+	o := &Options{}
+	o.Init()
 	// Illegal values do not update options.
-	SetOption("safeMode", "qux")
-	assert.Equal(t, 0, safeMode)
-	SetOption("safeMode", "42")
-	assert.Equal(t, 0, safeMode)
-	SetOption("safeMode", "1")
-	SetOption("reset", "qux")
-	assert.Equal(t, 1, safeMode)
+	o.SetOption("safeMode", "qux")
+	assert.Equal(t, 0, o.safeMode)
+	o.SetOption("safeMode", "42")
+	assert.Equal(t, 0, o.safeMode)
+	o.SetOption("safeMode", "1")
+	o.SetOption("reset", "qux")
+	assert.Equal(t, 1, o.safeMode)
 }
 
 func TestHtmlSafeModeFilter(t *testing.T) {
-	Init()
-	assert.Equal(t, "foo", HtmlSafeModeFilter("foo"))
-	safeMode = 1
-	assert.Equal(t, "", HtmlSafeModeFilter("foo"))
-	safeMode = 2
-	assert.Equal(t, "<mark>replaced HTML</mark>", HtmlSafeModeFilter("foo"))
-	safeMode = 3
-	assert.Equal(t, "&lt;br&gt;", HtmlSafeModeFilter("<br>"))
-	safeMode = 0 + 4
-	assert.Equal(t, "foo", HtmlSafeModeFilter("foo"))
+	o := &Options{}
+	o.Init()
+	assert.Equal(t, "foo", o.HtmlSafeModeFilter("foo"))
+	o.safeMode = 1
+	assert.Equal(t, "", o.HtmlSafeModeFilter("foo"))
+	o.safeMode = 2
+	assert.Equal(t, "<mark>replaced HTML</mark>", o.HtmlSafeModeFilter("foo"))
+	o.safeMode = 3
+	assert.Equal(t, "&lt;br&gt;", o.HtmlSafeModeFilter("<br>"))
+	o.safeMode = 0 + 4
+	assert.Equal(t, "foo", o.HtmlSafeModeFilter("foo"))
+}
+
+func TestErrorCallback(t *testing.T) {
+	o := &Options{}
+	o.Init()
+	var got CallbackMessage
+	o.callback = func(message CallbackMessage) { got = message }
+	o.ErrorCallback(UndefinedMacro, "foo")
+	assert.Equal(t, CallbackMessage{Kind: "error", Code: "undefined-macro", Text: "foo"}, got)
+	o.SetSource(Source{Lines: []string{"one", "two ½ {x}"}, LineNos: []int{5, 7}})
+	o.ErrorCallback(UndefinedMacro, "foo")
+	assert.Equal(t, CallbackMessage{Kind: "error", Code: "undefined-macro", Text: "foo", Line: 5, Column: 1}, got)
+	o.ErrorCallbackNear(UndefinedMacro, "foo", "{x}")
+	assert.Equal(t, CallbackMessage{Kind: "error", Code: "undefined-macro", Text: "foo", Line: 7, Column: 7}, got)
+	o.ErrorCallbackNear(UndefinedMacro, "foo", "{y}")
+	assert.Equal(t, CallbackMessage{Kind: "error", Code: "undefined-macro", Text: "foo", Line: 5, Column: 1}, got)
+	// Successive occurrences.
+	o.SetSource(Source{Lines: []string{"{x} {x}", "{x}"}, LineNos: []int{1, 2}})
+	for _, col := range []int{1, 5} {
+		o.ErrorCallbackNear(UndefinedMacro, "foo", "{x}")
+		assert.Equal(t, CallbackMessage{Kind: "error", Code: "undefined-macro", Text: "foo", Line: 1, Column: col}, got)
+	}
+	o.ErrorCallbackNear(UndefinedMacro, "foo", "{x}")
+	assert.Equal(t, 2, got.Line)
+	o.ErrorCallbackNear(UndefinedMacro, "foo", "{x}")
+	assert.Equal(t, 1, got.Line)
+	assert.Equal(t, 1, got.Column)
+	// Deprecation edits.
+	o.CollectEdits(true)
+	o.DeprecationWarning("bar", "{x}", Edit{Old: "{x}", New: "{y}"}, Edit{Line: 1, New: "{y}='1'"})
+	assert.Equal(t, CallbackMessage{Kind: "warning", Code: "deprecated-syntax", Text: "bar", Line: 1, Column: 1}, got)
+	assert.EqualValues(t, []Edit{{Line: 1, Old: "{x}", New: "{y}"}, {Line: 1, New: "{y}='1'"}}, o.CollectEdits(false))
+	// Every diagnostic code has a severity.
+	for code, severity := range Severities {
+		assert.True(t, code != "")
+		assert.True(t, severity == Error || severity == Warning || severity == Info)
+	}
 }
@@ -5,10 +5,6 @@ import (
 	"strings"
 )
 
-func init() {
-	Init()
-}
-
 type Definition struct {
 	Quote    string // Single quote character.
 	OpenTag  string
@@ -17,7 +13,10 @@ type Definition struct {
 	re       *regexp.Regexp
 }
 
-var defs []Definition // Mutable definitions initialized by DEFAULT_DEFS.
+// Quotes contains the quote definitions of a single document.
+type Quotes struct {
+	defs []Definition // Mutable definitions initialized by DEFAULT_DEFS.
+}
 
 var DEFAULT_DEFS = []Definition{
 	{
@@ -65,28 +64,28 @@ var DEFAULT_DEFS = []Definition{
 }
 
 // Reset definitions to defaults.
-func Init() {
-	defs = make([]Definition, len(DEFAULT_DEFS))
+func (q *Quotes) Init() {
+	q.defs = make([]Definition, len(DEFAULT_DEFS))
 	for i, def := range DEFAULT_DEFS {
-		defs[i] = def
+		q.defs[i] = def
 	}
-	initRegExps()
+	q.initRegExps()
 }
 
 // Synthesise re's to find quotes.
-func initRegExps() {
+func (q *Quotes) initRegExps() {
 	// $1 is quote character(s), $2 is quoted text.
 	// Quoted text cannot begin or end with whitespace.
 	// Quoted can span multiple lines.
 	// Quoted text cannot end with a backslash.
-	for i, def := range defs {
-		defs[i].re = regexp.MustCompile(`\\?(` + regexp.QuoteMeta(def.Quote) + `)([^\s\\]|\S[\s\S]*?[^\s\\])` + regexp.QuoteMeta(def.Quote))
+	for i, def := range q.defs {
+		q.defs[i].re = regexp.MustCompile(`\\?(` + regexp.QuoteMeta(def.Quote) + `)([^\s\\]|\S[\s\S]*?[^\s\\])` + regexp.QuoteMeta(def.Quote))
 	}
 }
 
 // Return the quote definition corresponding to 'quote', return nil if not found.
-func GetDefinition(quote string) *Definition {
-	for _, def := range defs {
+func (q *Quotes) GetDefinition(quote string) *Definition {
+	for _, def := range q.defs {
 		if def.Quote == quote {
 			return &def
 		}
@@ -95,29 +94,29 @@ func GetDefinition(quote string) *Definition {
 }
 
 // Update existing or add new quote definition.
-func SetDefinition(def Definition) {
-	for i := range defs {
-		if defs[i].Quote == def.Quote {
+func (q *Quotes) SetDefinition(def Definition) {
+	for i := range q.defs {
+		if q.defs[i].Quote == def.Quote {
 			// Update existing definition.
-			defs[i].OpenTag = def.OpenTag
-			defs[i].CloseTag = def.CloseTag
-			defs[i].Spans = def.Spans
+			q.defs[i].OpenTag = def.OpenTag
+			q.defs[i].CloseTag = def.CloseTag
+			q.defs[i].Spans = def.Spans
 			return
 		}
 	}
 	// Double-quote definitions are prepended to the array so they are matched
 	// before single-quote definitions (which are appended to the array).
 	if len(def.Quote) == 2 {
-		defs = append([]Definition{def}, defs...)
+		q.defs = append([]Definition{def}, q.defs...)
 	} else {
-		defs = append(defs, def)
+		q.defs = append(q.defs, def)
 	}
-	initRegExps()
+	q.initRegExps()
 }
 
 // Strip backslashes from quote characters.
-func Unescape(s string) string {
-	for _, def := range defs {
+func (q *Quotes) Unescape(s string) string {
+	for _, def := range q.defs {
 		s = strings.Replace(s, "\\"+def.Quote, def.Quote, -1)
 	}
 	return s
@@ -130,9 +129,9 @@ func Unescape(s string) string {
 // - The left quote    s[loc[2]:loc[3]]
 // - The quoted text   s[loc[4]:loc[5]]
 // Returns nil if not found.
-func Find(text string) []int {
+func (q *Quotes) Find(text string) []int {
 	var match []int
-	for _, def := range defs {
+	for _, def := range q.defs {
 		allMatch := def.re.FindAllStringSubmatchIndex(text, -1)
 		if allMatch == nil {
 			continue
@@ -7,55 +7,59 @@ import (
 )
 
 func TestInit(t *testing.T) {
-	Init()
-	assert.Equal(t, len(DEFAULT_DEFS), len(defs))
-	assert.NotEqual(t, &DEFAULT_DEFS, &defs)
+	q := &Quotes{}
+	q.Init()
+	assert.Equal(t, len(DEFAULT_DEFS), len(q.defs))
+	assert.NotEqual(t, &DEFAULT_DEFS, &q.defs)
 }
 
 func TestGetDefinition(t *testing.T) {
-	Init()
-	assert.True(t, GetDefinition("*") != nil)
+	q := &Quotes{}
+	q.Init()
+	assert.True(t, q.GetDefinition("*") != nil)
 }
 
 func TestSetDefinition(t *testing.T) {
-	Init()
+	q := &Quotes{}
+	q.Init()
 
-	SetDefinition(Definition{
+	q.SetDefinition(Definition{
 		Quote:    "*",
 		OpenTag:  "<strong>",
 		CloseTag: "</strong>",
 		Spans:    true,
 	})
-	assert.Equal(t, len(DEFAULT_DEFS), len(defs))
-	def := GetDefinition("*")
+	assert.Equal(t, len(DEFAULT_DEFS), len(q.defs))
+	def := q.GetDefinition("*")
 	assert.Equal(t, "<strong>", def.OpenTag)
 
-	SetDefinition(Definition{
+	q.SetDefinition(Definition{
 		Quote:    "x",
 		OpenTag:  "<del>",
 		CloseTag: "</del>",
 		Spans:    true,
 	})
-	assert.Equal(t, len(DEFAULT_DEFS)+1, len(defs))
-	def = GetDefinition("x")
+	assert.Equal(t, len(DEFAULT_DEFS)+1, len(q.defs))
+	def = q.GetDefinition("x")
 	assert.Equal(t, "<del>", def.OpenTag)
-	assert.Equal(t, "<del>", defs[len(defs)-1].OpenTag)
+	assert.Equal(t, "<del>", q.defs[len(q.defs)-1].OpenTag)
 
-	SetDefinition(Definition{
+	q.SetDefinition(Definition{
 		Quote:    "xx",
 		OpenTag:  "<u>",
 		CloseTag: "</u>",
 		Spans:    true,
 	})
-	assert.Equal(t, len(DEFAULT_DEFS)+2, len(defs))
-	def = GetDefinition("xx")
+	assert.Equal(t, len(DEFAULT_DEFS)+2, len(q.defs))
+	def = q.GetDefinition("xx")
 	assert.Equal(t, "<u>", def.OpenTag)
-	assert.Equal(t, "<u>", defs[0].OpenTag)
+	assert.Equal(t, "<u>", q.defs[0].OpenTag)
 }
 
 func TestUnescape(t *testing.T) {
-	Init()
-	assert.Equal(t, `* ~~ \x`, Unescape(`\* \~~ \x`))
+	q := &Quotes{}
+	q.Init()
+	assert.Equal(t, `* ~~ \x`, q.Unescape(`\* \~~ \x`))
 }
 
 func TestFind(t *testing.T) {
@@ -70,7 +74,9 @@ func TestFind(t *testing.T) {
 		{`*bar* _foo_`, []int{0, 5, 0, 1, 1, 4}},
 		{`_bar_ *foo*`, []int{0, 5, 0, 1, 1, 4}},
 	}
+	q := &Quotes{}
+	q.Init()
 	for _, tt := range tests {
-		assert.EqualValues(t, tt.want, Find(tt.text))
+		assert.EqualValues(t, tt.want, q.Find(tt.text))
 	}
 }
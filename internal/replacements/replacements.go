@@ -1,23 +1,25 @@
 package replacements
 
 import (
+	"fmt"
 	"regexp"
 	"strings"
 
 	"github.com/srackham/go-rimu/v11/internal/options"
 )
 
-func init() {
-	Init()
-}
-
 type Definition struct {
 	Match       *regexp.Regexp
 	Replacement string
-	Filter      func(match []string) string
+	Filter      func(match []string, apiOptions *options.Options) string
 }
 
-var Defs []Definition // Mutable definitions initialized by DEFAULT_DEFS.
+// Replacements contains the replacement definitions of a single document.
+type Replacements struct {
+	Defs    []Definition // Mutable definitions initialized by DEFAULT_DEFS.
+	Options *options.Options
+	custom  []Definition // Registered definitions, they persist across Init calls.
+}
 
 var DEFAULT_DEFS = []Definition{
 	// Begin match with \\? to allow the replacement to be escaped.
@@ -91,8 +93,8 @@ var DEFAULT_DEFS = []Definition{
 	{
 		Match:       regexp.MustCompile(`(?i)\\?(<!--(?:[^<>&]*)?-->|<\/?([a-z][a-z0-9]*)(?:\s+[^<>&]+)?>)`),
 		Replacement: "",
-		Filter: func(match []string) string {
-			return options.HtmlSafeModeFilter(match[1]) // Matched HTML comment or inline tag.
+		Filter: func(match []string, apiOptions *options.Options) string {
+			return apiOptions.HtmlSafeModeFilter(match[1]) // Matched HTML comment or inline tag.
 		},
 	},
 
@@ -113,7 +115,7 @@ var DEFAULT_DEFS = []Definition{
 	{
 		Match:       regexp.MustCompile(`\\?(&[\w#][\w]+;)`),
 		Replacement: "",
-		Filter: func(match []string) string {
+		Filter: func(match []string, _ *options.Options) string {
 			return match[1] // Pass the entity through verbatim.
 		},
 	},
@@ -144,34 +146,59 @@ var DEFAULT_DEFS = []Definition{
 }
 
 // Reset definitions to defaults.
-func Init() {
-	Defs = make([]Definition, len(DEFAULT_DEFS))
+func (r *Replacements) Init() {
+	r.Defs = make([]Definition, len(DEFAULT_DEFS))
 	for i, def := range DEFAULT_DEFS {
-		Defs[i] = def
+		r.Defs[i] = def
 	}
+	r.Defs = append(r.Defs, r.custom...)
 }
 
-// Update existing or add new replacement definition.
-func SetDefinition(pattern string, flags string, replacement string) {
+// Register appends a new replacement definition whose replacement text is
+// returned by filter. match[0] is the matched text and match[1]... are the
+// match groups. Registered definitions persist across Init calls. Patterns
+// that match the empty string are rejected.
+func (r *Replacements) Register(pattern string, filter func(match []string) string) error {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return err
+	}
+	if re.MatchString("") {
+		return fmt.Errorf("replacement regular expression matches the empty string: %s", pattern)
+	}
+	def := Definition{
+		Match: re,
+		Filter: func(match []string, _ *options.Options) string {
+			return filter(match)
+		},
+	}
+	r.custom = append(r.custom, def)
+	r.Defs = append(r.Defs, def)
+	return nil
+}
 
+// Update existing or add new replacement definition.
+func (r *Replacements) SetDefinition(pattern string, flags string, replacement string) {
 	if strings.Contains(flags, "i") {
 		pattern = `(?i)` + pattern
 	}
 	if strings.Contains(flags, "m") {
 		pattern = `(?m)` + pattern
 	}
-	for i, def := range Defs {
+	for i, def := range r.Defs {
 		if def.Match.String() == pattern {
 			// Update existing definition.
-			Defs[i].Replacement = replacement
+			r.Defs[i].Replacement = replacement
 			return
 		}
 
 	}
 	// Append new definition to end of defs list (custom definitions have lower precedence).
 	if re, err := regexp.Compile(pattern); err != nil {
-		options.ErrorCallback("illegal replacement regular expression: " + err.Error())
+		r.Options.ErrorCallback(options.IllegalRegExp, "illegal replacement regular expression: "+err.Error())
+	} else if re.MatchString("") {
+		r.Options.ErrorCallback(options.IllegalRegExp, "replacement regular expression matches the empty string: "+pattern)
 	} else {
-		Defs = append(Defs, Definition{Match: re, Replacement: replacement})
+		r.Defs = append(r.Defs, Definition{Match: re, Replacement: replacement})
 	}
 }
@@ -7,16 +7,28 @@ import (
 )
 
 func TestInit(t *testing.T) {
-	Init()
-	assert.Equal(t, len(DEFAULT_DEFS), len(Defs))
-	assert.NotEqual(t, &DEFAULT_DEFS, &Defs)
+	r := &Replacements{}
+	r.Init()
+	assert.Equal(t, len(DEFAULT_DEFS), len(r.Defs))
+	assert.NotEqual(t, &DEFAULT_DEFS, &r.Defs)
 }
 
 func TestSetDefinition(t *testing.T) {
-	Init()
-	SetDefinition(`\\?<image:([^\s|]+?)>`, "", "foo")
-	assert.Equal(t, len(DEFAULT_DEFS), len(Defs))
-	SetDefinition(`bar`, "mi", "foo")
-	assert.Equal(t, len(DEFAULT_DEFS)+1, len(Defs))
-	assert.Equal(t, Defs[len(Defs)-1].Match.String(), "(?m)(?i)bar")
+	r := &Replacements{}
+	r.Init()
+	r.SetDefinition(`\\?<image:([^\s|]+?)>`, "", "foo")
+	assert.Equal(t, len(DEFAULT_DEFS), len(r.Defs))
+	r.SetDefinition(`bar`, "mi", "foo")
+	assert.Equal(t, len(DEFAULT_DEFS)+1, len(r.Defs))
+	assert.Equal(t, r.Defs[len(r.Defs)-1].Match.String(), "(?m)(?i)bar")
+}
+
+func TestRegister(t *testing.T) {
+	r := &Replacements{}
+	r.Init()
+	assert.True(t, r.Register(`x+`, func(match []string) string { return "y" }) == nil)
+	assert.Equal(t, len(DEFAULT_DEFS)+1, len(r.Defs))
+	assert.False(t, r.Register(`x*`, func(match []string) string { return "y" }) == nil)
+	assert.False(t, r.Register(`(`, func(match []string) string { return "y" }) == nil)
+	assert.Equal(t, len(DEFAULT_DEFS)+1, len(r.Defs))
 }
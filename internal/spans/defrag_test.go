@@ -0,0 +1,32 @@
+package spans
+
+import (
+	"testing"
+
+	"github.com/srackham/go-rimu/v11/internal/assert"
+)
+
+func Test_defrag(t *testing.T) {
+	tests := []struct {
+		frags []fragment
+		want  string
+	}{
+		{
+			frags: []fragment{
+				{text: ""},
+			},
+			want: "",
+		},
+		{
+			frags: []fragment{
+				{text: "foo"},
+				{text: "bar"},
+			},
+			want: "foobar",
+		},
+	}
+	for _, tt := range tests {
+		got := defrag(tt.frags)
+		assert.Equal(t, tt.want, got)
+	}
+}
@@ -4,7 +4,9 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"unicode"
 
+	"github.com/srackham/go-rimu/v11/internal/ast"
 	"github.com/srackham/go-rimu/v11/internal/options"
 
 	"github.com/srackham/go-rimu/v11/internal/expansion"
@@ -14,22 +16,93 @@ import (
 	"github.com/srackham/go-rimu/v11/internal/utils/str"
 )
 
-// macros and spans package dependency injections.
-var MacrosRender func(text string, silent bool) string
+// Spans renders the inline elements of a single document.
+type Spans struct {
+	Options      *options.Options
+	Quotes       *quotes.Quotes
+	Replacements *replacements.Replacements
+	MacrosRender func(text string, silent bool) string // macros package dependency injection.
+}
 
 type fragment struct {
 	text     string
 	done     bool
 	verbatim string // Replacements text rendered verbatim.
+	quote    string // Quote characters (quote tag fragments only).
+	open     bool   // Quote opening tag fragment.
+}
+
+// Render source text and return the resulting HTML. Typographic
+// replacements are processed if they are enabled by the API options.
+func (s *Spans) Render(source string) string {
+	return s.render(source, s.Options.IsTypography())
 }
 
-func Render(source string) string {
-	result := preReplacements(source)
+func (s *Spans) render(source string, typography bool) string {
+	result, saved := s.preReplacements(source)
 	frags := []fragment{{text: result, done: false}}
-	frags = fragQuotes(frags)
+	frags = s.fragQuotes(frags)
+	if typography {
+		frags = fragTypography(frags, saved)
+	}
 	frags = fragSpecials(frags)
 	result = defrag(frags)
-	return postReplacements(result)
+	return postReplacements(result, saved)
+}
+
+// Parse source text and return the resulting inline document tree nodes.
+// Typographic replacements are processed if they are enabled by the API
+// options.
+func (s *Spans) Parse(source string) []ast.Node {
+	return s.parse(source, s.Options.IsTypography())
+}
+
+func (s *Spans) parse(source string, typography bool) []ast.Node {
+	text, saved := s.preReplacements(source)
+	frags := s.fragQuotes([]fragment{{text: text, done: false}})
+	if typography {
+		frags = fragTypography(frags, saved)
+	}
+	// Replace a placeholder with the next saved replacement.
+	next := func() (frag fragment) {
+		if len(saved) > 0 {
+			frag, saved = saved[0], saved[1:]
+		}
+		return
+	}
+	root := &ast.Quote{}
+	stack := []*ast.Quote{root}
+	for _, frag := range frags {
+		top := stack[len(stack)-1]
+		switch {
+		case frag.open:
+			q := &ast.Quote{Quote: frag.quote, OpenTag: frag.text}
+			top.Children = append(top.Children, q)
+			stack = append(stack, q)
+		case frag.quote != "":
+			top.CloseTag = frag.text
+			stack = stack[:len(stack)-1]
+		case frag.done:
+			// Verbatim quoted text: replacements are rendered as source text.
+			text := regexp.MustCompile(`\x{0000}`).ReplaceAllStringFunc(frag.verbatim, func(string) string {
+				return next().verbatim
+			})
+			if text != "" {
+				top.Children = append(top.Children, &ast.Text{Text: text})
+			}
+		default:
+			for i, t := range strings.Split(frag.text, "\u0000") {
+				if i > 0 {
+					r := next()
+					top.Children = append(top.Children, &ast.Replacement{Source: r.verbatim, HTML: r.text})
+				}
+				if t != "" {
+					top.Children = append(top.Children, &ast.Text{Text: t})
+				}
+			}
+		}
+	}
+	return root.Children
 }
 
 // Converts fragments to a string.
@@ -41,34 +114,34 @@ func defrag(frags []fragment) (result string) {
 }
 
 // Fragment quotes in all fragments and return resulting fragments array.
-func fragQuotes(frags []fragment) (result []fragment) {
+func (s *Spans) fragQuotes(frags []fragment) (result []fragment) {
 	for _, frag := range frags {
-		result = append(result, fragQuote(frag)...)
+		result = append(result, s.fragQuote(frag)...)
 	}
 	// Strip backlash from escaped quotes in non-done fragments.
 	for i, frag := range result {
 		if !frag.done {
-			result[i].text = quotes.Unescape(frag.text)
+			result[i].text = s.Quotes.Unescape(frag.text)
 		}
 	}
 	return
 }
 
 // Fragment quotes in a single fragment and return resulting fragments array.
-func fragQuote(frag fragment) (result []fragment) {
+func (s *Spans) fragQuote(frag fragment) (result []fragment) {
 	if frag.done {
 		return []fragment{frag}
 	}
 	var match []int
 	nextIndex := 0
 	for {
-		s := frag.text[nextIndex:]
-		match = quotes.Find(s)
+		text := frag.text[nextIndex:]
+		match = s.Quotes.Find(text)
 		if match == nil {
 			return []fragment{frag}
 		}
 		// Check if quote is escaped.
-		if s[match[0]] == '\\' {
+		if text[match[0]] == '\\' {
 			// Restart search after escaped opening quote.
 			nextIndex += match[3]
 			continue
@@ -92,38 +165,36 @@ func fragQuote(frag fragment) (result []fragment) {
 	// Arrive here if we have a matched quote.
 	// The quote splits the input fragment into 5 or more output fragments:
 	// Text before the quote, left quote tag, quoted text, right quote tag and text after the quote.
-	def := quotes.GetDefinition(quote)
+	def := s.Quotes.GetDefinition(quote)
 	before := frag.text[:startIndex]
 	after := frag.text[endIndex:]
 	result = append(result, fragment{text: before, done: false})
-	result = append(result, fragment{text: def.OpenTag, done: true})
+	result = append(result, fragment{text: def.OpenTag, done: true, quote: def.Quote, open: true})
 	if !def.Spans {
 		// Spans are disabled so render the quoted text verbatim.
+		verbatim := quoted
 		quoted = str.ReplaceSpecialChars(quoted)
 		quoted = strings.Replace(quoted, "\u0000", "\u0001", -1) // Substitute verbatim replacement placeholder.
-		result = append(result, fragment{text: quoted, done: true})
+		result = append(result, fragment{text: quoted, done: true, verbatim: verbatim})
 	} else {
 		// Recursively process the quoted text.
-		result = append(result, fragQuote(fragment{text: quoted, done: false})...)
+		result = append(result, s.fragQuote(fragment{text: quoted, done: false})...)
 	}
-	result = append(result, fragment{text: def.CloseTag, done: true})
+	result = append(result, fragment{text: def.CloseTag, done: true, quote: def.Quote})
 	// Recursively process the following text.
-	result = append(result, fragQuote(fragment{text: after, done: false})...)
+	result = append(result, s.fragQuote(fragment{text: after, done: false})...)
 	return
 }
 
-// Stores placeholder replacement fragments saved by `preReplacements()` and restored by `postReplacements()`.
-var savedReplacements []fragment
-
 // Return text with replacements replaced with placeholders (see `postReplacements()`).
-func preReplacements(text string) (result string) {
-	savedReplacements = nil
-	frags := fragReplacements([]fragment{{text: text, done: false}})
+// The replaced fragments are returned in saved.
+func (s *Spans) preReplacements(text string) (result string, saved []fragment) {
+	frags := s.fragReplacements([]fragment{{text: text, done: false}})
 	// Reassemble text with replacement placeholders.
 	for _, frag := range frags {
 		if frag.done {
-			savedReplacements = append(savedReplacements, frag) // Save replaced text.
-			result += string('\u0000')                          // Placeholder for replaced text.
+			saved = append(saved, frag) // Save replaced text.
+			result += string('\u0000')  // Placeholder for replaced text.
 		} else {
 			result += frag.text
 		}
@@ -131,11 +202,11 @@ func preReplacements(text string) (result string) {
 	return
 }
 
-// Replace replacements placeholders with replacements text from savedReplacements[].
-func postReplacements(text string) string {
+// Replace replacements placeholders with replacements text from saved[].
+func postReplacements(text string, saved []fragment) string {
 	return regexp.MustCompile(`[\x{0000}\x{0001}]`).ReplaceAllStringFunc(text, func(match string) string {
 		var frag fragment
-		frag, savedReplacements = savedReplacements[0], savedReplacements[1:] // Remove frag from start of list.
+		frag, saved = saved[0], saved[1:] // Remove frag from start of list.
 		if match == string('\u0000') {
 			return frag.text
 		} else {
@@ -146,12 +217,12 @@ func postReplacements(text string) string {
 }
 
 // Fragment replacements in all fragments and return resulting fragments array.
-func fragReplacements(frags []fragment) (result []fragment) {
+func (s *Spans) fragReplacements(frags []fragment) (result []fragment) {
 	result = frags
-	for _, def := range replacements.Defs {
+	for _, def := range s.Replacements.Defs {
 		var tmp []fragment
 		for _, frag := range result {
-			tmp = append(tmp, fragReplacement(frag, def)...)
+			tmp = append(tmp, s.fragReplacement(frag, def)...)
 		}
 		result = tmp
 	}
@@ -160,7 +231,7 @@ func fragReplacements(frags []fragment) (result []fragment) {
 
 // Fragment replacements in a single fragment for a single replacement definition.
 // Return resulting fragments list.
-func fragReplacement(frag fragment, def replacements.Definition) (result []fragment) {
+func (s *Spans) fragReplacement(frag fragment, def replacements.Definition) (result []fragment) {
 	if frag.done {
 		return []fragment{frag}
 	}
@@ -190,17 +261,111 @@ func fragReplacement(frag fragment, def replacements.Definition) (result []fragm
 	} else {
 		submatches := def.Match.FindStringSubmatch(matched)
 		if def.Filter == nil {
-			replacement = ReplaceMatch(submatches, def.Replacement, expansion.Options{})
+			replacement = s.ReplaceMatch(submatches, def.Replacement, expansion.Options{})
 		} else {
-			replacement = def.Filter(submatches)
+			replacement = def.Filter(submatches, s.Options)
 		}
 	}
 	result = append(result, fragment{text: replacement, done: true, verbatim: matched})
 	// Recursively process the remaining text.
-	result = append(result, fragReplacement(fragment{text: after, done: false}, def)...)
+	result = append(result, s.fragReplacement(fragment{text: after, done: false}, def)...)
+	return
+}
+
+// MATCH_OPEN_TAG matches an HTML opening tag.
+var MATCH_OPEN_TAG = regexp.MustCompile(`^<[a-zA-Z][^>]*>$`)
+
+// fragTypography replaces straight quotes with curly quotes, "--" and "---"
+// with en and em dashes and "..." with an ellipsis in all non-done fragments.
+// Quote direction is determined by the preceding character (which may be in
+// a preceding fragment or replacement). saved contains the replacements
+// returned by preReplacements.
+func fragTypography(frags []fragment, saved []fragment) (result []fragment) {
+	next := 0 // Index of the next saved replacement.
+	// replaced returns the character that is deemed to precede the text
+	// following the next saved replacement.
+	replaced := func() rune {
+		r := 'x'
+		if next < len(saved) && MATCH_OPEN_TAG.MatchString(saved[next].verbatim) {
+			r = ' ' // Opening HTML tags precede opening quotes.
+		}
+		next++
+		return r
+	}
+	result = make([]fragment, len(frags))
+	prev := ' ' // The character preceding the current fragment.
+	for i, frag := range frags {
+		switch {
+		case frag.open:
+			prev = ' ' // Quote opening tags precede opening quotes.
+		case frag.done:
+			next += strings.Count(frag.text, "\u0001") // Skip verbatim replacements.
+			prev = 'x'
+		default:
+			frag.text, prev = typography(frag.text, prev, replaced)
+		}
+		result[i] = frag
+	}
 	return
 }
 
+// typography returns text with typographic replacements along with the
+// character deemed to precede following text. prev is the character preceding
+// the text and replaced is called for each replacement placeholder.
+func typography(text string, prev rune, replaced func() rune) (string, rune) {
+	text = strings.Replace(text, "---", "\u2014", -1)
+	text = strings.Replace(text, "--", "\u2013", -1)
+	text = strings.Replace(text, "...", "\u2026", -1)
+	var b strings.Builder
+	runes := []rune(text)
+	escaped := false // The current character is an escaped straight quote.
+	for i, r := range runes {
+		opening := unicode.IsSpace(prev) || strings.ContainsRune("([{\u2014\u2013\u201c\u2018", prev)
+		switch {
+		case r == '\\' && i+1 < len(runes) && (runes[i+1] == '"' || runes[i+1] == '\''):
+			// Drop the backslash from escaped straight quotes.
+			escaped = true
+			continue
+		case escaped:
+			b.WriteRune(r)
+			escaped = false
+		case r == '\u0000':
+			b.WriteRune(r)
+			prev = replaced()
+			continue
+		case r == '"' && opening:
+			r = '\u201c'
+			b.WriteRune(r)
+		case r == '"':
+			r = '\u201d'
+			b.WriteRune(r)
+		case r == '\'' && opening && !elision(runes[i+1:]):
+			r = '\u2018'
+			b.WriteRune(r)
+		case r == '\'':
+			r = '\u2019'
+			b.WriteRune(r)
+		default:
+			b.WriteRune(r)
+		}
+		prev = r
+	}
+	return b.String(), prev
+}
+
+// MATCH_ELISION matches the text following a leading apostrophe that elides the
+// start of a word e.g. '90s, 'tis.
+var MATCH_ELISION = regexp.MustCompile(`(?i)^(?:\d\ds?|tis|twas|em)(?:[^\w']|$)`)
+
+// elision returns true if the text following a straight single quote in an
+// opening position is an elided word, in which case the quote is an apostrophe.
+func elision(text []rune) bool {
+	if len(text) > 5 {
+		text = text[:5]
+	}
+	return MATCH_ELISION.MatchString(string(text))
+}
+
 func fragSpecials(frags []fragment) (result []fragment) {
 	// Replace special characters in all non-done fragments.
 	result = make([]fragment, len(frags))
@@ -216,7 +381,7 @@ func fragSpecials(frags []fragment) (result []fragment) {
 // Replace pattern "$1" or "$$1", "$2" or "$$2"... in `replacement` with corresponding match groups
 // from `match`. If pattern starts with one "$" character add specials to `opts`,
 // if it starts with two "$" characters add spans to `opts`.
-func ReplaceMatch(match []string, replacement string, opts expansion.Options) string {
+func (s *Spans) ReplaceMatch(match []string, replacement string, opts expansion.Options) string {
 	return re.ReplaceAllStringSubmatchFunc(regexp.MustCompile(`(\${1,2})(\d)`), replacement, func(arguments []string) (result string) {
 		// Replace $1, $2 ... with corresponding match groups.
 		switch {
@@ -227,23 +392,43 @@ func ReplaceMatch(match []string, replacement string, opts expansion.Options) st
 		}
 		i, _ := strconv.ParseInt(arguments[2], 10, strconv.IntSize) // match group number.
 		if int(i) >= len(match) {
-			options.ErrorCallback("undefined replacement group: " + arguments[0])
+			s.Options.ErrorCallback(options.UndefinedReplacementGroup, "undefined replacement group: "+arguments[0])
 			return ""
 		}
 		result = match[i] // match group text.
-		return ReplaceInline(result, opts)
+		return s.ReplaceInline(result, opts)
 	}, -1)
 }
 
+// ParseInline parses the inline elements specified in options in text and
+// returns the resulting document tree nodes.
+func (s *Spans) ParseInline(text string, opts expansion.Options) []ast.Node {
+	if opts.Macros {
+		text = s.MacrosRender(text, false)
+	}
+	if text == "" {
+		return nil
+	}
+	// Spans also expand special characters.
+	switch {
+	case opts.Spans:
+		return s.parse(text, opts.IsTypography(s.Options.IsTypography()))
+	case opts.Specials:
+		return []ast.Node{&ast.Text{Text: text}}
+	default:
+		return []ast.Node{&ast.HTML{Text: text}}
+	}
+}
+
 // Replace the inline elements specified in options in text and return the result.
-func ReplaceInline(text string, opts expansion.Options) string {
+func (s *Spans) ReplaceInline(text string, opts expansion.Options) string {
 	if opts.Macros {
-		text = MacrosRender(text, false)
+		text = s.MacrosRender(text, false)
 	}
 	// Spans also expand special characters.
 	switch {
 	case opts.Spans:
-		text = Render(text)
+		text = s.render(text, opts.IsTypography(s.Options.IsTypography()))
 	case opts.Specials:
 		text = str.ReplaceSpecialChars(text)
 	}
@@ -1,9 +1,12 @@
-package spans
+package spans_test
 
 import (
 	"testing"
 
 	"github.com/srackham/go-rimu/v11/internal/assert"
+	"github.com/srackham/go-rimu/v11/internal/ast"
+	"github.com/srackham/go-rimu/v11/internal/document"
+	"github.com/srackham/go-rimu/v11/internal/expansion"
 )
 
 func TestRender(t *testing.T) {
@@ -22,34 +25,58 @@ func TestRender(t *testing.T) {
 		// Replacements.
 		{"<image:foo|bar>", `<img src="foo" alt="bar">`},
 		{"<image:foo|bar\nboo>", "<img src=\"foo\" alt=\"bar\nboo\">"},
+		{"`a &copy; b` &copy;", "<code>a &amp;copy; b</code> &copy;"},
+		{"\\*foo* <x> 1 < 2", "*foo* <x> 1 &lt; 2"},
 	}
+	s := document.New().Spans
 	for _, tt := range tests {
-		got := Render(tt.source)
+		got := s.Render(tt.source)
+		assert.Equal(t, tt.want, got)
+		got = ast.RenderHTML(s.Parse(tt.source)...)
 		assert.Equal(t, tt.want, got)
 	}
 }
 
-func Test_defrag(t *testing.T) {
+func TestParse(t *testing.T) {
+	s := document.New().Spans
+	nodes := s.Parse("a *b `c`* &copy;")
+	assert.Equal(t, 4, len(nodes))
+	assert.Equal(t, "a ", nodes[0].(*ast.Text).Text)
+	q := nodes[1].(*ast.Quote)
+	assert.Equal(t, "*", q.Quote)
+	assert.Equal(t, "<em>", q.OpenTag)
+	assert.Equal(t, "</em>", q.CloseTag)
+	assert.Equal(t, 2, len(q.Children))
+	assert.Equal(t, "c", q.Children[1].(*ast.Quote).Children[0].(*ast.Text).Text)
+	assert.Equal(t, " ", nodes[2].(*ast.Text).Text)
+	r := nodes[3].(*ast.Replacement)
+	assert.Equal(t, "&copy;", r.Source)
+	assert.Equal(t, "&copy;", r.HTML)
+}
+
+func TestTypography(t *testing.T) {
 	tests := []struct {
-		frags []fragment
-		want  string
+		source string
+		want   string
 	}{
-		{
-			frags: []fragment{
-				{text: ""},
-			},
-			want: "",
-		},
-		{
-			frags: []fragment{
-				{text: "foo"},
-				{text: "bar"},
-			},
-			want: "foobar",
-		},
+		{`"a" 'b' it's`, "“a” ‘b’ it’s"},
+		{"a -- b --- c...", "a – b — c…"},
+		{`("a") ['b']`, "(“a”) [‘b’]"},
+		{"`\"a\" --` <b>'b'</b>", "<code>\"a\" --</code> <b>‘b’</b>"},
+		{`*"a"* "*b*"`, "<em>“a”</em> “<em>b</em>”"},
+		{`\"a\" \'b'`, `"a" 'b’`},
+		{`&copy;"a"`, "&copy;”a”"},
+		{`"'a'" '"b"'`, "“‘a’” ‘“b”’"},
+		{`the '90s, '08 and 'tis 'em`, "the ’90s, ’08 and ’tis ’em"},
+		{`'99' '1234'`, "‘99’ ‘1234’"},
 	}
+	s := document.New().Spans
 	for _, tt := range tests {
-		got := defrag(tt.frags)
+		got := s.ReplaceInline(tt.source, expansion.Options{Spans: true, Typography: true})
+		assert.Equal(t, tt.want, got)
+		got = ast.RenderHTML(s.ParseInline(tt.source, expansion.Options{Spans: true, Typography: true})...)
 		assert.Equal(t, tt.want, got)
 	}
+	// Typography is disabled by default.
+	assert.Equal(t, `"a" -- b`, s.Render(`"a" -- b`))
 }
@@ -0,0 +1,102 @@
+/*
+  Table of contents.
+*/
+
+package toc
+
+import (
+	"html"
+	"regexp"
+	"strings"
+
+	"github.com/srackham/go-rimu/v11/internal/ast"
+	"github.com/srackham/go-rimu/v11/internal/utils/str"
+)
+
+// PLACEHOLDER is the value of the --toc-html macro while a document is parsed,
+// it is replaced by the document's table of contents once all headers have
+// been parsed.
+const PLACEHOLDER = "<!--rimu-toc-->"
+
+// Matches the source of a cross-reference link to a heading. $1 = heading id.
+var MATCH_CROSS_REFERENCE = regexp.MustCompile(`^<#([\w\-]+)>$`)
+
+// Heading is a table of contents entry.
+type Heading struct {
+	Level  int    // 1..6
+	Number string // Section number (blank if the heading is not numbered).
+	Text   string // Plain text (excluding the section number).
+	ID     string // HTML id (blank if the heading does not have an id).
+	Line   int    // Source line number (zero if unknown).
+}
+
+// NewHeading returns the table of contents entry for a header node at source
+// line number line.
+func NewHeading(h *ast.Header, line int) Heading {
+	return Heading{Level: h.Level, Number: h.Number, Text: Text(h.Children...), ID: h.ID, Line: line}
+}
+
+// Title returns the heading's section number (if any) followed by its text
+// e.g. for table of contents entries and cross-reference link text.
+func (h Heading) Title() string {
+	if h.Number == "" {
+		return h.Text
+	}
+	return h.Number + " " + h.Text
+}
+
+// CrossReference returns the HTML link to the numbered heading with the given
+// id, the link text is the heading's title. Returns a blank string if there is
+// no numbered heading with the id.
+func CrossReference(headings []Heading, id string) string {
+	for _, h := range headings {
+		if h.ID == id && h.Number != "" {
+			return `<a href="#` + id + `">` + str.ReplaceSpecialChars(h.Title()) + `</a>`
+		}
+	}
+	return ""
+}
+
+// Text returns the plain text rendered from nodes.
+func Text(nodes ...ast.Node) string {
+	text := regexp.MustCompile(`<[^>]*>`).ReplaceAllString(ast.RenderHTML(nodes...), "")
+	return strings.TrimSpace(html.UnescapeString(text))
+}
+
+// HTML returns a nested <nav><ul> table of contents rendered from headings.
+// Headings without an id are not linked.
+// Returns a blank string if there are no headings.
+func HTML(headings []Heading) string {
+	if len(headings) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("<nav><ul>")
+	levels := []int{headings[0].Level} // Open list levels.
+	for i, h := range headings {
+		switch {
+		case i == 0:
+			b.WriteString("<li>")
+		case h.Level > levels[len(levels)-1]:
+			b.WriteString("<ul><li>")
+			levels = append(levels, h.Level)
+		default:
+			b.WriteString("</li>")
+			for len(levels) > 1 && h.Level < levels[len(levels)-1] {
+				b.WriteString("</ul></li>")
+				levels = levels[:len(levels)-1]
+			}
+			b.WriteString("<li>")
+		}
+		text := str.ReplaceSpecialChars(h.Title())
+		if h.ID != "" {
+			b.WriteString(`<a href="#` + h.ID + `">` + text + `</a>`)
+		} else {
+			b.WriteString(text)
+		}
+	}
+	b.WriteString("</li>")
+	b.WriteString(strings.Repeat("</ul></li>", len(levels)-1))
+	b.WriteString("</ul></nav>")
+	return b.String()
+}
@@ -0,0 +1,44 @@
+package toc
+
+import (
+	"testing"
+
+	"github.com/srackham/go-rimu/v11/internal/assert"
+	"github.com/srackham/go-rimu/v11/internal/ast"
+)
+
+func TestHTML(t *testing.T) {
+	tests := []struct {
+		headings []Heading
+		want     string
+	}{
+		{nil, ""},
+		{[]Heading{{1, "", "A & B", "a", 0}}, `<nav><ul><li><a href="#a">A &amp; B</a></li></ul></nav>`},
+		{
+			[]Heading{{1, "", "A", "a", 0}, {2, "", "B", "b", 0}, {3, "", "C", "", 0}, {2, "", "D", "d", 0}, {1, "", "E", "e", 0}},
+			`<nav><ul><li><a href="#a">A</a><ul><li><a href="#b">B</a><ul><li>C</li></ul></li><li><a href="#d">D</a></li></ul></li><li><a href="#e">E</a></li></ul></nav>`,
+		},
+		{
+			// Skipped and out of order levels.
+			[]Heading{{2, "1", "A", "", 0}, {4, "", "B", "", 0}, {1, "", "C", "", 0}},
+			`<nav><ul><li>1 A<ul><li>B</li></ul></li><li>C</li></ul></nav>`,
+		},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, HTML(tt.headings))
+	}
+}
+
+func TestText(t *testing.T) {
+	nodes := []ast.Node{&ast.Text{Text: "A < "}, &ast.Quote{OpenTag: "<em>", CloseTag: "</em>", Children: []ast.Node{&ast.Text{Text: "B"}}}, &ast.Replacement{HTML: "&copy;"}}
+	assert.Equal(t, "A < B©", Text(nodes...))
+}
+
+func TestCrossReference(t *testing.T) {
+	headings := []Heading{{1, "", "A", "a", 0}, {2, "1", "B & C", "b", 0}, {2, "2", "D", "", 0}}
+	assert.Equal(t, `<a href="#b">1 B &amp; C</a>`, CrossReference(headings, "b"))
+	assert.Equal(t, "", CrossReference(headings, "a")) // Not numbered.
+	assert.Equal(t, "", CrossReference(headings, "x"))
+	assert.True(t, MATCH_CROSS_REFERENCE.MatchString("<#b>"))
+	assert.False(t, MATCH_CROSS_REFERENCE.MatchString("\\<#b>"))
+}
@@ -0,0 +1,91 @@
+package rimu
+
+import "github.com/srackham/go-rimu/v11/internal/ast"
+
+// Node is a document tree node. The concrete node types are listed below.
+type Node = ast.Node
+
+// BlockAttributes are the HTML attributes assigned to a block element by a
+// preceding Block Attributes element.
+type BlockAttributes = ast.BlockAttributes
+
+// Block nodes.
+type (
+	Document       = ast.Document
+	HTML           = ast.HTML
+	Newline        = ast.Newline
+	Header         = ast.Header
+	Image          = ast.Image
+	DelimitedBlock = ast.DelimitedBlock
+	Paragraph      = ast.Paragraph
+	Admonition     = ast.Admonition
+	List           = ast.List
+	ListItem       = ast.ListItem
+	Table          = ast.Table
+	TableCell      = ast.TableCell
+	Footnotes      = ast.Footnotes
+	Footnote       = ast.Footnote
+)
+
+// AdmonitionKind is the kind of an Admonition.
+type AdmonitionKind = ast.AdmonitionKind
+
+// Admonition kinds.
+const (
+	AdmonitionNote      = ast.AdmonitionNote
+	AdmonitionTip       = ast.AdmonitionTip
+	AdmonitionImportant = ast.AdmonitionImportant
+	AdmonitionCaution   = ast.AdmonitionCaution
+	AdmonitionWarning   = ast.AdmonitionWarning
+	AdmonitionDanger    = ast.AdmonitionDanger
+)
+
+// Inline nodes.
+type (
+	Text        = ast.Text
+	Quote       = ast.Quote
+	Replacement = ast.Replacement
+)
+
+// AttributesOf returns a pointer to the node's Block Attributes or nil if the
+// node does not have Block Attributes.
+func AttributesOf(n Node) *BlockAttributes {
+	return ast.AttributesOf(n)
+}
+
+// Children returns the child nodes of n.
+func Children(n Node) []Node {
+	return ast.Children(n)
+}
+
+// Walk traverses the tree rooted at n in depth-first order calling f for each node.
+// Children of a node are skipped if f returns false.
+func Walk(n Node, f func(n Node) bool) {
+	ast.Walk(n, f)
+}
+
+// RenderHTML serializes document tree nodes to HTML.
+// RenderHTML(Parse(text, opts)) returns the same HTML as Render(text, opts).
+func RenderHTML(nodes ...Node) string {
+	return ast.RenderHTML(nodes...)
+}
+
+// RenderText serializes the document tree rooted at n to plain text.
+// Paragraphs are wrapped to width columns (they are not wrapped if width is
+// zero), headers are underlined, list items are indented and link URLs are
+// listed as numbered references at the end of the text.
+func RenderText(n Node, width int) string {
+	return ast.RenderText(n, width)
+}
+
+// RenderMarkdown serializes the document tree rooted at n to CommonMark/GFM
+// Markdown. Elements that cannot be expressed in Markdown are rendered as HTML
+// and reported to the callback (if it is not nil) with the UnsupportedMarkdown
+// warning code.
+func RenderMarkdown(n Node, callback CallbackFunction) string {
+	return ast.RenderMarkdown(n, func(message string) {
+		if callback != nil {
+			callback(CallbackMessage{Kind: Warning, Code: UnsupportedMarkdown, Text: message})
+		}
+	})
+}
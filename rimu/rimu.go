@@ -1,8 +1,20 @@
 package rimu
 
 import (
+	"io"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/srackham/go-rimu/v11/internal/delimitedblocks"
 	"github.com/srackham/go-rimu/v11/internal/document"
+	"github.com/srackham/go-rimu/v11/internal/expansion"
+	"github.com/srackham/go-rimu/v11/internal/frontmatter"
+	"github.com/srackham/go-rimu/v11/internal/iotext"
+	"github.com/srackham/go-rimu/v11/internal/lineblocks"
+	"github.com/srackham/go-rimu/v11/internal/lists"
+	"github.com/srackham/go-rimu/v11/internal/markdown"
 	"github.com/srackham/go-rimu/v11/internal/options"
+	"github.com/srackham/go-rimu/v11/internal/toc"
 )
 
 // CallbackFunction is the API callback function type.
@@ -11,11 +23,251 @@ type CallbackFunction = options.CallbackFunction
 // CallbackMessage contains the callback message passed to the callback function.
 type CallbackMessage = options.CallbackMessage
 
+// Diagnostic severities (CallbackMessage Kind values).
+const (
+	Error   = options.Error
+	Warning = options.Warning
+	Info    = options.Info
+)
+
+// Diagnostic codes (CallbackMessage Code values).
+// Codes are stable: they are never changed or reused.
+const (
+	DeprecatedSyntax          = options.DeprecatedSyntax
+	DuplicateID               = options.DuplicateID
+	IllegalApiOption          = options.IllegalApiOption
+	IllegalBlockDefinition    = options.IllegalBlockDefinition
+	IllegalBlockOption        = options.IllegalBlockOption
+	IllegalInclude            = options.IllegalInclude
+	IllegalMacroDefinition    = options.IllegalMacroDefinition
+	IllegalMacroSyntax        = options.IllegalMacroSyntax
+	IllegalRegExp             = options.IllegalRegExp
+	InvalidUTF8               = options.InvalidUTF8
+	UndefinedFootnote         = options.UndefinedFootnote
+	UndefinedMacro            = options.UndefinedMacro
+	UndefinedReplacementGroup = options.UndefinedReplacementGroup
+	UnsupportedMarkdown       = options.UnsupportedMarkdown
+	UnterminatedBlock         = options.UnterminatedBlock
+	UnusedFootnote            = options.UnusedFootnote
+)
+
 // RenderOptions contains the API render options.
 type RenderOptions = options.RenderOptions
 
+// DelimitedBlockDefinition defines a custom Delimited Block.
+type DelimitedBlockDefinition = delimitedblocks.CustomDefinition
+
+// LineBlockDefinition defines a custom Line Block.
+type LineBlockDefinition = lineblocks.CustomDefinition
+
+// Heading is a document heading (a table of contents entry).
+type Heading = toc.Heading
+
+// Task is a task list item.
+type Task = lists.Task
+
+// ExpansionOptions are Delimited Block content expansion options.
+type ExpansionOptions = expansion.Options
+
+// Renderer translates Rimu Markup to HTML.
+// Each Renderer has its own macro, quote, replacement, delimited block and
+// block attribute state which persists between Render calls (unless the Reset
+// option is set). Renderers are isolated from one another and a Renderer is safe
+// for concurrent use by multiple goroutines.
+type Renderer struct {
+	mu  sync.Mutex
+	doc *document.Document
+}
+
+// NewRenderer returns a new Renderer initialised to default state.
+func NewRenderer() *Renderer {
+	return &Renderer{doc: document.New()}
+}
+
+// Render translates Rimu Markup to HTML.
+func (r *Renderer) Render(text string, opts RenderOptions) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.doc.UpdateOptions(opts)
+	return r.doc.Render(text)
+}
+
+// Parse translates Rimu Markup to a document tree.
+// Use RenderHTML to serialize the tree to HTML.
+func (r *Renderer) Parse(text string, opts RenderOptions) *Document {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.doc.UpdateOptions(opts)
+	return r.doc.Parse(text)
+}
+
+// Migrate returns Rimu Markup with deprecated syntax replaced by its modern
+// equivalent. Deprecated syntax is also reported by deprecated-syntax warning
+// callbacks. The text is rendered so Migrate updates Renderer state in the
+// same way as Render.
+func (r *Renderer) Migrate(text string, opts RenderOptions) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.doc.UpdateOptions(opts)
+	return r.doc.Migrate(text)
+}
+
+// Macros returns the Renderer's macro definitions (the macro values set by
+// the most recent render along with any previously set values).
+func (r *Renderer) Macros() map[string]string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.doc.Macros.Values()
+}
+
+// Metadata returns the front matter fields of the most recently rendered
+// document (nil if there was no front matter or the FrontMatter option is not
+// set).
+func (r *Renderer) Metadata() map[string]string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.doc.Metadata == nil {
+		return nil
+	}
+	result := make(map[string]string, len(r.doc.Metadata))
+	for k, v := range r.doc.Metadata {
+		result[k] = v
+	}
+	return result
+}
+
+// Headings returns the headings of the most recently rendered document.
+func (r *Renderer) Headings() []Heading {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Heading(nil), r.doc.Headings...)
+}
+
+// Tasks returns the task list items of the most recently rendered document.
+func (r *Renderer) Tasks() []Task {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Task(nil), r.doc.Lists.Tasks...)
+}
+
+// RenderTo translates Rimu Markup read from src to HTML written to w.
+// Source lines are read on demand and each top-level block is written as soon
+// as it is rendered, so memory use depends on the largest block rather than
+// the size of the document.
+// Returns the first read or write error.
+func (r *Renderer) RenderTo(w io.Writer, src io.Reader, opts RenderOptions) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.doc.UpdateOptions(opts)
+	return r.doc.RenderTo(w, src)
+}
+
+// RegisterDelimitedBlock adds a custom Delimited Block definition.
+// The block is matched before the definition named by def.Before (by default
+// it is matched before normal paragraphs). Registered definitions are not
+// removed by the Reset option.
+func (r *Renderer) RegisterDelimitedBlock(def DelimitedBlockDefinition) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.doc.DelimitedBlocks.Register(def)
+}
+
+// RegisterLineBlock adds a custom Line Block definition.
+// The line is matched before the definition named by def.Before (by default
+// it is matched before Headers). Registered definitions are not removed by the
+// Reset option.
+func (r *Renderer) RegisterLineBlock(def LineBlockDefinition) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.doc.LineBlocks.Register(def)
+}
+
+// RegisterReplacement adds a Replacement definition whose replacement HTML is
+// returned by filter. groups[0] is the matched text and groups[1]... are the
+// pattern's match groups. Prefix the pattern with `\\?` to allow the
+// replacement to be escaped with a backslash. Registered replacements are
+// processed after the built-in replacements and are not removed by the Reset
+// option. Returns an error if the pattern is not a valid regular expression or
+// if it matches the empty string.
+func (r *Renderer) RegisterReplacement(pattern string, filter func(groups []string) string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.doc.Replacements.Register(pattern, filter)
+}
+
+// defaultRenderer is the Renderer used by the Render function.
+var defaultRenderer = NewRenderer()
+
 // Render is public API to translate Rimu Markup to HTML.
+// It uses a shared default Renderer.
 func Render(text string, opts RenderOptions) string {
-	options.UpdateOptions(opts)
-	return document.Render(text)
+	return defaultRenderer.Render(text, opts)
+}
+
+// Parse is public API to translate Rimu Markup to a document tree.
+// It uses the shared default Renderer.
+func Parse(text string, opts RenderOptions) *Document {
+	return defaultRenderer.Parse(text, opts)
+}
+
+// Migrate is public API to replace deprecated Rimu Markup syntax with its
+// modern equivalent. It uses the shared default Renderer.
+func Migrate(text string, opts RenderOptions) string {
+	return defaultRenderer.Migrate(text, opts)
+}
+
+// Format is public API to format Rimu Markup in the canonical Rimu source
+// format. The formatted source renders the same HTML as the text: formatting
+// that would change the rendered HTML is not applied. The text is formatted
+// with new Renderer state (the Reset option is implied) and the Callback option
+// reports the text's diagnostics.
+func Format(text string, opts RenderOptions) string {
+	opts.Reset = nil
+	doc := document.New()
+	doc.UpdateOptions(opts)
+	opts.Callback = nil
+	return doc.Format(text, func() *document.Document {
+		doc := document.New()
+		doc.UpdateOptions(opts)
+		return doc
+	})
+}
+
+// RenderTo is public API to translate Rimu Markup read from r to HTML written to w.
+// It uses the shared default Renderer.
+func RenderTo(w io.Writer, r io.Reader, opts RenderOptions) error {
+	return defaultRenderer.RenderTo(w, r, opts)
+}
+
+// RegisterDelimitedBlock is public API to add a custom Delimited Block
+// definition to the shared default Renderer.
+func RegisterDelimitedBlock(def DelimitedBlockDefinition) error {
+	return defaultRenderer.RegisterDelimitedBlock(def)
+}
+
+// RegisterLineBlock is public API to add a custom Line Block definition to the
+// shared default Renderer.
+func RegisterLineBlock(def LineBlockDefinition) error {
+	return defaultRenderer.RegisterLineBlock(def)
+}
+
+// RegisterReplacement is public API to add a Replacement definition with a Go
+// filter function to the shared default Renderer.
+func RegisterReplacement(pattern string, filter func(groups []string) string) error {
+	return defaultRenderer.RegisterReplacement(pattern, filter)
+}
+
+// ParseFrontMatter returns the front matter fields at the start of the Rimu
+// Markup text (nil if there is no front matter).
+func ParseFrontMatter(text string) map[string]string {
+	if !utf8.ValidString(text) {
+		return nil
+	}
+	return frontmatter.Read(iotext.NewReader(text))
+}
+
+// FromMarkdown is public API to convert Markdown (CommonMark/GFM) source to
+// Rimu Markup source.
+func FromMarkdown(text string) string {
+	return markdown.ToRimu(text)
 }
@@ -1,10 +1,17 @@
 package rimu
 
 import (
+	"bytes"
 	"encoding/json"
+	"fmt"
+	"html"
+	"io"
 	"io/ioutil"
+	"regexp"
 	"strings"
+	"sync"
 	"testing"
+	"testing/fstest"
 
 	"github.com/srackham/go-rimu/v11/internal/assert"
 )
@@ -22,6 +29,7 @@ type apiOptions struct {
 	SafeMode        int    `json:"safeMode,omitempty"`
 	HtmlReplacement string `json:"htmlReplacement,omitempty"`
 	Reset           bool   `json:"reset,omitempty"`
+	Typography      bool   `json:"typography,omitempty"`
 }
 
 func TestRender(t *testing.T) {
@@ -55,6 +63,7 @@ func TestRender(t *testing.T) {
 			Reset:           tt.Options.Reset,
 			SafeMode:        tt.Options.SafeMode,
 			HtmlReplacement: tt.Options.HtmlReplacement,
+			Typography:      tt.Options.Typography,
 			Callback:        func(message CallbackMessage) { msg += message.Kind + ": " + message.Text + "\n" },
 		}
 		// fmt.Println("Description: ", tt.Description)
@@ -72,6 +81,650 @@ func TestRender(t *testing.T) {
 	}
 }
 
+func TestRenderer(t *testing.T) {
+	// Renderers are isolated.
+	r1 := NewRenderer()
+	r2 := NewRenderer()
+	r1.Render("{x}='1'\n*='<b>|</b>'", RenderOptions{})
+	r2.Render("{x}='2'", RenderOptions{})
+	assert.Equal(t, "<p>1 <b>a</b></p>", r1.Render("{x} *a*", RenderOptions{}))
+	assert.Equal(t, "<p>2 <em>a</em></p>", r2.Render("{x} *a*", RenderOptions{}))
+	// A Renderer can be shared by multiple goroutines.
+	r := NewRenderer()
+	r.Render("{x}='foo'", RenderOptions{})
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				assert.Equal(t, "<h1>foo</h1>\n<p><em>foo</em></p>", r.Render("# {x}\n\n*{x}*", RenderOptions{}))
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestRenderTo(t *testing.T) {
+	raw, err := ioutil.ReadFile("./testdata/rimu-tests.json")
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	var tests []renderTest
+	json.Unmarshal(raw, &tests)
+	tests = append(tests, renderTest{Input: "Hello\r\rWorld\r\n\n- Item\r"})
+	// Streamed rendering is identical to string rendering.
+	for _, tt := range tests {
+		if strings.Contains(tt.Unsupported, "go") {
+			continue
+		}
+		opts := RenderOptions{
+			SafeMode:        tt.Options.SafeMode,
+			HtmlReplacement: tt.Options.HtmlReplacement,
+		}
+		want := NewRenderer().Render(tt.Input, opts)
+		var got bytes.Buffer
+		err := NewRenderer().RenderTo(&got, strings.NewReader(tt.Input), opts)
+		assert.True(t, err == nil)
+		assert.Equal(t, want, got.String())
+	}
+	// Invalid UTF-8 input.
+	msg := ""
+	var got bytes.Buffer
+	err = RenderTo(&got, strings.NewReader("Hello\n\nWorld \xbb"), RenderOptions{
+		Reset:    true,
+		Callback: func(message CallbackMessage) { msg += message.Kind + ": " + message.Text },
+	})
+	assert.True(t, err == nil)
+	assert.Equal(t, "<p>Hello</p>", got.String())
+	assert.Equal(t, "error: invalid UTF-8 input", msg)
+}
+
+func TestParse(t *testing.T) {
+	doc := Parse("# Title\n\n.lead\nHello *World*\n\n- Item\n\n``\ncode\n``", RenderOptions{Reset: true})
+	assert.Equal(t, 6, len(doc.Children))
+	header := doc.Children[0].(*Header)
+	assert.Equal(t, 1, header.Level)
+	assert.Equal(t, "Title", header.Children[0].(*Text).Text)
+	para := doc.Children[2].(*Paragraph)
+	assert.Equal(t, "paragraph", para.Name)
+	assert.Equal(t, "lead", para.Classes)
+	quote := para.Children[1].(*Quote)
+	assert.Equal(t, "*", quote.Quote)
+	list := doc.Children[4].(*List)
+	assert.Equal(t, "-", list.ID)
+	assert.Equal(t, "Item", list.Items[0].Children[0].(*Text).Text)
+	code := doc.Children[5].(*DelimitedBlock)
+	assert.Equal(t, "code", code.Name)
+	// Transform the tree before rendering it.
+	Walk(doc, func(n Node) bool {
+		switch n := n.(type) {
+		case *Header:
+			n.Level = 2
+		case *Text:
+			n.Text = strings.ToUpper(n.Text)
+		}
+		if attrs := AttributesOf(n); attrs != nil && attrs.Classes == "lead" {
+			attrs.ID = "intro"
+		}
+		return true
+	})
+	want := "<h2>TITLE</h2>\n<p class=\"lead\" id=\"intro\">HELLO <em>WORLD</em></p>\n<ul><li>ITEM</li></ul><pre><code>CODE</code></pre>"
+	assert.Equal(t, want, RenderHTML(doc))
+}
+
+func TestRenderText(t *testing.T) {
+	doc := Parse("## Steps\n1. Read the <https://example.com|manual>.\n2. Run `make`.", RenderOptions{Reset: true})
+	want := "Steps\n-----\n\n1. Read the manual [1].\n2. Run make.\n\n[1] https://example.com"
+	assert.Equal(t, want, RenderText(doc, 72))
+	assert.Equal(t, "one two\nthree", RenderText(Parse("one two three", RenderOptions{}), 7))
+}
+
+func TestRenderMarkdown(t *testing.T) {
+	source := "{x} = 'macro'\n## *Title*\n{x} <https://example.com|link> and `code`.\n\n- [ ] One\n\n``go\nx := 1\n``\n\n!!note\nHeads up.\n!!"
+	want := "## *Title*\n\nmacro [link](https://example.com) and `code`.\n\n- [ ] One\n\n```go\nx := 1\n```\n\n> [!NOTE]\n> Heads up."
+	var got []CallbackMessage
+	callback := func(message CallbackMessage) { got = append(got, message) }
+	assert.Equal(t, want, RenderMarkdown(Parse(source, RenderOptions{Reset: true}), callback))
+	assert.Equal(t, 0, len(got))
+	// Elements without a Markdown equivalent are rendered as HTML.
+	source = "/x/='<mark>x</mark>'\n.lead\nx\n\nterm:: def"
+	want = "<p class=\"lead\"><mark>x</mark></p>\n\n<dl><dt>term</dt><dd>def</dd></dl>"
+	assert.Equal(t, want, RenderMarkdown(Parse(source, RenderOptions{Reset: true}), callback))
+	assert.Equal(t, 2, len(got))
+	assert.Equal(t, UnsupportedMarkdown, got[0].Code)
+	assert.Equal(t, Warning, got[0].Kind)
+	assert.Equal(t, "paragraph attributes rendered as HTML", got[0].Text)
+	assert.Equal(t, "definition list rendered as HTML", got[1].Text)
+}
+
+func TestCallbackPositions(t *testing.T) {
+	source := "Line 1\n{a} here\n\n# Header {b}\n\n..\nPara  {c}\n..\n\n- item\n  and {d}\n\n{m}='one\n{e}'\n{m}\n\n.foo +bad\nText\n\nNote[^x]\n\n[^z]: Unused\n\n``\ncode"
+	want := []string{
+		"2:1: undefined macro: {a}",
+		"4:10: undefined macro: {b}",
+		"7:7: undefined macro: {c}",
+		"11:7: undefined macro: {d}",
+		"14:1: undefined macro: {e}",
+		"15:1: undefined macro: {e}", // Expanded macro line.
+		"17:6: illegal block option: +bad",
+		"24:1: unterminated code block: ``",
+		"20:5: undefined footnote: [^x]", // Footnotes are reported at the end of the document.
+		"22:1: unused footnote: [^z]",
+	}
+	for _, render := range []func(opts RenderOptions){
+		func(opts RenderOptions) { NewRenderer().Render(source, opts) },
+		func(opts RenderOptions) { NewRenderer().RenderTo(io.Discard, strings.NewReader(source), opts) },
+	} {
+		var got []string
+		render(RenderOptions{Callback: func(message CallbackMessage) {
+			text := strings.SplitN(message.Text, ": ", 3)
+			got = append(got, fmt.Sprintf("%d:%d: %s: %s", message.Line, message.Column, text[0], text[1]))
+		}})
+		assert.EqualValues(t, want, got)
+	}
+}
+
+func TestCallbackCodes(t *testing.T) {
+	tests := []struct {
+		source string
+		code   string
+		kind   string
+	}{
+		{"{x}", UndefinedMacro, Error},
+		{"{x?y}", DeprecatedSyntax, Warning},
+		{"{x}='1'\n{x=[}", IllegalRegExp, Error},
+		{"{--}='x'", IllegalMacroDefinition, Error},
+		{".#a\nfoo\n\n.#a\nbar", DuplicateID, Error},
+		{".-zacros\nfoo", IllegalBlockOption, Error},
+		{"|foo|='<p>|</p>'", IllegalBlockDefinition, Error},
+		{".foo='bar'", IllegalApiOption, Error},
+		{"/x/='$2'\nx", UndefinedReplacementGroup, Error},
+		{"/[/='x'", IllegalRegExp, Error},
+		{"..\nfoo", UnterminatedBlock, Error},
+		{"\xbb", InvalidUTF8, Error},
+		{"[^x]", UndefinedFootnote, Error},
+		{"[^x]: y", UnusedFootnote, Warning},
+	}
+	for _, tt := range tests {
+		var got []CallbackMessage
+		NewRenderer().Render(tt.source, RenderOptions{Callback: func(message CallbackMessage) { got = append(got, message) }})
+		assert.Equal(t, 1, len(got))
+		assert.Equal(t, tt.code, got[0].Code)
+		assert.Equal(t, tt.kind, got[0].Kind)
+	}
+}
+
+func TestFromMarkdown(t *testing.T) {
+	source := FromMarkdown("Title\n=====\n\n* See [the docs][docs].\n  - `x`\n\n[docs]: https://example.com/docs")
+	assert.Equal(t, "# Title\n\n- See <https://example.com/docs|the docs>.\n  * `x`\n", source)
+	want := "<h1>Title</h1>\n<ul><li>See <a href=\"https://example.com/docs\">the docs</a>.<ul><li><code>x</code></li></ul></li></ul>"
+	assert.Equal(t, want, Render(source, RenderOptions{Reset: true}))
+	// Markdown text that looks like Rimu markup is rendered literally. Character
+	// references are unescaped before comparison.
+	tests := []struct {
+		markdown string
+		want     string
+	}{
+		{"{x} = 'y'\n\nAfter", "<p>{x} = 'y'</p>\n<p>After</p>"},
+		{"{x} = 'y\n\nAfter '", "<p>{x} = 'y</p>\n<p>After '</p>"},
+		{"\\{x} = `y`", "<p>{x} = <code>y</code></p>"},
+		{"|x| = 'y'", "<p>|x| = 'y'</p>"},
+		{"/x/ = 'y'", "<p>/x/ = 'y'</p>"},
+		{"<<#x>>", "<p><<#x>></p>"},
+		{"See <<#x>> and <a|b> and <#y>", "<p>See <<#x>> and <a|b> and <#y></p>"},
+		{"\\>> x", "<p>>> x</p>"},
+		{"\\> x", "<p>> x</p>"},
+		{"\\-- x", "<p>-- x</p>"},
+		{"\\# x", "<p># x</p>"},
+		{"\\\\# x", "<p>\\# x</p>"},
+		{"\\[^x]: y", "<p>[^x]: y</p>"},
+		{"// x\n\n.lead\n\n.toc\n\nterm:: def\n\n|x|\n\n!!note x", "<p>// x</p>\n<p>.lead</p>\n<p>.toc</p>\n<p>term:: def</p>\n<p>|x|</p>\n<p>!!note x</p>"},
+	}
+	for _, tt := range tests {
+		var messages []CallbackMessage
+		got := Render(FromMarkdown(tt.markdown), RenderOptions{Reset: true, Callback: func(message CallbackMessage) { messages = append(messages, message) }})
+		assert.Equal(t, tt.want, html.UnescapeString(got))
+		assert.Equal(t, 0, len(messages))
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	tests := []struct {
+		source   string
+		want     string
+		warnings int
+	}{
+		{"Hello", "Hello", 0},
+		{"<<#x1>>\nText", ".#x1\n..\n..\nText", 1},
+		{"--\nA -- B\n--", "``\nA -- B\n``", 1},
+		{"```\n--\n```", "```\n--\n```", 0},
+		{"- Item\n---\ncode\n---", "- Item\n```\ncode\n```", 1},
+		{"Para\n\n{x?a} and {y?b}\n{x?a}", "{x?}='a'\n{y?}='b'\nPara\n\n{x} and {y}\n{x}", 3},
+		{"{x} = `1 + 1`\n{x}", "{x} = '1 + 1'\n{x}", 1},
+		{"{x} = `1\n+ 1`\n{x}", "{x} = '1\n+ 1'\n{x}", 1},
+		{"{x} = `1 '\n+ 1`", "{x} = `1 '\n+ 1`", 1}, // Not migrated.
+		{"..\n--\ncode\n--\n..", "..\n``\ncode\n``\n..", 1},
+	}
+	for _, tt := range tests {
+		warnings := 0
+		got := NewRenderer().Migrate(tt.source, RenderOptions{Callback: func(message CallbackMessage) {
+			assert.Equal(t, DeprecatedSyntax, message.Code)
+			warnings++
+		}})
+		assert.Equal(t, tt.want, got)
+		assert.Equal(t, tt.warnings, warnings)
+		if got != tt.source {
+			// Migrated source renders without warnings.
+			warnings = 0
+			NewRenderer().Render(got, RenderOptions{Callback: func(message CallbackMessage) { warnings++ }})
+			assert.Equal(t, 0, warnings)
+		}
+	}
+	// Definitions are inserted after the front matter.
+	for source, want := range map[string]string{
+		"---\ntitle: x\n---\n{x?a}":   "---\ntitle: x\n---\n{x?}='a'\n{x}",
+		"title: x\n\n\n{x?a}":         "title: x\n\n\n{x?}='a'\n{x}",
+		"---\ntitle: x\n---\n\n{x?a}": "---\ntitle: x\n---\n\n{x?}='a'\n{x}",
+	} {
+		assert.Equal(t, want, NewRenderer().Migrate(source, RenderOptions{FrontMatter: true}))
+	}
+	// Migrated source renders the same HTML (existential invocations were not
+	// expanded so they are excluded).
+	for _, source := range []string{"<<#x1>>\nText", "--\n<A>\n--", "{x} = `1 + 1`\n{x}"} {
+		assert.Equal(t, NewRenderer().Render(source, RenderOptions{}), NewRenderer().Render(Migrate(source, RenderOptions{Reset: true}), RenderOptions{}))
+	}
+}
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{"", ""},
+		{"\n\n", ""},
+		{"Hello", "Hello\n"},
+		{"Hello\n\n\n\nWorld\n\n\n", "Hello\n\nWorld\n\n"},                      // The trailing blank line is rendered as a newline.
+		{"= Title =\n==  Section\n### Sub ###", "# Title\n## Section\n### Sub"}, // Appending a newline would change the rendered HTML.
+		{"\\= Not a header", "\\= Not a header\n"},
+		{"* One\n** Two\n*** Three\n  * Four", "- One\n  * Two\n    ** Three\n- Four\n"},
+		{"1. One\n.. Two\n- Three\n2. Four", "1. One\n  .. Two\n    - Three\n2. Four\n"},
+		{"Term::   Definition\n  Sub:::   Text", "Term:: Definition\n  Sub::: Text\n"},
+		{"- One\n\n\n\n\n- Two", "- One\n\n\n- Two\n"},
+		{"``\ncode\n``\n{x} = 'v'\n## H", "``\ncode\n``\n{x} = 'v'\n## H"}, // Blank lines are not inserted between adjacent blocks.
+		{".....  note\nText\n.....\n", "..note\nText\n..\n"},
+		{"````\n``\n````\n\n\"\"\"\"\n..\n``\n``\n..\n\"\"\"\"\n", "```\n``\n```\n\n\"\"\n..\n``\n``\n..\n\"\"\n"},
+		{"!!!!note My Title\nText\n!!!!\n", "!!note My Title\nText\n!!\n"},
+		{".foo   #bar  \"color: red\"   [title=\"x\"]   +macros   -spans\nText", ".foo #bar \"color: red\" [title=\"x\"] +macros -spans\nText\n"},
+		{".{c}  #x\nText", ".{c}  #x\nText\n"}, // Macro references are not formatted.
+		{"{x}='1'\n{y}  =  `2`\n{z}='a\nb'\n{x}{y}{z}", "{x} = '1'\n{y} = '2'\n{z} = 'a\nb'\n{x}{y}{z}\n"},
+		{"/a/ = 'b'\n|code|='<pre>|</pre>'", "/a/ = 'b'\n|code|='<pre>|</pre>'\n"}, // Definitions are not changed.
+		{"``\n  code\n\n\n  ## not formatted\n``\n", "``\n  code\n\n\n  ## not formatted\n``\n"},
+		{"``\nUnterminated\n\n", "``\nUnterminated\n\n"},
+	}
+	for _, tt := range tests {
+		got := Format(tt.source, RenderOptions{})
+		assert.Equal(t, tt.want, got)
+		assert.Equal(t, got, Format(got, RenderOptions{}))
+	}
+	// Formatted test inputs render the same HTML and formatting is idempotent.
+	raw, err := ioutil.ReadFile("./testdata/rimu-tests.json")
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	var cases []renderTest
+	json.Unmarshal(raw, &cases)
+	for _, tt := range cases {
+		if strings.Contains(tt.Unsupported, "go") {
+			continue
+		}
+		opts := RenderOptions{
+			SafeMode:        tt.Options.SafeMode,
+			HtmlReplacement: tt.Options.HtmlReplacement,
+			Typography:      tt.Options.Typography,
+		}
+		got := Format(tt.Input, opts)
+		assert.Equal(t, NewRenderer().Render(tt.Input, opts), NewRenderer().Render(got, opts))
+		assert.Equal(t, got, Format(got, opts))
+	}
+}
+
+func TestRegisterDelimitedBlock(t *testing.T) {
+	r := NewRenderer()
+	// Admonition block with Rimu content.
+	err := r.RegisterDelimitedBlock(DelimitedBlockDefinition{
+		Name:       "admonition",
+		OpenMatch:  regexp.MustCompile(`^\\?:::\s*(\w+)$`),
+		CloseMatch: regexp.MustCompile(`^:::$`),
+		OpenTag:    "<div>",
+		CloseTag:   "</div>",
+		Options:    ExpansionOptions{Container: true},
+		DelimiterFilter: func(match []string, attrs *BlockAttributes) string {
+			attrs.Classes = strings.TrimSpace(match[1] + " " + attrs.Classes)
+			return ""
+		},
+	})
+	assert.True(t, err == nil)
+	// CSV table block.
+	err = r.RegisterDelimitedBlock(DelimitedBlockDefinition{
+		Name:       "csv",
+		OpenMatch:  regexp.MustCompile(`^~~~csv$`),
+		CloseMatch: regexp.MustCompile(`^~~~$`),
+		OpenTag:    "<table>",
+		CloseTag:   "</table>",
+		Before:     "code",
+		ContentFilter: func(text string, _ []string) string {
+			result := ""
+			for _, line := range strings.Split(text, "\n") {
+				result += "<tr><td>" + strings.Join(strings.Split(line, ","), "</td><td>") + "</td></tr>"
+			}
+			return result
+		},
+	})
+	assert.True(t, err == nil)
+	assert.Equal(t, `<div class="note"><p><em>Hi</em></p></div>`, r.Render(":::note\n*Hi*\n:::", RenderOptions{}))
+	assert.Equal(t, `<div class="note x"><p>Hi</p></div>`, r.Render(".x\n:::note\nHi\n:::", RenderOptions{}))
+	assert.Equal(t, "<p>:::note</p>", r.Render("\\:::note", RenderOptions{}))
+	assert.Equal(t, `<table><tr><td>a</td><td>b</td></tr><tr><td>1</td><td>2</td></tr></table>`, r.Render("~~~csv\na,b\n1,2\n~~~", RenderOptions{}))
+	// Registered blocks survive a reset.
+	assert.Equal(t, `<div class="note"><p>Hi</p></div>`, r.Render(":::note\nHi\n:::", RenderOptions{Reset: true}))
+	// Built-in block definitions can be applied to registered blocks.
+	assert.Equal(t, `<table class="t"><tr><td>a</td></tr></table>`, r.Render("|csv| = '<table class=\"t\">|</table>'\n~~~csv\na\n~~~", RenderOptions{}))
+	// Registered blocks replace built-in blocks with the same name.
+	assert.Equal(t, "<p>!!note\nHi\n!!</p>", r.Render("!!note\nHi\n!!", RenderOptions{Reset: true}))
+	assert.Contains(t, NewRenderer().Render("!!note\nHi\n!!", RenderOptions{}), `<aside class="admonition note"`)
+	// Illegal definitions.
+	assert.False(t, r.RegisterDelimitedBlock(DelimitedBlockDefinition{Name: "csv", OpenMatch: regexp.MustCompile(`^x$`)}) == nil)
+	assert.False(t, r.RegisterDelimitedBlock(DelimitedBlockDefinition{Name: "admonition", OpenMatch: regexp.MustCompile(`^x$`)}) == nil)
+	assert.False(t, r.RegisterDelimitedBlock(DelimitedBlockDefinition{Name: "paragraph", OpenMatch: regexp.MustCompile(`^x$`)}) == nil)
+	assert.False(t, r.RegisterDelimitedBlock(DelimitedBlockDefinition{Name: "quote", OpenMatch: regexp.MustCompile(`^x$`), Before: "quote"}) == nil)
+	assert.False(t, r.RegisterDelimitedBlock(DelimitedBlockDefinition{Name: "x"}) == nil)
+	assert.False(t, r.RegisterDelimitedBlock(DelimitedBlockDefinition{Name: "x", OpenMatch: regexp.MustCompile(`^x$`), Before: "missing"}) == nil)
+	assert.False(t, r.RegisterDelimitedBlock(DelimitedBlockDefinition{Name: "x", OpenMatch: regexp.MustCompile(`^x*`)}) == nil)
+	// Empty opening delimiter matches are ignored.
+	assert.True(t, r.RegisterDelimitedBlock(DelimitedBlockDefinition{Name: "empty", OpenMatch: regexp.MustCompile(`\b`)}) == nil)
+	assert.Equal(t, "<p>Hi</p>", r.Render("Hi", RenderOptions{}))
+	// Other renderers are unaffected.
+	assert.Equal(t, "<p>:::note\nHi\n:::</p>", NewRenderer().Render(":::note\nHi\n:::", RenderOptions{}))
+}
+
+func TestRegisterLineBlock(t *testing.T) {
+	r := NewRenderer()
+	err := r.RegisterLineBlock(LineBlockDefinition{
+		Name:  "youtube",
+		Match: regexp.MustCompile(`^\\?::: youtube (\w+)$`),
+		Filter: func(match []string) string {
+			return `<iframe src="https://www.youtube.com/embed/` + match[1] + `"></iframe>`
+		},
+	})
+	assert.True(t, err == nil)
+	err = r.RegisterLineBlock(LineBlockDefinition{
+		Name:   "contents",
+		Match:  regexp.MustCompile(`^\.contents$`),
+		Before: "attributes",
+		Filter: func(match []string) string {
+			return `<div class="contents"></div>`
+		},
+	})
+	assert.True(t, err == nil)
+	err = r.RegisterLineBlock(LineBlockDefinition{
+		Name:   "upper",
+		Match:  regexp.MustCompile(`^!(.+)$`),
+		Verify: func(match []string) bool { return match[1] != "!" },
+		Filter: func(match []string) string { return "<p>" + strings.ToUpper(match[1]) + "</p>" },
+	})
+	assert.True(t, err == nil)
+	assert.Equal(t, `<iframe src="https://www.youtube.com/embed/abc"></iframe>`, r.Render("::: youtube abc", RenderOptions{}))
+	assert.Equal(t, `<iframe id="v1" src="https://www.youtube.com/embed/abc"></iframe>`, r.Render(".#v1\n::: youtube abc", RenderOptions{}))
+	assert.Equal(t, "<p>::: youtube abc</p>", r.Render("\\::: youtube abc", RenderOptions{}))
+	assert.Equal(t, `<div class="contents"></div>`+"\n<h1>Title</h1>", r.Render(".contents\n# Title", RenderOptions{}))
+	assert.Equal(t, "<p>HI</p>", r.Render("{x}='hi'\n!{x}", RenderOptions{}))
+	assert.Equal(t, "<p>!!</p>", r.Render("!!", RenderOptions{}))
+	// Illegal definitions.
+	assert.False(t, r.RegisterLineBlock(LineBlockDefinition{Name: "contents", Match: regexp.MustCompile(`^x$`)}) == nil)
+	assert.False(t, r.RegisterLineBlock(LineBlockDefinition{Name: "x"}) == nil)
+	assert.False(t, r.RegisterLineBlock(LineBlockDefinition{Name: "x", Match: regexp.MustCompile(`^x$`), Before: "missing"}) == nil)
+	assert.False(t, r.RegisterLineBlock(LineBlockDefinition{Name: "x", Match: regexp.MustCompile(`^x*`)}) == nil)
+	// Empty matches are ignored.
+	assert.True(t, r.RegisterLineBlock(LineBlockDefinition{Name: "empty", Match: regexp.MustCompile(`\b`)}) == nil)
+	assert.Equal(t, "<p>Hi</p>", r.Render("Hi", RenderOptions{}))
+	// Other renderers are unaffected.
+	assert.Equal(t, "<p>::: youtube abc</p>", NewRenderer().Render("::: youtube abc", RenderOptions{}))
+}
+
+func TestRegisterReplacement(t *testing.T) {
+	r := NewRenderer()
+	issues := map[string]string{"PROJ-123": "Fix the build"}
+	err := r.RegisterReplacement(`\\?\b(PROJ-\d+)\b`, func(groups []string) string {
+		return `<a href="https://example.com/` + groups[1] + `" title="` + issues[groups[1]] + `">` + groups[1] + `</a>`
+	})
+	assert.True(t, err == nil)
+	assert.Equal(t, `<p>See <a href="https://example.com/PROJ-123" title="Fix the build">PROJ-123</a>.</p>`, r.Render("See PROJ-123.", RenderOptions{}))
+	assert.Equal(t, `<p>See PROJ-123.</p>`, r.Render("See \\PROJ-123.", RenderOptions{}))
+	assert.Equal(t, `<p><code>PROJ-123</code></p>`, r.Render("`PROJ-123`", RenderOptions{}))
+	// Registered replacements survive a reset.
+	assert.Equal(t, `<p><a href="https://example.com/PROJ-1" title="">PROJ-1</a></p>`, r.Render("PROJ-1", RenderOptions{Reset: true}))
+	assert.False(t, r.RegisterReplacement(`(`, func(groups []string) string { return "" }) == nil)
+	assert.False(t, r.RegisterReplacement(`x*`, func(groups []string) string { return "" }) == nil)
+	assert.Equal(t, `<p>xx</p>`, r.Render("xx", RenderOptions{}))
+	assert.Equal(t, `<p>PROJ-1</p>`, NewRenderer().Render("PROJ-1", RenderOptions{}))
+}
+
+func TestMacrosOption(t *testing.T) {
+	r := NewRenderer()
+	opts := RenderOptions{Macros: map[string]string{"version": "1.2.3", "author?": "Joe"}}
+	assert.Equal(t, "<p>1.2.3 Joe</p>", r.Render("{version} {author}", opts))
+	// Existential document definitions do not override preloaded values.
+	assert.Equal(t, "<p>Joe</p>", r.Render("{author?}='Kim'\n{author}", RenderOptions{Reset: true, Macros: map[string]string{"author": "Joe"}}))
+	assert.Equal(t, "<p>Kim</p>", NewRenderer().Render("{author}='Kim'\n{author}", RenderOptions{Macros: map[string]string{"author": "Joe"}}))
+	// Preloaded values are set after a reset and regardless of safe mode.
+	assert.Equal(t, "<p>x</p>", r.Render("{v}", RenderOptions{Reset: true, SafeMode: 1, Macros: map[string]string{"v": "x"}}))
+	// Read back the macro table.
+	r = NewRenderer()
+	r.Render("{title}='Hello'", RenderOptions{Macros: map[string]string{"build": "42"}})
+	macros := r.Macros()
+	assert.Equal(t, "Hello", macros["title"])
+	assert.Equal(t, "42", macros["build"])
+	assert.Equal(t, "", macros["--header-ids"])
+	macros["title"] = "changed"
+	assert.Equal(t, "Hello", r.Macros()["title"])
+	// Illegal macro names.
+	var messages []CallbackMessage
+	NewRenderer().Render("", RenderOptions{Macros: map[string]string{"a b": "x"}, Callback: func(message CallbackMessage) {
+		messages = append(messages, message)
+	}})
+	assert.Equal(t, 1, len(messages))
+	assert.Equal(t, IllegalMacroDefinition, messages[0].Code)
+}
+
+func TestInclude(t *testing.T) {
+	fsys := fstest.MapFS{
+		"doc.rmu":            {Data: []byte(".include 'inc/a.rmu'\nEnd")},
+		"inc/a.rmu":          {Data: []byte("# A\n.include 'b.rmu'")},
+		"inc/b.rmu":          {Data: []byte("{x}='B'\n*{x}*")},
+		"inc/cycle.rmu":      {Data: []byte(".include '../loop.rmu'")},
+		"loop.rmu":           {Data: []byte(".include 'inc/cycle.rmu'")},
+		"docs/self.rmu":      {Data: []byte(".include 'self.rmu'\nSelf")},
+		"top.rmu":            {Data: []byte(".include '/inc/b.rmu'")},
+		"deep.rmu":           {Data: []byte(".include 'deep2.rmu'")},
+		"deep2.rmu":          {Data: []byte(".include 'deep3.rmu'")},
+		"deep3.rmu":          {Data: []byte("Deep")},
+		"inc/attributes.rmu": {Data: []byte("Para")},
+	}
+	tests := []struct {
+		source string
+		path   string
+		want   string
+		code   string
+	}{
+		{".include 'doc.rmu'", "", "<h1>A</h1>\n<p><em>B</em></p>\n<p>End</p>", ""},
+		{".include 'a.rmu'\n{x}", "inc/main.rmu", "<h1>A</h1>\n<p><em>B</em></p>\n<p>B</p>", ""},
+		{".include 'inc/b.rmu'", "/", "<p><em>B</em></p>", ""},
+		{".include 'top.rmu'", "", "<p><em>B</em></p>", ""},
+		{"{f}='deep'\n.include '{f}.rmu'", "", "<p>Deep</p>", ""},
+		{".cls\n.include 'inc/attributes.rmu'", "", `<p class="cls">Para</p>`, ""},
+		{"\\.include 'deep3.rmu'", "", "<p>.include 'deep3.rmu'</p>", ""},
+		{".include 'loop.rmu'", "", "", IllegalInclude},
+		{".include 'loop.rmu'", "loop.rmu", "", IllegalInclude},
+		{".include 'self.rmu'", "/docs/self.rmu", "", IllegalInclude},
+		{".include 'self.rmu'", "docs/../docs/self.rmu", "", IllegalInclude},
+		{".include 'missing.rmu'", "", "", IllegalInclude},
+		{".include '../x.rmu'", "", "", IllegalInclude},
+	}
+	for _, tt := range tests {
+		code := ""
+		got := NewRenderer().Render(tt.source, RenderOptions{FS: fsys, Path: tt.path, Callback: func(message CallbackMessage) {
+			code = message.Code
+		}})
+		assert.Equal(t, tt.want, got)
+		assert.Equal(t, tt.code, code)
+	}
+	// Depth limit.
+	deep := fstest.MapFS{}
+	for i := 0; i < 20; i++ {
+		deep[fmt.Sprintf("%d.rmu", i)] = &fstest.MapFile{Data: []byte(fmt.Sprintf(".include '%d.rmu'", i+1))}
+	}
+	code := ""
+	NewRenderer().Render(".include '0.rmu'", RenderOptions{FS: deep, Callback: func(message CallbackMessage) {
+		code = message.Code
+		assert.True(t, strings.Contains(message.Text, "maximum include depth exceeded"))
+	}})
+	assert.Equal(t, IllegalInclude, code)
+	// Disabled in safe mode and when there is no file system.
+	assert.Equal(t, "", NewRenderer().Render(".include 'deep3.rmu'", RenderOptions{FS: fsys, SafeMode: 1}))
+	code = ""
+	NewRenderer().Render(".include 'deep3.rmu'", RenderOptions{Callback: func(message CallbackMessage) { code = message.Code }})
+	assert.Equal(t, IllegalInclude, code)
+}
+
+func TestFrontMatter(t *testing.T) {
+	r := NewRenderer()
+	source := "---\nTitle: Hello\nauthor: 'Joe'\n---\n# {meta-title}\n{meta-author}"
+	assert.Equal(t, "<h1>Hello</h1>\n<p>Joe</p>", r.Render(source, RenderOptions{FrontMatter: true}))
+	assert.Equal(t, "Hello", r.Metadata()["title"])
+	assert.Equal(t, "Hello", r.Macros()["meta-title"])
+	doc := r.Parse("title: Doc\n\nText", RenderOptions{})
+	assert.Equal(t, "Doc", doc.Metadata["title"])
+	assert.Equal(t, "<p>Text</p>", RenderHTML(doc))
+	// Line numbers are preserved.
+	line := 0
+	r.Render("title: x\n\n{undefined}", RenderOptions{Callback: func(message CallbackMessage) { line = message.Line }})
+	assert.Equal(t, 3, line)
+	// Streaming.
+	var b strings.Builder
+	assert.True(t, r.RenderTo(&b, strings.NewReader("title: Stream\n\n{meta-title}"), RenderOptions{}) == nil)
+	assert.Equal(t, "<p>Stream</p>", b.String())
+	// Front matter is not processed by default.
+	r = NewRenderer()
+	assert.Equal(t, "<p>title: x</p>", r.Render("title: x", RenderOptions{}))
+	assert.True(t, r.Metadata() == nil)
+	assert.Equal(t, "x", ParseFrontMatter("title: x\n\nText")["title"])
+	assert.True(t, ParseFrontMatter("Text") == nil)
+}
+
+func TestTableOfContents(t *testing.T) {
+	source := ".toc\n# A\n## B & C\n.#x\n## D\n# A"
+	want := `<nav><ul><li><a href="#a">A</a><ul><li><a href="#b-c">B &amp; C</a></li><li><a href="#x">D</a></li></ul></li><li><a href="#a-2">A</a></li></ul></nav>` +
+		"\n<h1 id=\"a\">A</h1>\n<h2 id=\"b-c\">B &amp; C</h2>\n<h2 id=\"x\">D</h2>\n<h1 id=\"a-2\">A</h1>"
+	r := NewRenderer()
+	assert.Equal(t, want, r.Render(source, RenderOptions{}))
+	assert.Equal(t, 4, len(r.Headings()))
+	assert.Equal(t, "B & C", r.Headings()[1].Text)
+	assert.Equal(t, "b-c", r.Headings()[1].ID)
+	assert.Equal(t, 2, r.Headings()[1].Level)
+	// Streaming.
+	var b strings.Builder
+	assert.True(t, NewRenderer().RenderTo(&b, strings.NewReader(source), RenderOptions{}) == nil)
+	assert.Equal(t, want, b.String())
+	// Block Attributes.
+	assert.Equal(t, `<nav class="contents"><ul><li><a href="#a">A</a></li></ul></nav>`+"\n<h1 id=\"a\">A</h1>", NewRenderer().Render(".contents\n.toc\n# A", RenderOptions{}))
+	// The --toc-html macro expands to the document's table of contents and is
+	// set to the most recently rendered document's table of contents.
+	r = NewRenderer()
+	want = `<p>Contents: <nav><ul><li><a href="#a">A</a></li></ul></nav></p>` + "\n<h1 id=\"a\">A</h1>"
+	assert.Equal(t, want, r.Render("Contents: {--toc-html}\n\n# A", RenderOptions{}))
+	assert.Equal(t, `<nav><ul><li><a href="#a">A</a></li></ul></nav>`, r.Macros()["--toc-html"])
+	b.Reset()
+	assert.True(t, NewRenderer().RenderTo(&b, strings.NewReader("Contents: {--toc-html}\n\n# A"), RenderOptions{}) == nil)
+	assert.Equal(t, want, b.String())
+	assert.Equal(t, "<h1>A</h1>", r.Render("# A", RenderOptions{}))
+	assert.Equal(t, "<nav><ul><li>A</li></ul></nav>", r.Macros()["--toc-html"])
+	assert.Equal(t, "", r.Render("{--toc-html}", RenderOptions{}))
+	assert.Equal(t, "", NewRenderer().Render(".toc", RenderOptions{}))
+}
+
+func TestHeaderNumbers(t *testing.T) {
+	source := "{--header-numbers}='true'\n# Title\n## A\n### A1\n### A2\n#### X\n## B\n### B1"
+	want := "<h1>Title</h1>\n<h2>1 A</h2>\n<h3>1.1 A1</h3>\n<h3>1.2 A2</h3>\n<h4>X</h4>\n<h2>2 B</h2>\n<h3>2.1 B1</h3>"
+	r := NewRenderer()
+	assert.Equal(t, want, r.Render(source, RenderOptions{}))
+	assert.Equal(t, "1.2", r.Headings()[3].Number)
+	assert.Equal(t, "A2", r.Headings()[3].Text)
+	assert.Equal(t, "1.2 A2", r.Headings()[3].Title())
+	// Numbers restart in each document.
+	assert.Equal(t, "<h2>1 C</h2>", r.Render("## C", RenderOptions{}))
+	// Start level, depth and separator.
+	source = "{--header-numbers}='true'\n{--header-numbers-start}='1'\n{--header-numbers-depth}='3'\n{--header-numbers-separator}='-'\n# A\n## B\n### C\n# D"
+	want = "<h1>1 A</h1>\n<h2>1-1 B</h2>\n<h3>1-1-1 C</h3>\n<h1>2 D</h1>"
+	assert.Equal(t, want, NewRenderer().Render(source, RenderOptions{}))
+	// Numbers are included in the table of contents but not in header ids.
+	source = "{--header-numbers}='true'\n.toc\n## A\n## B"
+	want = `<nav><ul><li><a href="#a">1 A</a></li><li><a href="#b">2 B</a></li></ul></nav>` + "\n<h2 id=\"a\">1 A</h2>\n<h2 id=\"b\">2 B</h2>"
+	assert.Equal(t, want, NewRenderer().Render(source, RenderOptions{}))
+	// Cross-references to numbered headers have the header's title as link text.
+	source = "{--header-numbers}='true'\nSee <#b> and <#a>.\n\n.#a\n## A\n.#b\n## B & C\n<#a> \\<#a> <#x>"
+	want = `<p>See <a href="#b">2 B &amp; C</a> and <a href="#a">1 A</a>.</p>` + "\n" +
+		`<h2 id="a">1 A</h2>` + "\n" + `<h2 id="b">2 B &amp; C</h2>` + "\n" +
+		`<p><a href="#a">1 A</a> &lt;#a&gt; <a href="#x">#x</a></p>`
+	assert.Equal(t, want, NewRenderer().Render(source, RenderOptions{}))
+	var b strings.Builder
+	assert.True(t, NewRenderer().RenderTo(&b, strings.NewReader(source), RenderOptions{}) == nil)
+	assert.Equal(t, want, b.String())
+	assert.Equal(t, `<p><a href="#a">#a</a></p>`+"\n"+`<h2 id="a">A</h2>`, NewRenderer().Render("<#a>\n\n.#a\n## A", RenderOptions{}))
+	// Illegal configuration.
+	var codes []string
+	r = NewRenderer()
+	source = "{--header-numbers}='true'\n{--header-numbers-depth}='x'\n## A\n## B"
+	got := r.Render(source, RenderOptions{Callback: func(message CallbackMessage) { codes = append(codes, message.Code) }})
+	assert.Equal(t, "<h2>1 A</h2>\n<h2>2 B</h2>", got)
+	assert.Equal(t, 1, len(codes)) // Reported once per document.
+	assert.Equal(t, IllegalMacroDefinition, codes[0])
+	r.Render(source, RenderOptions{})
+	assert.Equal(t, 2, len(codes))
+}
+
+func TestTasks(t *testing.T) {
+	source := "- [ ] Build\n- [x] Test *all*\n\n\n.. [x] Not a task"
+	want := `<ul><li class="task-list-item"><input type="checkbox" disabled> Build</li><li class="task-list-item"><input type="checkbox" checked disabled> Test <em>all</em></li></ul>` +
+		"<ol><li>[x] Not a task</li></ol>"
+	r := NewRenderer()
+	assert.Equal(t, want, r.Render(source, RenderOptions{}))
+	tasks := r.Tasks()
+	assert.Equal(t, 2, len(tasks))
+	assert.Equal(t, Task{Text: "Build", Done: false, Line: 1}, tasks[0])
+	assert.Equal(t, Task{Text: "Test all", Done: true, Line: 2}, tasks[1])
+	// Tasks are reset for each document.
+	r.Render("Text", RenderOptions{})
+	assert.Equal(t, 0, len(r.Tasks()))
+}
+
+func TestAdmonitions(t *testing.T) {
+	doc := Parse("!!warning Mind the *gap*\nText\n!!", RenderOptions{Reset: true})
+	assert.Equal(t, 1, len(doc.Children))
+	a := doc.Children[0].(*Admonition)
+	assert.Equal(t, AdmonitionWarning, a.Kind)
+	assert.Equal(t, "Mind the ", a.Title[0].(*Text).Text)
+	assert.Equal(t, "paragraph", a.Children[0].(*Paragraph).Name)
+	want := `<aside class="admonition warning"><p class="admonition-title">Mind the <em>gap</em></p>` + "\n<p>Text</p></aside>"
+	assert.Equal(t, want, RenderHTML(doc))
+	// Default title.
+	a = Parse("!!TIP\nText\n!!", RenderOptions{Reset: true}).Children[0].(*Admonition)
+	assert.Equal(t, AdmonitionTip, a.Kind)
+	assert.Equal(t, "Tip", a.Title[0].(*Text).Text)
+}
+
 func BenchmarkSmall(b *testing.B) {
 	text, err := ioutil.ReadFile("./testdata/benchmark-small.rmu")
 	if err != nil {
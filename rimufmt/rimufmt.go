@@ -0,0 +1,124 @@
+/*
+  Command-line app to format Rimu source.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/srackham/go-rimu/v11/internal/utils/stringlist"
+	"github.com/srackham/go-rimu/v11/rimu"
+)
+
+const STDIN = "-"
+
+const usage = `NAME
+  rimufmt - format Rimu source
+
+SYNOPSIS
+  rimufmt [OPTIONS...] [FILES...]
+
+DESCRIPTION
+  Reads Rimu source from stdin (or FILES) and writes it to stdout
+  in the canonical Rimu source format. An input file named '-'
+  is read from stdin.
+
+  Headers are written with '#' markers, list IDs and indents are
+  determined by list nesting, runs of blank lines are collapsed to
+  a single blank line (blank lines are not inserted between
+  adjacent blocks), delimiters are as short as possible and Block
+  Attributes and macro definitions are normalized. Formatted
+  source renders the same HTML as the original source.
+  Definitions are never reordered or changed.
+
+OPTIONS
+  --check
+    Do not write the formatted source, list the FILES that are
+    not formatted and exit with status 1 if there are any.
+
+  --front-matter
+    Skip front matter at the start of FILES.
+
+  -h, --help
+    Display help message.
+
+  -w, --write
+    Write the formatted source to FILES instead of stdout.
+`
+
+// Helpers.
+func die(message string) {
+	if message != "" {
+		fmt.Fprintln(os.Stderr, message)
+	}
+	os.Exit(1)
+}
+
+func main() {
+	args := stringlist.StringList(os.Args)
+	args.Shift() // Skip program name.
+	check := false
+	write := false
+	var opts rimu.RenderOptions
+outer:
+	for len(args) > 0 {
+		arg := args.Shift()
+		switch arg {
+		case "--help", "-h":
+			fmt.Print(usage)
+			os.Exit(0)
+		case "--check":
+			check = true
+		case "--write", "-w":
+			write = true
+		case "--front-matter":
+			opts.FrontMatter = true
+		default:
+			args.Unshift(arg) // argv contains source file names.
+			break outer
+		}
+	}
+	if check && write {
+		die("--check and --write options are mutually exclusive")
+	}
+	files := args
+	if len(files) == 0 {
+		files.Push(STDIN)
+	}
+	unformatted := 0
+	for _, infile := range files {
+		var data []byte
+		var err error
+		if infile == STDIN {
+			data, err = io.ReadAll(os.Stdin)
+		} else {
+			data, err = os.ReadFile(infile)
+		}
+		if err != nil {
+			die(err.Error())
+		}
+		source := string(data)
+		formatted := rimu.Format(source, opts)
+		switch {
+		case check:
+			if formatted != source {
+				fmt.Println(infile)
+				unformatted++
+			}
+		case write && infile != STDIN:
+			if formatted != source {
+				if err := os.WriteFile(infile, []byte(formatted), 0644); err != nil {
+					die(err.Error())
+				}
+			}
+		default:
+			fmt.Print(formatted)
+		}
+	}
+	if unformatted > 0 {
+		os.Exit(1)
+	}
+}
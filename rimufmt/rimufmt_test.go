@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/srackham/go-rimu/v11/internal/assert"
+)
+
+// rimufmt runs the installed rimufmt command and returns its output and exit code.
+func rimufmt(input string, args ...string) (string, int) {
+	cmd := exec.Command("rimufmt", args...)
+	cmd.Stdin = strings.NewReader(input)
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	exitCode := 0
+	if err := cmd.Run(); err != nil {
+		exitCode = 1
+	}
+	return out.String(), exitCode
+}
+
+func TestRimufmt(t *testing.T) {
+	out, code := rimufmt("= Title\n\n\n* One\n** Two")
+	assert.Equal(t, "# Title\n\n- One\n  * Two\n", out)
+	assert.Equal(t, 0, code)
+
+	out, code = rimufmt("", "--help")
+	assert.Contains(t, out, "rimufmt - format Rimu source")
+	assert.Equal(t, 0, code)
+
+	out, code = rimufmt("", "--check", "--write")
+	assert.Contains(t, out, "mutually exclusive")
+	assert.Equal(t, 1, code)
+
+	dir := t.TempDir()
+	formatted := filepath.Join(dir, "formatted.rmu")
+	unformatted := filepath.Join(dir, "unformatted.rmu")
+	os.WriteFile(formatted, []byte("# Title\n"), 0644)
+	os.WriteFile(unformatted, []byte("= Title\n"), 0644)
+	out, code = rimufmt("", "--check", formatted, unformatted)
+	assert.Equal(t, unformatted+"\n", out)
+	assert.Equal(t, 1, code)
+
+	out, code = rimufmt("", "--write", unformatted)
+	assert.Equal(t, "", out)
+	assert.Equal(t, 0, code)
+	data, _ := os.ReadFile(unformatted)
+	assert.Equal(t, "# Title\n", string(data))
+
+	out, code = rimufmt("", "--check", formatted, unformatted)
+	assert.Equal(t, "", out)
+	assert.Equal(t, 0, code)
+
+	out, code = rimufmt("---\ntitle:  x\n---\n= Title\n", "--front-matter")
+	assert.Equal(t, "---\ntitle:  x\n---\n# Title\n", out)
+	assert.Equal(t, 0, code)
+}
@@ -6,8 +6,10 @@ package main
 
 import (
 	"embed"
+	"encoding/json"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"os/user"
 	"path"
@@ -15,6 +17,7 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/srackham/go-rimu/v11/internal/utils/str"
 	"github.com/srackham/go-rimu/v11/internal/utils/stringlist"
 	"github.com/srackham/go-rimu/v11/rimu"
 )
@@ -62,6 +65,43 @@ func importLayoutFile(name string) string {
 	return ""
 }
 
+// diagnostic is the --diagnostics json output format.
+type diagnostic struct {
+	File     string `json:"file"`
+	Line     int    `json:"line,omitempty"`
+	Column   int    `json:"column,omitempty"`
+	Severity string `json:"severity"`
+	Code     string `json:"code"`
+	Message  string `json:"message"`
+}
+
+// includeFS returns the file system and the FS path of the named source file
+// that are used to resolve the file's include elements.
+func includeFS(name string) (fs.FS, string) {
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		die(err.Error())
+	}
+	root := filepath.VolumeName(abs) + string(filepath.Separator)
+	return os.DirFS(root), filepath.ToSlash(abs[len(root):])
+}
+
+// writeJSONDiagnostic writes a callback message to stderr as a single line JSON object.
+func writeJSONDiagnostic(file string, message rimu.CallbackMessage) {
+	data, err := json.Marshal(diagnostic{
+		File:     file,
+		Line:     message.Line,
+		Column:   message.Column,
+		Severity: message.Kind,
+		Code:     message.Code,
+		Message:  message.Text,
+	})
+	if err != nil {
+		panic("diagnostic: " + err.Error())
+	}
+	fmt.Fprintln(os.Stderr, string(data))
+}
+
 func main() {
 	args := stringlist.StringList(os.Args)
 	args.Shift() // Skip program name.
@@ -77,12 +117,24 @@ func main() {
 	noRimurc := false
 	var prependFiles stringlist.StringList
 	pass := false
+	migrate := false
+	frontMatter := false
+	typography := false
+	from := "rimu"
+	to := "html"
+	width := 72
+	var layoutOptions stringlist.StringList // Layout options specified on the command line.
 	// Parse command-line options.
 	prepend := ""
 	outfile := ""
+	diagnostics := "text"
 outer:
 	for len(args) > 0 {
 		arg := args.Shift()
+		if strings.HasPrefix(arg, "--diagnostics=") {
+			args.Unshift(strings.TrimPrefix(arg, "--diagnostics="))
+			arg = "--diagnostics"
+		}
 		switch arg {
 		case "--help", "-h":
 			fmt.Printf("\n" + readResourceFile("manpage.txt") + "\n")
@@ -96,6 +148,34 @@ outer:
 			outfile = nextArg("missing --output file name")
 		case "--pass":
 			pass = true
+		case "--migrate":
+			migrate = true
+		case "--front-matter":
+			frontMatter = true
+		case "--typography":
+			typography = true
+		case "--from":
+			from = nextArg("missing --from value")
+			if from != "rimu" && from != "markdown" {
+				die("illegal --from option value: " + from)
+			}
+		case "--to":
+			to = nextArg("missing --to value")
+			if to != "html" && to != "text" && to != "markdown" && to != "rimu" {
+				die("illegal --to option value: " + to)
+			}
+		case "--width":
+			s := nextArg("missing --width value")
+			n, err := strconv.ParseInt(s, 10, strconv.IntSize)
+			if err != nil || n < 0 {
+				die("illegal --width option value: " + s)
+			}
+			width = int(n)
+		case "--diagnostics":
+			diagnostics = nextArg("missing --diagnostics value")
+			if diagnostics != "text" && diagnostics != "json" {
+				die("illegal --diagnostics option value: " + diagnostics)
+			}
 		case "--prepend", "-p":
 			prepend += nextArg("missing --prepend value") + "\n"
 		case "--prepend-file":
@@ -114,7 +194,8 @@ outer:
 			"--htmlReplacement": // Deprecated in Rimu 7.1.0.
 			htmlReplacement = nextArg("missing --html-replacement value")
 			// Styling macro definitions shortcut options.
-		case "--highlightjs",
+		case "--highlight",
+			"--highlightjs",
 			"--mathjax",
 			"--section-numbers",
 			"--theme",
@@ -126,7 +207,9 @@ outer:
 			"--dropdown-toc", // Deprecated in Rimu 10.0.0
 			"--custom-toc",
 			"--header-ids",
-			"--header-links":
+			"--header-links",
+			"--header-numbers":
+			layoutOptions.Push(arg)
 			macroValue := ""
 			if strings.Contains("--lang|--title|--theme", arg) {
 				macroValue = nextArg("missing " + arg + " value")
@@ -147,6 +230,12 @@ outer:
 			break outer
 		}
 	}
+	if to == "rimu" && from != "markdown" {
+		die("--to rimu option requires --from markdown")
+	}
+	if to != "html" && layout != "" {
+		die("--layout option is not valid with --to " + to)
+	}
 	// args contains the list of source files.
 	files := args
 	if len(files) == 0 {
@@ -156,9 +245,10 @@ outer:
 		ext := path.Ext(files[0])
 		outfile = files[0][:len(files[0])-len(ext)] + ".html"
 	}
-	const RESOURCE_TAG = "resource:"    // Tag for resource files.
-	const PREPEND = "--prepend options" // Tag for --prepend source.
-	if layout != "" {
+	sources := append(stringlist.StringList{}, files...) // Migrated source files.
+	const RESOURCE_TAG = "resource:"                     // Tag for resource files.
+	const PREPEND = "--prepend options"                  // Tag for --prepend source.
+	if layout != "" && !migrate {
 		// Envelope source files with header and footer.
 		files.Unshift(RESOURCE_TAG + layout + "-header.rmu")
 		files.Push(RESOURCE_TAG + layout + "-footer.rmu")
@@ -171,15 +261,51 @@ outer:
 		prependFiles.Push(PREPEND)
 	}
 	files = append(prependFiles, files...)
+	// Stdin is read once.
+	stdin := ""
+	stdinRead := false
+	readStdin := func() string {
+		if !stdinRead {
+			bytes, _ := io.ReadAll(os.Stdin)
+			stdin = string(bytes)
+			stdinRead = true
+		}
+		return stdin
+	}
+	var opts rimu.RenderOptions
+	if frontMatter && layout != "" && !migrate {
+		// Layout title and lang options from the first source front matter that
+		// defines them. Explicit definitions take precedence.
+		opts.Macros = map[string]string{}
+		for _, infile := range sources {
+			if strings.HasSuffix(infile, ".html") || (pass && infile == STDIN) {
+				continue
+			}
+			source := ""
+			if infile == STDIN {
+				source = readStdin()
+			} else if bytes, err := os.ReadFile(infile); err == nil {
+				source = string(bytes)
+			}
+			meta := rimu.ParseFrontMatter(source)
+			for _, key := range []string{"title", "lang"} {
+				name := "--" + key
+				if _, ok := opts.Macros[name]; !ok && meta[key] != "" && !layoutOptions.Contains(name) {
+					opts.Macros[name] = str.ReplaceSpecialChars(meta[key])
+				}
+			}
+		}
+	}
 	// Convert Rimu source files to HTML.
 	output := ""
 	errors := 0
-	var opts rimu.RenderOptions
 	if htmlReplacement != nil {
 		opts.HtmlReplacement = htmlReplacement
 	}
 	for _, infile := range files {
 		var source string
+		// Stdin and --prepend source includes are resolved relative to the current directory.
+		opts.FS, opts.Path = includeFS(STDIN)
 		switch {
 		case strings.HasPrefix(infile, RESOURCE_TAG):
 			infile = infile[len(RESOURCE_TAG):]
@@ -190,8 +316,7 @@ outer:
 			}
 			opts.SafeMode = 0 // Resources are trusted.
 		case infile == STDIN:
-			bytes, _ := io.ReadAll(os.Stdin)
-			source = string(bytes)
+			source = readStdin()
 			opts.SafeMode = safeMode
 		case infile == PREPEND:
 			source = prepend
@@ -205,6 +330,7 @@ outer:
 				die(err.Error())
 			}
 			source = string(bytes)
+			opts.FS, opts.Path = includeFS(infile)
 			// Prepended and ~/.rimurc files are trusted.
 			if prependFiles.IndexOf(infile) > -1 {
 				opts.SafeMode = 0
@@ -212,6 +338,18 @@ outer:
 				opts.SafeMode = safeMode
 			}
 		}
+		opts.FrontMatter = frontMatter && sources.IndexOf(infile) >= 0 && !strings.HasPrefix(infile, RESOURCE_TAG)
+		opts.Typography = typography && sources.IndexOf(infile) >= 0 && !strings.HasPrefix(infile, RESOURCE_TAG)
+		if from == "markdown" && sources.IndexOf(infile) >= 0 && !strings.HasSuffix(infile, ".html") && !(pass && infile == STDIN) {
+			source = rimu.FromMarkdown(source)
+		}
+		if to == "rimu" {
+			// Only converted source files are output.
+			if sources.IndexOf(infile) >= 0 {
+				output += strings.TrimSpace(source) + "\n\n"
+			}
+			continue
+		}
 		// Skip .html and pass-through inputs.
 		if !(strings.HasSuffix(infile, ".html") || (pass && infile == STDIN)) {
 			opts.Callback = func(message rimu.CallbackMessage) {
@@ -219,26 +357,65 @@ outer:
 				if infile == STDIN {
 					f = "/dev/stdin"
 				}
-				msg := message.Kind + ": " + f + ": " + message.Text
-				if len(msg) > 120 {
-					msg = msg[:117] + "..."
+				if diagnostics == "json" {
+					writeJSONDiagnostic(f, message)
+				} else {
+					// Format: file:line:col: kind: message
+					if message.Line > 0 {
+						f += fmt.Sprintf(":%d:%d", message.Line, message.Column)
+					}
+					msg := f + ": " + message.Kind + ": " + message.Text
+					if len(msg) > 120 {
+						msg = msg[:117] + "..."
+					}
+					fmt.Fprintln(os.Stderr, msg)
 				}
-				fmt.Fprintln(os.Stderr, msg)
-				if message.Kind == "error" {
+				if message.Kind == rimu.Error {
 					errors++
 				}
 			}
-			source = rimu.Render(source, opts)
+			if migrate {
+				// Migrate source files in place (stdin is migrated to stdout).
+				// Other inputs are rendered for their definitions.
+				if sources.IndexOf(infile) >= 0 && !strings.HasPrefix(infile, RESOURCE_TAG) {
+					migrated := rimu.Migrate(source, opts)
+					if infile == STDIN {
+						fmt.Print(migrated)
+					} else if migrated != source {
+						if err := os.WriteFile(infile, []byte(migrated), 0644); err != nil {
+							die(err.Error())
+						}
+					}
+				} else {
+					rimu.Render(source, opts)
+				}
+				continue
+			}
+			switch to {
+			case "text":
+				source = rimu.RenderText(rimu.Parse(source, opts), width)
+			case "markdown":
+				source = rimu.RenderMarkdown(rimu.Parse(source, opts), opts.Callback)
+			default:
+				source = rimu.Render(source, opts)
+			}
+			opts.Macros = nil // Front matter layout macros are preloaded once.
 		}
 		source = strings.TrimSpace(source)
 		if source != "" {
 			output += source + "\n"
+			if to != "html" {
+				output += "\n" // Separate text and Markdown blocks with a blank line.
+			}
 		}
 	}
 	output = strings.TrimSpace(output)
-	if outfile == "" || outfile == "-" {
+	switch {
+	case migrate:
+		// Migrated sources have already been written.
+	case outfile == "" || outfile == "-":
 		fmt.Print(output)
-	} else {
+	default:
 		err := os.WriteFile(outfile, []byte(output), 0644)
 		if err != nil {
 			die(err.Error())
@@ -0,0 +1,51 @@
+/*
+  Command-line app that runs the Rimu language server.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/srackham/go-rimu/v11/internal/lsp"
+)
+
+const usage = `NAME
+  rimulsp - Rimu language server
+
+SYNOPSIS
+  rimulsp [OPTIONS...]
+
+DESCRIPTION
+  Runs a Language Server Protocol (LSP) server for Rimu Markup.
+  The server reads JSON-RPC messages from stdin and writes them
+  to stdout.
+
+  Features: diagnostics, macro go-to-definition, hover and
+  rename, completion of macro names and block classes and a
+  document outline from headers.
+
+OPTIONS
+  -h, --help
+    Display help message.
+`
+
+func main() {
+	for _, arg := range os.Args[1:] {
+		switch arg {
+		case "--help", "-h":
+			fmt.Print(usage)
+			os.Exit(0)
+		case "--stdio":
+			// Accepted for compatibility with editor clients, stdio is the only transport.
+		default:
+			fmt.Fprintln(os.Stderr, "illegal option: "+arg)
+			os.Exit(1)
+		}
+	}
+	if err := lsp.Serve(os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+}
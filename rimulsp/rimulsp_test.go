@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/srackham/go-rimu/v11/internal/assert"
+)
+
+// rimulsp runs the installed rimulsp command and returns its output and exit code.
+func rimulsp(input string, args ...string) (string, int) {
+	cmd := exec.Command("rimulsp", args...)
+	cmd.Stdin = strings.NewReader(input)
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	exitCode := 0
+	if err := cmd.Run(); err != nil {
+		exitCode = 1
+	}
+	return out.String(), exitCode
+}
+
+func frame(body string) string {
+	return fmt.Sprintf("Content-Length: %d\r\n\r\n%s", len(body), body)
+}
+
+func TestRimulsp(t *testing.T) {
+	out, code := rimulsp("", "--help")
+	assert.Contains(t, out, "rimulsp - Rimu language server")
+	assert.Equal(t, 0, code)
+
+	out, code = rimulsp("", "--foo")
+	assert.Contains(t, out, "illegal option: --foo")
+	assert.Equal(t, 1, code)
+
+	out, code = rimulsp(frame(`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{}}`) +
+		frame(`{"jsonrpc":"2.0","id":2,"method":"shutdown"}`) +
+		frame(`{"jsonrpc":"2.0","method":"exit"}`))
+	assert.Contains(t, out, `"renameProvider":true`)
+	assert.Contains(t, out, `{"jsonrpc":"2.0","id":2,"result":null}`)
+	assert.Equal(t, 0, code)
+
+	out, code = rimulsp(frame(`{"jsonrpc":"2.0","method":"exit"}`))
+	assert.Contains(t, out, "exit notification received before shutdown request")
+	assert.Equal(t, 1, code)
+}